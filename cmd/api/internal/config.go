@@ -0,0 +1,289 @@
+package internal
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+	"github.com/spolia/wallet-api/internal/wallet/withdrawal"
+)
+
+// Config holds the settings of the API.
+type Config struct {
+	Session SessionConfig
+	Token   TokenConfig
+	// IdempotencyRetention is how long the responses of the requests with an idempotency key are kept
+	IdempotencyRetention time.Duration
+	Exchange             ExchangeConfig
+	// FeeRulesFile is a JSON file with the fee rules, without it the movements have no fees
+	FeeRulesFile string
+	// WithdrawalInterval is how often the withdrawals are sent to the payout provider and their status is checked
+	WithdrawalInterval time.Duration
+	Payout             PayoutConfig
+	// HoldMaxDuration is the default and the maximum duration of a hold
+	HoldMaxDuration time.Duration
+	// HoldExpiryInterval is how often the expired holds are marked as expired
+	HoldExpiryInterval time.Duration
+	// LedgerVerifyInterval is how often the ledger is verified, 0 disables the verification
+	LedgerVerifyInterval time.Duration
+}
+
+// ExchangeConfig configures the currency exchanges.
+type ExchangeConfig struct {
+	// RatesFile is a JSON file with the exchange rates, without it every exchange fails
+	RatesFile string
+	// QuoteTTL is how long the user has to confirm a quote
+	QuoteTTL time.Duration
+	// Spread is the fraction of the rate kept by the wallet in every conversion
+	Spread money.Amount
+}
+
+// PayoutConfig selects the payout provider that pays the withdrawals.
+type PayoutConfig struct {
+	// Provider is the name of the payout provider, the API does not start without one
+	Provider string
+	// AllowFake lets the API use the fake provider, that settles every withdrawal without paying it
+	AllowFake bool
+}
+
+// PayoutFake is the name of the fake payout provider, only for development and tests
+const PayoutFake = "fake"
+
+// ConfigFromEnv builds the API configuration from environment variables:
+//   - SESSION_KEYS: comma separated list of base64 "hashkey:blockkey" pairs, the first one signs new cookies.
+//     The block key is optional; when it is missing cookies are signed but not encrypted.
+//   - SESSION_COOKIE_SECURE: true by default.
+//   - SESSION_COOKIE_HTTPONLY: true by default.
+//   - SESSION_COOKIE_SAMESITE: lax (default), strict or none.
+//   - SESSION_MAX_AGE: session cookie duration like "24h" (default), 0 means until the browser is closed.
+//   - SESSION_IDLE_TIMEOUT: sessions not used for this long are revoked, "30m" by default.
+//   - SESSION_ABSOLUTE_TIMEOUT: sessions older than this are revoked, "24h" by default.
+//   - TOKEN_KEYS: comma separated list of base64 keys used to sign the bearer tokens, the first one signs new tokens.
+//   - TOKEN_ACCESS_TTL: access token duration, "15m" by default.
+//   - TOKEN_REFRESH_TTL: refresh token duration, "24h" by default.
+//   - IDEMPOTENCY_RETENTION: how long an idempotency key can not be reused, "24h" by default.
+//   - EXCHANGE_RATES_FILE: JSON file with the exchange rates like {"USDT/ARS": "350.25"}.
+//   - EXCHANGE_QUOTE_TTL: how long an exchange quote can be confirmed, "30s" by default.
+//   - EXCHANGE_SPREAD: fraction of the rate kept by the wallet like "0.01", 0 by default.
+//   - FEE_RULES_FILE: JSON file with the fee rules of the movements.
+//   - WITHDRAWAL_INTERVAL: how often the withdrawals are processed, "30s" by default, it must be positive.
+//   - PAYOUT_PROVIDER: the payout provider of the withdrawals, required.
+//   - PAYOUT_ALLOW_FAKE: true lets PAYOUT_PROVIDER be "fake" in development and tests, false by default.
+//   - HOLD_MAX_DURATION: default and maximum duration of a hold, "168h" by default.
+//   - HOLD_EXPIRY_INTERVAL: how often the expired holds are marked, "1m" by default, it must be positive.
+//   - LEDGER_VERIFY_INTERVAL: how often the ledger is verified, "24h" by default, 0 disables it.
+func ConfigFromEnv(getenv func(string) string) (Config, error) {
+	var config Config
+	var err error
+
+	if config.Session.Keys, err = parseKeyPairs(getenv("SESSION_KEYS")); err != nil {
+		return Config{}, err
+	}
+
+	if config.Session.Secure, err = parseBool(getenv("SESSION_COOKIE_SECURE"), true); err != nil {
+		return Config{}, fmt.Errorf("SESSION_COOKIE_SECURE: %w", err)
+	}
+
+	if config.Session.HTTPOnly, err = parseBool(getenv("SESSION_COOKIE_HTTPONLY"), true); err != nil {
+		return Config{}, fmt.Errorf("SESSION_COOKIE_HTTPONLY: %w", err)
+	}
+
+	switch strings.ToLower(getenv("SESSION_COOKIE_SAMESITE")) {
+	case "", "lax":
+		config.Session.SameSite = http.SameSiteLaxMode
+	case "strict":
+		config.Session.SameSite = http.SameSiteStrictMode
+	case "none":
+		config.Session.SameSite = http.SameSiteNoneMode
+	default:
+		return Config{}, fmt.Errorf("SESSION_COOKIE_SAMESITE: unknown mode %q", getenv("SESSION_COOKIE_SAMESITE"))
+	}
+
+	if config.Session.MaxAge, err = parseDuration(getenv("SESSION_MAX_AGE"), 24*time.Hour); err != nil {
+		return Config{}, fmt.Errorf("SESSION_MAX_AGE: %w", err)
+	}
+
+	if config.Session.IdleTimeout, err = parseDuration(getenv("SESSION_IDLE_TIMEOUT"), 30*time.Minute); err != nil {
+		return Config{}, fmt.Errorf("SESSION_IDLE_TIMEOUT: %w", err)
+	}
+
+	if config.Session.AbsoluteTimeout, err = parseDuration(getenv("SESSION_ABSOLUTE_TIMEOUT"), 24*time.Hour); err != nil {
+		return Config{}, fmt.Errorf("SESSION_ABSOLUTE_TIMEOUT: %w", err)
+	}
+
+	if config.Token.Keys, err = parseKeys(getenv("TOKEN_KEYS")); err != nil {
+		return Config{}, fmt.Errorf("TOKEN_KEYS: %w", err)
+	}
+
+	if config.Token.AccessTTL, err = parseDuration(getenv("TOKEN_ACCESS_TTL"), 15*time.Minute); err != nil {
+		return Config{}, fmt.Errorf("TOKEN_ACCESS_TTL: %w", err)
+	}
+
+	if config.Token.RefreshTTL, err = parseDuration(getenv("TOKEN_REFRESH_TTL"), 24*time.Hour); err != nil {
+		return Config{}, fmt.Errorf("TOKEN_REFRESH_TTL: %w", err)
+	}
+
+	if config.IdempotencyRetention, err = parseDuration(getenv("IDEMPOTENCY_RETENTION"), 24*time.Hour); err != nil {
+		return Config{}, fmt.Errorf("IDEMPOTENCY_RETENTION: %w", err)
+	}
+
+	config.Exchange.RatesFile = getenv("EXCHANGE_RATES_FILE")
+	if config.Exchange.QuoteTTL, err = parseDuration(getenv("EXCHANGE_QUOTE_TTL"), 30*time.Second); err != nil {
+		return Config{}, fmt.Errorf("EXCHANGE_QUOTE_TTL: %w", err)
+	}
+
+	if config.Exchange.Spread, err = parseSpread(getenv("EXCHANGE_SPREAD")); err != nil {
+		return Config{}, fmt.Errorf("EXCHANGE_SPREAD: %w", err)
+	}
+
+	config.FeeRulesFile = getenv("FEE_RULES_FILE")
+	if config.WithdrawalInterval, err = parseInterval(getenv("WITHDRAWAL_INTERVAL"), 30*time.Second); err != nil {
+		return Config{}, fmt.Errorf("WITHDRAWAL_INTERVAL: %w", err)
+	}
+
+	config.Payout.Provider = strings.ToLower(getenv("PAYOUT_PROVIDER"))
+	if config.Payout.AllowFake, err = parseBool(getenv("PAYOUT_ALLOW_FAKE"), false); err != nil {
+		return Config{}, fmt.Errorf("PAYOUT_ALLOW_FAKE: %w", err)
+	}
+
+	if config.HoldMaxDuration, err = parseDuration(getenv("HOLD_MAX_DURATION"), 7*24*time.Hour); err != nil {
+		return Config{}, fmt.Errorf("HOLD_MAX_DURATION: %w", err)
+	}
+
+	if config.HoldExpiryInterval, err = parseInterval(getenv("HOLD_EXPIRY_INTERVAL"), time.Minute); err != nil {
+		return Config{}, fmt.Errorf("HOLD_EXPIRY_INTERVAL: %w", err)
+	}
+
+	if config.LedgerVerifyInterval, err = parseDuration(getenv("LEDGER_VERIFY_INTERVAL"), 24*time.Hour); err != nil {
+		return Config{}, fmt.Errorf("LEDGER_VERIFY_INTERVAL: %w", err)
+	}
+	if config.LedgerVerifyInterval < 0 {
+		return Config{}, errors.New("LEDGER_VERIFY_INTERVAL: the interval can not be negative")
+	}
+
+	return config, nil
+}
+
+// NewPayout returns the payout provider of the configuration, it fails when there is no provider so the withdrawals
+// are never settled without being paid
+func NewPayout(config PayoutConfig) (withdrawal.Payout, error) {
+	switch config.Provider {
+	case "":
+		return nil, errors.New("PAYOUT_PROVIDER: no payout provider configured")
+	case PayoutFake:
+		if !config.AllowFake {
+			return nil, errors.New("PAYOUT_PROVIDER: the fake provider does not pay the withdrawals, set PAYOUT_ALLOW_FAKE=true to use it")
+		}
+
+		return withdrawal.FakePayout{}, nil
+	default:
+		return nil, fmt.Errorf("PAYOUT_PROVIDER: unknown payout provider %q", config.Provider)
+	}
+}
+
+func parseKeyPairs(value string) ([]KeyPair, error) {
+	var pairs []KeyPair
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		keys := strings.SplitN(v, ":", 2)
+		hashKey, err := base64.StdEncoding.DecodeString(keys[0])
+		if err != nil {
+			return nil, fmt.Errorf("SESSION_KEYS: decoding hash key %d: %w", len(pairs), err)
+		}
+		if len(hashKey) < 32 {
+			return nil, fmt.Errorf("SESSION_KEYS: hash key %d must have at least 32 bytes", len(pairs))
+		}
+
+		var blockKey []byte
+		if len(keys) == 2 {
+			if blockKey, err = base64.StdEncoding.DecodeString(keys[1]); err != nil {
+				return nil, fmt.Errorf("SESSION_KEYS: decoding block key %d: %w", len(pairs), err)
+			}
+			if l := len(blockKey); l != 16 && l != 24 && l != 32 {
+				return nil, fmt.Errorf("SESSION_KEYS: block key %d must have 16, 24 or 32 bytes", len(pairs))
+			}
+		}
+
+		pairs = append(pairs, KeyPair{HashKey: hashKey, BlockKey: blockKey})
+	}
+
+	return pairs, nil
+}
+
+func parseKeys(value string) ([][]byte, error) {
+	var keys [][]byte
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		key, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("decoding key %d: %w", len(keys), err)
+		}
+		if len(key) < 32 {
+			return nil, fmt.Errorf("key %d must have at least 32 bytes", len(keys))
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func parseSpread(value string) (money.Amount, error) {
+	if value == "" {
+		return money.Amount{}, nil
+	}
+
+	spread, err := money.Parse(value)
+	if err != nil {
+		return money.Amount{}, err
+	}
+
+	if spread.Sign() < 0 || spread.Cmp(money.MustParse("1")) >= 0 {
+		return money.Amount{}, fmt.Errorf("the spread must be between 0 and 1")
+	}
+
+	return spread, nil
+}
+
+func parseBool(value string, def bool) (bool, error) {
+	if value == "" {
+		return def, nil
+	}
+
+	return strconv.ParseBool(value)
+}
+
+func parseDuration(value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// parseInterval parses the interval of a background job, a job without a positive interval would never run
+func parseInterval(value string, def time.Duration) (time.Duration, error) {
+	interval, err := parseDuration(value, def)
+	if err != nil {
+		return 0, err
+	}
+
+	if interval <= 0 {
+		return 0, errors.New("the interval must be positive")
+	}
+
+	return interval, nil
+}
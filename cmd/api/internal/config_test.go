@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/withdrawal"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ConfigFromEnv_Defaults(t *testing.T) {
+	// When
+	config, err := ConfigFromEnv(func(string) string { return "" })
+
+	// Then
+	require.NoError(t, err)
+	require.Empty(t, config.Session.Keys)
+	require.True(t, config.Session.Secure)
+	require.True(t, config.Session.HTTPOnly)
+	require.Equal(t, http.SameSiteLaxMode, config.Session.SameSite)
+	require.Equal(t, 24*time.Hour, config.Session.MaxAge)
+	require.Equal(t, 30*time.Minute, config.Session.IdleTimeout)
+	require.Equal(t, 24*time.Hour, config.Session.AbsoluteTimeout)
+	require.Empty(t, config.Token.Keys)
+	require.Equal(t, 15*time.Minute, config.Token.AccessTTL)
+	require.Equal(t, 24*time.Hour, config.Token.RefreshTTL)
+	require.Empty(t, config.Exchange.RatesFile)
+	require.Equal(t, 30*time.Second, config.Exchange.QuoteTTL)
+	require.True(t, config.Exchange.Spread.IsZero())
+	require.Empty(t, config.FeeRulesFile)
+	require.Equal(t, 30*time.Second, config.WithdrawalInterval)
+	require.Empty(t, config.Payout.Provider)
+	require.False(t, config.Payout.AllowFake)
+	require.Equal(t, 7*24*time.Hour, config.HoldMaxDuration)
+	require.Equal(t, time.Minute, config.HoldExpiryInterval)
+	require.Equal(t, 24*time.Hour, config.LedgerVerifyInterval)
+}
+
+func Test_ConfigFromEnv(t *testing.T) {
+	// Given
+	hashKey := make([]byte, 32)
+	blockKey := make([]byte, 16)
+	oldHashKey := make([]byte, 64)
+	env := map[string]string{
+		"SESSION_KEYS": base64.StdEncoding.EncodeToString(hashKey) + ":" + base64.StdEncoding.EncodeToString(blockKey) +
+			"," + base64.StdEncoding.EncodeToString(oldHashKey),
+		"SESSION_COOKIE_SECURE":    "false",
+		"SESSION_COOKIE_HTTPONLY":  "false",
+		"SESSION_COOKIE_SAMESITE":  "Strict",
+		"SESSION_MAX_AGE":          "1h",
+		"SESSION_IDLE_TIMEOUT":     "5m",
+		"SESSION_ABSOLUTE_TIMEOUT": "2h",
+		"EXCHANGE_RATES_FILE":      "rates.json",
+		"EXCHANGE_QUOTE_TTL":       "1m",
+		"EXCHANGE_SPREAD":          "0.015",
+		"FEE_RULES_FILE":           "fees.json",
+		"WITHDRAWAL_INTERVAL":      "1m",
+		"PAYOUT_PROVIDER":          "Fake",
+		"PAYOUT_ALLOW_FAKE":        "true",
+		"HOLD_MAX_DURATION":        "24h",
+		"HOLD_EXPIRY_INTERVAL":     "10s",
+		"LEDGER_VERIFY_INTERVAL":   "0",
+	}
+
+	// When
+	config, err := ConfigFromEnv(func(key string) string { return env[key] })
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []KeyPair{{HashKey: hashKey, BlockKey: blockKey}, {HashKey: oldHashKey}}, config.Session.Keys)
+	require.False(t, config.Session.Secure)
+	require.False(t, config.Session.HTTPOnly)
+	require.Equal(t, http.SameSiteStrictMode, config.Session.SameSite)
+	require.Equal(t, time.Hour, config.Session.MaxAge)
+	require.Equal(t, 5*time.Minute, config.Session.IdleTimeout)
+	require.Equal(t, 2*time.Hour, config.Session.AbsoluteTimeout)
+	require.Equal(t, "rates.json", config.Exchange.RatesFile)
+	require.Equal(t, time.Minute, config.Exchange.QuoteTTL)
+	require.Equal(t, "0.015", config.Exchange.Spread.String())
+	require.Equal(t, "fees.json", config.FeeRulesFile)
+	require.Equal(t, time.Minute, config.WithdrawalInterval)
+	require.Equal(t, PayoutConfig{Provider: PayoutFake, AllowFake: true}, config.Payout)
+	require.Equal(t, 24*time.Hour, config.HoldMaxDuration)
+	require.Equal(t, 10*time.Second, config.HoldExpiryInterval)
+	require.Zero(t, config.LedgerVerifyInterval)
+}
+
+func Test_ConfigFromEnv_Fail(t *testing.T) {
+	tt := []struct {
+		TestName, Key, Value string
+	}{
+		{"ShortHashKey", "SESSION_KEYS", base64.StdEncoding.EncodeToString(make([]byte, 16))},
+		{"WrongBlockKeySize", "SESSION_KEYS", base64.StdEncoding.EncodeToString(make([]byte, 32)) + ":" + base64.StdEncoding.EncodeToString(make([]byte, 10))},
+		{"KeyNotBase64", "SESSION_KEYS", "not base64"},
+		{"WrongSecure", "SESSION_COOKIE_SECURE", "maybe"},
+		{"WrongSameSite", "SESSION_COOKIE_SAMESITE", "sometimes"},
+		{"WrongMaxAge", "SESSION_MAX_AGE", "one day"},
+		{"WrongIdleTimeout", "SESSION_IDLE_TIMEOUT", "a while"},
+		{"ShortTokenKey", "TOKEN_KEYS", base64.StdEncoding.EncodeToString(make([]byte, 16))},
+		{"WrongAccessTTL", "TOKEN_ACCESS_TTL", "soon"},
+		{"WrongWithdrawalInterval", "WITHDRAWAL_INTERVAL", "often"},
+		{"ZeroWithdrawalInterval", "WITHDRAWAL_INTERVAL", "0"},
+		{"NegativeWithdrawalInterval", "WITHDRAWAL_INTERVAL", "-1m"},
+		{"ZeroHoldExpiryInterval", "HOLD_EXPIRY_INTERVAL", "0"},
+		{"NegativeLedgerVerifyInterval", "LEDGER_VERIFY_INTERVAL", "-1h"},
+		{"WrongAllowFakePayout", "PAYOUT_ALLOW_FAKE", "sure"},
+		{"WrongHoldMaxDuration", "HOLD_MAX_DURATION", "a week"},
+		{"WrongLedgerVerifyInterval", "LEDGER_VERIFY_INTERVAL", "daily"},
+		{"NegativeSpread", "EXCHANGE_SPREAD", "-0.01"},
+		{"WholeSpread", "EXCHANGE_SPREAD", "1"},
+	}
+
+	for _, tc := range tt {
+		// When
+		_, err := ConfigFromEnv(func(key string) string {
+			if key == tc.Key {
+				return tc.Value
+			}
+			return ""
+		})
+
+		// Then
+		require.Error(t, err, tc.TestName)
+	}
+}
+
+func Test_NewPayout(t *testing.T) {
+	tt := []struct {
+		TestName      string
+		Config        PayoutConfig
+		ExpectedError bool
+	}{
+		{"Fake", PayoutConfig{Provider: PayoutFake, AllowFake: true}, false},
+		{"FakeNotAllowed", PayoutConfig{Provider: PayoutFake}, true},
+		{"Missing", PayoutConfig{AllowFake: true}, true},
+		{"Unknown", PayoutConfig{Provider: "bank"}, true},
+	}
+
+	for _, tc := range tt {
+		// When
+		payout, err := NewPayout(tc.Config)
+
+		// Then
+		if tc.ExpectedError {
+			require.Error(t, err, tc.TestName)
+			continue
+		}
+
+		require.NoError(t, err, tc.TestName)
+		require.Equal(t, withdrawal.FakePayout{}, payout, tc.TestName)
+	}
+}
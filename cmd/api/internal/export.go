@@ -0,0 +1,280 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+)
+
+const (
+	formatCSV   = "csv"
+	formatOFX   = "ofx"
+	formatJSONL = "jsonl"
+	// ofxTime is the date format of OFX, always in UTC
+	ofxTime = "20060102150405.000[0:GMT]"
+)
+
+var exportContentTypes = map[string]string{
+	formatCSV:   "text/csv",
+	formatOFX:   "application/x-ofx",
+	formatJSONL: "application/jsonl",
+}
+
+// historyEncoder writes the rows of an exported history
+type historyEncoder interface {
+	begin() error
+	encode(row movement.Row) error
+	end() error
+}
+
+// exportHistory streams the history of an account of the user between two dates as CSV, OFX or JSON Lines,
+// the rows are written while they are read
+func exportHistory(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format, ok := exportFormat(r)
+		if !ok {
+			http.Error(w, "the history can be exported as csv, ofx or jsonl", http.StatusNotAcceptable)
+			return
+		}
+
+		filter, err := decodeExportFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		alias := strings.ToLower(userAlias(r.Context()))
+		buffer := bufio.NewWriter(w)
+		var encoder historyEncoder
+		switch format {
+		case formatCSV:
+			encoder = &csvEncoder{w: csv.NewWriter(buffer)}
+		case formatJSONL:
+			encoder = &jsonlEncoder{enc: json.NewEncoder(buffer)}
+		case formatOFX:
+			encoder = &ofxEncoder{w: buffer, alias: alias, filter: filter, balance: func() (money.Amount, error) {
+				return service.GetBalanceAt(r.Context(), alias, filter.CurrencyName, filter.To)
+			}}
+		}
+
+		// the response starts with the first row, so an invalid request still gets its error status
+		started := false
+		start := func() error {
+			started = true
+			w.Header().Set("Content-Type", exportContentTypes[format])
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="history-%s-%s-%s.%s"`,
+				filter.CurrencyName, filter.From.Format("20060102"), filter.To.Format("20060102"), format))
+			return encoder.begin()
+		}
+
+		err = service.ExportHistory(r.Context(), alias, filter, func(row movement.Row) error {
+			if !started {
+				if err := start(); err != nil {
+					return err
+				}
+			}
+
+			return encoder.encode(row)
+		})
+		if err == nil && !started {
+			err = start()
+		}
+		if err == nil {
+			if err = encoder.end(); err == nil {
+				err = buffer.Flush()
+			}
+		}
+
+		if err != nil {
+			if !started {
+				if err == movement.ErrorWrongCurrency {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			// the status was already sent, the client gets a truncated file
+			log.Printf("exporting the history of %s: %v", alias, err)
+		}
+	}
+}
+
+// exportFormat returns the format of the format query parameter or else the first supported type of the
+// Accept header, CSV by default
+func exportFormat(r *http.Request) (string, bool) {
+	if format := strings.ToLower(r.URL.Query().Get("format")); format != "" {
+		_, ok := exportContentTypes[format]
+		return format, ok
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return formatCSV, true
+	}
+
+	for _, part := range strings.Split(accept, ",") {
+		switch strings.TrimSpace(strings.SplitN(part, ";", 2)[0]) {
+		case "text/csv", "text/*", "*/*":
+			return formatCSV, true
+		case "application/x-ofx", "application/ofx":
+			return formatOFX, true
+		case "application/jsonl", "application/x-ndjson", "application/x-jsonlines":
+			return formatJSONL, true
+		}
+	}
+
+	return "", false
+}
+
+// decodeExportFilter reads the currency and the dates of the export, all of them are required
+func decodeExportFilter(r *http.Request) (movement.HistoryFilter, error) {
+	query := r.URL.Query()
+	filter := movement.HistoryFilter{CurrencyName: strings.ToUpper(query.Get("currencyname"))}
+	if filter.CurrencyName == "" || query.Get("from") == "" || query.Get("to") == "" {
+		return movement.HistoryFilter{}, errors.New("currencyname, from and to are required")
+	}
+
+	var err error
+	if filter.From, err = parseHistoryDate(query.Get("from")); err != nil {
+		return movement.HistoryFilter{}, fmt.Errorf("from: %w", err)
+	}
+	if filter.To, err = parseHistoryDate(query.Get("to")); err != nil {
+		return movement.HistoryFilter{}, fmt.Errorf("to: %w", err)
+	}
+	if !filter.From.Before(filter.To) {
+		return movement.HistoryFilter{}, errors.New("from must be before to")
+	}
+
+	return filter, nil
+}
+
+// csvEncoder writes a row per line, the debits have a negative amount. The id is unique for every row,
+// the entry id is the movement the refunds refer to and is empty for the rows saved before the journal entries
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) begin() error {
+	return e.w.Write([]string{"id", "entryid", "date", "currency", "type", "interactionalias", "amount", "balance", "refundof"})
+}
+
+func (e *csvEncoder) encode(row movement.Row) error {
+	var entryID, refundOf string
+	if row.ID != 0 {
+		entryID = strconv.FormatInt(row.ID, 10)
+	}
+	if row.RefundOf != 0 {
+		refundOf = strconv.FormatInt(row.RefundOf, 10)
+	}
+
+	return e.w.Write([]string{strconv.FormatInt(row.MovementID, 10), entryID, row.DateCreated.UTC().Format(time.RFC3339), row.CurrencyName,
+		row.Type, row.InteractionAlias, row.SignedAmount().String(), row.TotalAmount.String(), refundOf})
+}
+
+func (e *csvEncoder) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonlEncoder writes every row as a JSON object in a line, like the rows of the history
+type jsonlEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *jsonlEncoder) begin() error {
+	return nil
+}
+
+func (e *jsonlEncoder) encode(row movement.Row) error {
+	return e.enc.Encode(row)
+}
+
+func (e *jsonlEncoder) end() error {
+	return nil
+}
+
+// ofxEncoder writes an OFX 2.2 bank statement of the account, its ledger balance is the balance after the
+// last row or the balance at the end of the dates when there are no rows
+type ofxEncoder struct {
+	w       io.Writer
+	alias   string
+	filter  movement.HistoryFilter
+	balance func() (money.Amount, error)
+	last    *movement.Row
+}
+
+func (e *ofxEncoder) begin() error {
+	_, err := fmt.Fprintf(e.w, `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS><DTSERVER>%s</DTSERVER><LANGUAGE>ENG</LANGUAGE></SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1><STMTTRNRS><TRNUID>0</TRNUID><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<STMTRS><CURDEF>%s</CURDEF>
+<BANKACCTFROM><BANKID>wallet</BANKID><ACCTID>%s</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>
+<BANKTRANLIST><DTSTART>%s</DTSTART><DTEND>%s</DTEND>
+`, time.Now().UTC().Format(ofxTime), e.filter.CurrencyName, ofxText(e.alias+"-"+e.filter.CurrencyName),
+		e.filter.From.UTC().Format(ofxTime), e.filter.To.UTC().Format(ofxTime))
+
+	return err
+}
+
+func (e *ofxEncoder) encode(row movement.Row) error {
+	e.last = &row
+
+	// the importers skip the transactions with a known FITID, so it is the id of the row and not the one of its
+	// entry: the rows saved before the journal entries have no entry
+	_, err := fmt.Fprintf(e.w, "<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s</TRNAMT>"+
+		"<FITID>%d</FITID><NAME>%s</NAME><MEMO>%s</MEMO></STMTTRN>\n", ofxType(row), row.DateCreated.UTC().Format(ofxTime),
+		row.SignedAmount(), row.MovementID, ofxText(row.InteractionAlias), row.Type)
+
+	return err
+}
+
+func (e *ofxEncoder) end() error {
+	var balance money.Amount
+	var err error
+	if e.last != nil {
+		balance = e.last.TotalAmount
+	} else if balance, err = e.balance(); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(e.w, "</BANKTRANLIST>\n<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>\n"+
+		"</STMTRS></STMTTRNRS></BANKMSGSRSV1>\n</OFX>\n", balance, e.filter.To.UTC().Format(ofxTime))
+
+	return err
+}
+
+func ofxType(row movement.Row) string {
+	switch {
+	case row.Type == movement.DepositMov:
+		return "DEP"
+	case row.Type == movement.FeeMov:
+		return "FEE"
+	case row.SignedAmount().Sign() < 0:
+		return "DEBIT"
+	default:
+		return "CREDIT"
+	}
+}
+
+func ofxText(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spolia/wallet-api/internal/wallet/idempotency"
+	"github.com/spolia/wallet-api/internal/wallet/money"
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+	"github.com/spolia/wallet-api/internal/wallet/session"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Handler_API_ExportHistory(t *testing.T) {
+	created := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	rows := []movement.Row{
+		{ID: 2, MovementID: 7, CurrencyName: movement.ARS, InteractionAlias: "otheruser", Type: movement.SendMov, DateCreated: created,
+			Amount: money.MustParse("40.50"), TotalAmount: money.MustParse("59.50")},
+		{ID: 4, MovementID: 9, CurrencyName: movement.ARS, InteractionAlias: "otheruser", Type: movement.ReceiveMov, DateCreated: created,
+			Amount: money.MustParse("10.00"), TotalAmount: money.MustParse("69.50"), RefundOf: 2},
+		// saved before the journal entries
+		{MovementID: 3, CurrencyName: movement.ARS, InteractionAlias: "otheruser", Type: movement.SendMov, DateCreated: created,
+			Amount: money.MustParse("1.00"), TotalAmount: money.MustParse("68.50")},
+	}
+	tt := []struct {
+		TestName, Query, Accept string
+		Rows                    []movement.Row
+		ServiceError            error
+		ExpectedStatus          int
+		ExpectedType            string
+		ExpectedBody            []string
+	}{
+		{"CSVByDefault", "", "", rows, nil, http.StatusOK, "text/csv",
+			[]string{"id,entryid,date,currency,type,interactionalias,amount,balance,refundof\n",
+				"7,2,2022-03-01T10:00:00Z,ARS,send,otheruser,-40.50,59.50,\n", "9,4,2022-03-01T10:00:00Z,ARS,receive,otheruser,10.00,69.50,2\n",
+				"3,,2022-03-01T10:00:00Z,ARS,send,otheruser,-1.00,68.50,\n"}},
+		{"JSONLinesByAccept", "", "application/x-ndjson", rows, nil, http.StatusOK, "application/jsonl",
+			[]string{`"ID":2,"MovementID":7,"CurrencyName":"ARS"`, `"RefundOf":2}` + "\n"}},
+		{"OFXByParameter", "&format=ofx", "text/csv", rows, nil, http.StatusOK, "application/x-ofx",
+			[]string{"<CURDEF>ARS</CURDEF>", "<DTSTART>20220301000000.000[0:GMT]</DTSTART>",
+				"<TRNTYPE>DEBIT</TRNTYPE><DTPOSTED>20220301100000.000[0:GMT]</DTPOSTED><TRNAMT>-40.50</TRNAMT><FITID>7</FITID>",
+				"<TRNAMT>-1.00</TRNAMT><FITID>3</FITID>", "<LEDGERBAL><BALAMT>68.50</BALAMT>"}},
+		// without rows the ledger balance is read at the end of the dates
+		{"OFXWithoutRows", "&format=ofx", "", nil, nil, http.StatusOK, "application/x-ofx",
+			[]string{"<LEDGERBAL><BALAMT>100.00</BALAMT><DTASOF>20220401000000.000[0:GMT]</DTASOF>"}},
+		{"UnknownFormat", "&format=pdf", "", rows, nil, http.StatusNotAcceptable, "", nil},
+		{"NotAcceptable", "", "application/pdf", rows, nil, http.StatusNotAcceptable, "", nil},
+		{"WrongCurrency", "", "", nil, movement.ErrorWrongCurrency, http.StatusBadRequest, "", nil},
+	}
+
+	for _, tc := range tt {
+		// Given
+		service := &serviceMock{}
+		service.On("ValidateCredential").Return(true, nil)
+		service.On("ExportHistory").Return(tc.Rows, tc.ServiceError)
+		service.On("GetBalanceAt").Return(money.MustParse("100.00"), nil)
+		router := mux.NewRouter()
+		API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
+		cookie := loginCookie(t, router)
+
+		// When
+		request, err := http.NewRequest(http.MethodGet,
+			"/internal/movements/history/export?currencyname=ars&from=2022-03-01&to=2022-04-01"+tc.Query, nil)
+		require.NoError(t, err)
+		request.AddCookie(cookie)
+		if tc.Accept != "" {
+			request.Header.Set("Accept", tc.Accept)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request)
+
+		// Then
+		require.Equal(t, tc.ExpectedStatus, rr.Code, "%s failed. Response: %s", tc.TestName, rr.Body.String())
+		if tc.ExpectedStatus == http.StatusOK {
+			require.Equal(t, tc.ExpectedType, rr.Header().Get("Content-Type"), tc.TestName)
+			for _, expected := range tc.ExpectedBody {
+				require.True(t, strings.Contains(rr.Body.String(), expected), "%s failed. Response: %s", tc.TestName, rr.Body.String())
+			}
+		}
+	}
+}
+
+func Test_Handler_API_ExportHistory_When_DatesAreMissing_Then_ReturnsBadRequest(t *testing.T) {
+	// Given
+	service := &serviceMock{}
+	service.On("ValidateCredential").Return(true, nil)
+	router := mux.NewRouter()
+	API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
+	cookie := loginCookie(t, router)
+
+	// When
+	request, err := http.NewRequest(http.MethodGet, "/internal/movements/history/export?currencyname=ars&from=2022-03-01", nil)
+	require.NoError(t, err)
+	request.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, request)
+
+	// Then
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	service.AssertNotCalled(t, "ExportHistory")
+}
@@ -2,25 +2,34 @@ package internal
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
-	"github.com/gorilla/securecookie"
+	"github.com/gorilla/mux"
+	"github.com/spolia/wallet-api/internal/wallet/exchange"
+	"github.com/spolia/wallet-api/internal/wallet/money"
 	"github.com/spolia/wallet-api/internal/wallet/movement"
+	"github.com/spolia/wallet-api/internal/wallet/session"
+	"github.com/spolia/wallet-api/internal/wallet/statement"
 	"github.com/spolia/wallet-api/internal/wallet/user"
+	"github.com/spolia/wallet-api/internal/wallet/withdrawal"
 )
 
 var validate = validator.New()
 
-var cookieHandler = securecookie.New(securecookie.GenerateRandomKey(64),
-	securecookie.GenerateRandomKey(32))
-
-func login(service Service) http.HandlerFunc {
+func login(service Service, sessions *sessionManager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var loginRequest struct {
 			Alias    string `json:"alias" validate:"required"`
 			Password string `json:"password" validate:"required"`
+			// Token asks for bearer tokens instead of the session cookie
+			Token bool `json:"token"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&loginRequest); err != nil {
@@ -33,6 +42,11 @@ func login(service Service) http.HandlerFunc {
 			return
 		}
 
+		if len(loginRequest.Password) > user.MaxPasswordBytes {
+			http.Error(w, user.ErrorPasswordTooLong.Error(), http.StatusBadRequest)
+			return
+		}
+
 		ok, err := service.ValidateCredential(r.Context(), strings.ToLower(loginRequest.Alias), loginRequest.Password)
 		if err != nil {
 			if err == user.ErrorInvalidCredential {
@@ -43,17 +57,158 @@ func login(service Service) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if ok {
-			setSession(loginRequest.Alias, w)
+		if !ok {
+			http.Error(w, user.ErrorInvalidCredential.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		alias := strings.ToLower(loginRequest.Alias)
+		if loginRequest.Token {
+			created, err := sessions.createSession(alias, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			tokens, err := sessions.tokens.issue(created, sessions.now())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			json.NewEncoder(w).Encode(tokens)
+			return
+		}
+
+		if err = sessions.setSession(alias, w, r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
 		w.Write([]byte("Logged in"))
 	}
 }
 
-func logout(w http.ResponseWriter, r *http.Request) {
-	clearSession(w)
-	w.Write([]byte("Logged out"))
+func refreshToken(sessions *sessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var refreshRequest struct {
+			RefreshToken string `json:"refreshtoken" validate:"required"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&refreshRequest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := validate.Struct(refreshRequest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		claims, err := sessions.tokens.parse(refreshRequest.RefreshToken, refreshTokenType)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		current, ok := sessions.validSession(r.Context(), claims.SessionID, claims.Subject)
+		if !ok {
+			http.Error(w, "the session expired or was revoked", http.StatusUnauthorized)
+			return
+		}
+
+		tokens, err := sessions.tokens.issue(current, sessions.now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(tokens)
+		return
+	}
+}
+
+func logout(sessions *sessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if current, ok := sessions.currentSession(r); ok {
+			err := sessions.store.Revoke(r.Context(), current.Alias, current.ID)
+			if err != nil && err != session.ErrorNotFound {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		sessions.clearSession(w)
+		w.Write([]byte("Logged out"))
+	}
+}
+
+func listSessions(sessions *sessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current := sessionFromContext(r.Context())
+
+		userSessions, err := sessions.store.List(r.Context(), current.Alias)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		type sessionResponse struct {
+			session.Session
+			Current bool `json:"current"`
+		}
+
+		now := sessions.now()
+		response := make([]sessionResponse, 0, len(userSessions))
+		for _, v := range userSessions {
+			if v.Expired(now, sessions.config.IdleTimeout, sessions.config.AbsoluteTimeout) {
+				continue
+			}
+			response = append(response, sessionResponse{Session: v, Current: v.ID == current.ID})
+		}
+
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+}
+
+func revokeSession(sessions *sessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current := sessionFromContext(r.Context())
+
+		id := mux.Vars(r)["id"]
+		if err := sessions.store.Revoke(r.Context(), current.Alias, id); err != nil {
+			if err == session.ErrorNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if id == current.ID {
+			sessions.clearSession(w)
+		}
+
+		json.NewEncoder(w).Encode("ok")
+		return
+	}
+}
+
+func revokeAllSessions(sessions *sessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current := sessionFromContext(r.Context())
+
+		if err := sessions.store.RevokeAll(r.Context(), current.Alias); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		sessions.clearSession(w)
+		json.NewEncoder(w).Encode("ok")
+		return
+	}
 }
 
 //create user
@@ -69,6 +224,12 @@ func createUser(service Service) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+
+		// bcrypt ignores the bytes after the maximum, the validator counts characters
+		if len(userRequest.Password) > user.MaxPasswordBytes {
+			http.Error(w, user.ErrorPasswordTooLong.Error(), http.StatusBadRequest)
+			return
+		}
 		userRequest.Alias = strings.ToLower(userRequest.Alias)
 		err := service.CreateUser(r.Context(), userRequest)
 		if err != nil {
@@ -85,11 +246,127 @@ func createUser(service Service) http.HandlerFunc {
 	}
 }
 
+// getProfile returns the profile of the logged in user
+func getProfile(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		profile, err := service.GetUser(r.Context(), strings.ToLower(userAlias(r.Context())))
+		if err != nil {
+			profileError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(profile)
+		return
+	}
+}
+
+// updateProfile changes the fields of the profile present in the request, the alias can not be changed
+func updateProfile(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var update user.ProfileUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := validateProfileUpdate(update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		profile, err := service.UpdateUser(r.Context(), strings.ToLower(userAlias(r.Context())), update)
+		if err != nil {
+			if err == user.ErrorAlreadyExist {
+				http.Error(w, "email already exist", http.StatusBadRequest)
+				return
+			}
+			profileError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(profile)
+		return
+	}
+}
+
+// validateProfileUpdate checks the fields present in the update, they can not be empty
+func validateProfileUpdate(update user.ProfileUpdate) error {
+	if update.FirstName != nil {
+		if err := validate.Var(*update.FirstName, "required,max=45"); err != nil {
+			return fmt.Errorf("firstname: %w", err)
+		}
+	}
+	if update.LastName != nil {
+		if err := validate.Var(*update.LastName, "required,max=45"); err != nil {
+			return fmt.Errorf("lastname: %w", err)
+		}
+	}
+	if update.Email != nil {
+		if err := validate.Var(*update.Email, "required,email,max=45"); err != nil {
+			return fmt.Errorf("email: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// closeAccount closes the account of the logged in user and revokes all its sessions
+func closeAccount(service Service, sessions *sessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alias := strings.ToLower(userAlias(r.Context()))
+		if err := service.CloseUser(r.Context(), alias); err != nil {
+			profileError(w, err)
+			return
+		}
+
+		if err := sessions.store.RevokeAll(r.Context(), alias); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		sessions.clearSession(w)
+		json.NewEncoder(w).Encode("ok")
+		return
+	}
+}
+
+// profileError writes the response of a failed profile operation
+func profileError(w http.ResponseWriter, err error) {
+	switch err {
+	case user.ErrorDestinyUserNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case user.ErrorBalanceNotZero, user.ErrorPendingWithdrawals:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// getBalance returns the balance of every user currency, with an at query parameter it returns the ledger
+// balances as of that time
 func getBalance(service Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		alias := getUserAlias(r)
-		if alias == "" {
-			http.Error(w, "log in is required", http.StatusUnauthorized)
+		alias := userAlias(r.Context())
+
+		if value := r.URL.Query().Get("at"); value != "" {
+			at, err := parseHistoryDate(value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("at: %v", err), http.StatusBadRequest)
+				return
+			}
+
+			if at.After(time.Now()) {
+				http.Error(w, "at can not be in the future", http.StatusBadRequest)
+				return
+			}
+
+			balance, err := service.GetAccountBalanceAt(r.Context(), strings.ToLower(alias), at)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			json.NewEncoder(w).Encode(balance)
 			return
 		}
 
@@ -104,16 +381,25 @@ func getBalance(service Service) http.HandlerFunc {
 	}
 }
 
+// getHistory returns a page of the history of the user, the query parameters filter the rows and the cursor
+// of a page returns the following one
 func getHistory(service Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		alias := getUserAlias(r)
-		if alias == "" {
-			http.Error(w, "log in is required", http.StatusUnauthorized)
+		alias := userAlias(r.Context())
+
+		filter, err := decodeHistoryFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		history, err := service.GetHistory(r.Context(), strings.ToLower(alias))
+		history, err := service.GetHistory(r.Context(), strings.ToLower(alias), filter)
 		if err != nil {
+			if err == movement.ErrorWrongCurrency || err == movement.ErrorInvalidCursor {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -123,50 +409,223 @@ func getHistory(service Service) http.HandlerFunc {
 	}
 }
 
+// historyTypes are the movement types that filter the history
+var historyTypes = map[string]bool{
+	"init": true, movement.DepositMov: true, movement.SendMov: true, movement.ReceiveMov: true,
+	movement.ExchangeOutMov: true, movement.ExchangeInMov: true, movement.FeeMov: true, movement.WithdrawMov: true,
+}
+
+// decodeHistoryFilter reads the filters of the history from the query parameters, the dates are RFC 3339 times
+// or days like 2022-03-01
+func decodeHistoryFilter(r *http.Request) (movement.HistoryFilter, error) {
+	query := r.URL.Query()
+	filter := movement.HistoryFilter{
+		CurrencyName:     strings.ToUpper(query.Get("currencyname")),
+		Type:             strings.ToLower(query.Get("type")),
+		InteractionAlias: strings.ToLower(query.Get("interactionalias")),
+		Cursor:           query.Get("cursor"),
+		Order:            strings.ToLower(query.Get("order")),
+	}
+
+	if filter.Type != "" && !historyTypes[filter.Type] {
+		return movement.HistoryFilter{}, fmt.Errorf("unknown movement type %q", filter.Type)
+	}
+
+	if filter.Order != "" && filter.Order != movement.OrderAsc && filter.Order != movement.OrderDesc {
+		return movement.HistoryFilter{}, fmt.Errorf("the order must be %s or %s", movement.OrderAsc, movement.OrderDesc)
+	}
+
+	var err error
+	if filter.From, err = parseHistoryDate(query.Get("from")); err != nil {
+		return movement.HistoryFilter{}, fmt.Errorf("from: %w", err)
+	}
+	if filter.To, err = parseHistoryDate(query.Get("to")); err != nil {
+		return movement.HistoryFilter{}, fmt.Errorf("to: %w", err)
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return movement.HistoryFilter{}, errors.New("from must be before to")
+	}
+
+	if filter.MinAmount, err = parseHistoryAmount(query.Get("minamount")); err != nil {
+		return movement.HistoryFilter{}, fmt.Errorf("minamount: %w", err)
+	}
+	if filter.MaxAmount, err = parseHistoryAmount(query.Get("maxamount")); err != nil {
+		return movement.HistoryFilter{}, fmt.Errorf("maxamount: %w", err)
+	}
+
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 1 || filter.Limit > movement.MaxHistoryLimit {
+			return movement.HistoryFilter{}, fmt.Errorf("the limit must be between 1 and %d", movement.MaxHistoryLimit)
+		}
+	}
+
+	return filter, nil
+}
+
+func parseHistoryDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if day, err := time.Parse("2006-01-02", value); err == nil {
+		return day, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+func parseHistoryAmount(value string) (*money.Amount, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	amount, err := money.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &amount, nil
+}
+
 func send(service Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		alias := getUserAlias(r)
-		if alias == "" {
-			http.Error(w, "log in is required", http.StatusUnauthorized)
+		m, err := decodeSend(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sent, err := service.Send(r.Context(), m)
+		if err != nil {
+			sendError(w, err)
 			return
 		}
 
-		var sendRequest struct {
-			Amount           float64 `json:"amount" validate:"required,gt=0"`
-			CurrencyName     string  `json:"currencyname" validate:"required,oneof=usdt btc ars"`
-			InteractionAlias string  `json:"interactionalias" validate:"required"`
+		json.NewEncoder(w).Encode(sent)
+		return
+	}
+}
+
+// quoteSend returns the fee and the total of a send without moving the money
+func quoteSend(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, err := decodeSend(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		quoted, err := service.QuoteSend(r.Context(), m)
+		if err != nil {
+			sendError(w, err)
+			return
+		}
+
+		total, err := quoted.Amount.Add(quoted.Fee)
+		if err != nil {
+			sendError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(struct {
+			movement.Movement
+			// Total is the amount plus the fee, the money taken from the user account
+			Total money.Amount `json:"total"`
+		}{quoted, total})
+		return
+	}
+}
+
+// decodeSend reads the send request of the user
+func decodeSend(r *http.Request) (movement.Movement, error) {
+	var sendRequest struct {
+		Amount           money.Amount `json:"amount"`
+		CurrencyName     string       `json:"currencyname" validate:"required"`
+		InteractionAlias string       `json:"interactionalias" validate:"required"`
+		// DestinationCurrency is the currency paid to the receiver, the currency of the amount by default
+		DestinationCurrency string `json:"destinationcurrency"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&sendRequest); err != nil {
+		return movement.Movement{}, err
+	}
+
+	if err := validate.Struct(sendRequest); err != nil {
+		return movement.Movement{}, err
+	}
+
+	var m movement.Movement
+	m.Alias = strings.ToLower(userAlias(r.Context()))
+	m.InteractionAlias = strings.ToLower(sendRequest.InteractionAlias)
+	m.CurrencyName = strings.ToUpper(sendRequest.CurrencyName)
+	m.Type = movement.SendMov
+	if sendRequest.DestinationCurrency != "" {
+		m.Conversion = &movement.Conversion{DestinationCurrency: strings.ToUpper(sendRequest.DestinationCurrency)}
+	}
+
+	if sendRequest.Amount.Sign() <= 0 {
+		return movement.Movement{}, errorNotPositiveAmount
+	}
+	m.Amount = sendRequest.Amount
+
+	if m.Alias == m.InteractionAlias {
+		return movement.Movement{}, errors.New("the destiny and origin alias have to be different")
+	}
+
+	return m, nil
+}
+
+// sendError writes the response of a failed send
+func sendError(w http.ResponseWriter, err error) {
+	if err == user.ErrorDestinyUserNotFound || err == movement.ErrorAccountClosed {
+		http.Error(w, "wrong destiny alias", http.StatusBadRequest)
+		return
+	}
+
+	if err == movement.ErrorWrongCurrency || err == movement.ErrorInsufficientFunds || isAmountError(err) ||
+		err == exchange.ErrorRateNotFound || err == exchange.ErrorAmountTooSmall {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func deposit(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alias := userAlias(r.Context())
+
+		var depositRequest struct {
+			Amount       money.Amount `json:"amount"`
+			CurrencyName string       `json:"currencyname" validate:"required"`
 		}
 
-		if err := json.NewDecoder(r.Body).Decode(&sendRequest); err != nil {
+		if err := json.NewDecoder(r.Body).Decode(&depositRequest); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		if err := validate.Struct(sendRequest); err != nil {
+		if err := validate.Struct(depositRequest); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
 		var m movement.Movement
 		m.Alias = strings.ToLower(alias)
-		m.InteractionAlias = strings.ToLower(sendRequest.InteractionAlias)
-		m.CurrencyName = strings.ToUpper(sendRequest.CurrencyName)
-		m.Type = movement.SendMov
-		m.Amount = sendRequest.Amount
+		m.CurrencyName = strings.ToUpper(depositRequest.CurrencyName)
+		m.InteractionAlias = strings.ToLower(alias)
+		m.Type = movement.DepositMov
 
-		if m.Alias == m.InteractionAlias {
-			http.Error(w, "the destiny and origin alias have to be different", http.StatusBadRequest)
+		if depositRequest.Amount.Sign() <= 0 {
+			http.Error(w, errorNotPositiveAmount.Error(), http.StatusBadRequest)
 			return
 		}
+		m.Amount = depositRequest.Amount
 
-		err := service.Send(r.Context(), m)
+		deposited, err := service.AutoDeposit(r.Context(), m)
 		if err != nil {
-			if err == user.ErrorDestinyUserNotFound {
-				http.Error(w, "wrong destiny alias", http.StatusBadRequest)
-				return
-			}
-
-			if err == movement.ErrorWrongCurrency || err == movement.ErrorInsufficientFunds {
+			if err == movement.ErrorWrongCurrency || err == movement.ErrorFeeExceedsAmount ||
+				err == movement.ErrorInsufficientFunds || isAmountError(err) {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
@@ -175,44 +634,125 @@ func send(service Service) http.HandlerFunc {
 			return
 		}
 
-		json.NewEncoder(w).Encode("ok")
+		json.NewEncoder(w).Encode(deposited)
 		return
 	}
 }
 
-func deposit(service Service) http.HandlerFunc {
+func quoteExchange(service Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		alias := getUserAlias(r)
-		if alias == "" {
-			http.Error(w, "log in is required", http.StatusUnauthorized)
+		alias := userAlias(r.Context())
+
+		var quoteRequest struct {
+			Amount              money.Amount `json:"amount"`
+			CurrencyName        string       `json:"currencyname" validate:"required"`
+			DestinationCurrency string       `json:"destinationcurrency" validate:"required"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&quoteRequest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		var depositRequest struct {
-			Amount       float64 `json:"amount" validate:"required,gt=0"`
-			CurrencyName string  `json:"currencyname" validate:"required,oneof=usdt btc ars"`
+		if err := validate.Struct(quoteRequest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
-		if err := json.NewDecoder(r.Body).Decode(&depositRequest); err != nil {
+		if quoteRequest.Amount.Sign() <= 0 {
+			http.Error(w, errorNotPositiveAmount.Error(), http.StatusBadRequest)
+			return
+		}
+
+		quote, err := service.QuoteExchange(r.Context(), strings.ToLower(alias), quoteRequest.Amount,
+			strings.ToUpper(quoteRequest.CurrencyName), strings.ToUpper(quoteRequest.DestinationCurrency))
+		if err != nil {
+			http.Error(w, err.Error(), exchangeErrorStatus(err))
+			return
+		}
+
+		json.NewEncoder(w).Encode(quote)
+		return
+	}
+}
+
+func exchangeMoney(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alias := userAlias(r.Context())
+
+		var exchangeRequest struct {
+			QuoteID string `json:"quoteid" validate:"required"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&exchangeRequest); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		if err := validate.Struct(depositRequest); err != nil {
+		if err := validate.Struct(exchangeRequest); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		var m movement.Movement
-		m.Alias = strings.ToLower(alias)
-		m.CurrencyName = strings.ToUpper(depositRequest.CurrencyName)
-		m.InteractionAlias = strings.ToLower(alias)
-		m.Type = movement.DepositMov
-		m.Amount = depositRequest.Amount
+		exchanged, err := service.Exchange(r.Context(), strings.ToLower(alias), exchangeRequest.QuoteID)
+		if err != nil {
+			http.Error(w, err.Error(), exchangeErrorStatus(err))
+			return
+		}
+
+		json.NewEncoder(w).Encode(exchanged)
+		return
+	}
+}
+
+// exchangeErrorStatus returns the response status of an exchange error
+func exchangeErrorStatus(err error) int {
+	switch {
+	case err == exchange.ErrorQuoteNotFound:
+		return http.StatusNotFound
+	case err == exchange.ErrorQuoteExpired:
+		return http.StatusGone
+	case err == exchange.ErrorRateNotFound || err == exchange.ErrorSameCurrency || err == exchange.ErrorAmountTooSmall,
+		err == movement.ErrorWrongCurrency || err == movement.ErrorInsufficientFunds || isAmountError(err):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func withdraw(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alias := userAlias(r.Context())
+
+		var withdrawRequest struct {
+			Amount       money.Amount `json:"amount"`
+			CurrencyName string       `json:"currencyname" validate:"required"`
+			Destination  string       `json:"destination" validate:"required,max=255"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&withdrawRequest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := validate.Struct(withdrawRequest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if withdrawRequest.Amount.Sign() <= 0 {
+			http.Error(w, errorNotPositiveAmount.Error(), http.StatusBadRequest)
+			return
+		}
 
-		err := service.AutoDeposit(r.Context(), m)
+		created, err := service.Withdraw(r.Context(), withdrawal.Withdrawal{
+			Alias:        strings.ToLower(alias),
+			Amount:       withdrawRequest.Amount,
+			CurrencyName: strings.ToUpper(withdrawRequest.CurrencyName),
+			Destination:  withdrawRequest.Destination,
+		})
 		if err != nil {
-			if err == movement.ErrorWrongCurrency {
+			if err == movement.ErrorWrongCurrency || err == movement.ErrorInsufficientFunds || isAmountError(err) {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
@@ -221,44 +761,301 @@ func deposit(service Service) http.HandlerFunc {
 			return
 		}
 
-		json.NewEncoder(w).Encode("ok")
+		json.NewEncoder(w).Encode(created)
+		return
+	}
+}
+
+func getWithdrawals(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		withdrawals, err := service.GetWithdrawals(r.Context(), strings.ToLower(userAlias(r.Context())))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(withdrawals)
+		return
+	}
+}
+
+func getWithdrawal(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			http.Error(w, withdrawal.ErrorNotFound.Error(), http.StatusNotFound)
+			return
+		}
+
+		found, err := service.GetWithdrawal(r.Context(), strings.ToLower(userAlias(r.Context())), id)
+		if err != nil {
+			if err == withdrawal.ErrorNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(found)
+		return
+	}
+}
+
+// getMovement returns a movement of the user, the movements of other users are not found
+func getMovement(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			http.Error(w, movement.ErrorMovementNotFound.Error(), http.StatusNotFound)
+			return
+		}
+
+		found, err := service.GetMovement(r.Context(), strings.ToLower(userAlias(r.Context())), id)
+		if err != nil {
+			if err == movement.ErrorMovementNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(found)
 		return
 	}
 }
 
-func clearSession(w http.ResponseWriter) {
-	cookie := &http.Cookie{
-		Name:   "session",
-		Value:  "",
-		Path:   "/",
-		MaxAge: -1,
+// refund gives back a send received by the user, the request body is optional and without an amount
+// all the money that was not refunded yet is given back
+func refund(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			http.Error(w, movement.ErrorMovementNotFound.Error(), http.StatusNotFound)
+			return
+		}
+
+		var refundRequest struct {
+			Amount money.Amount `json:"amount"`
+		}
+
+		if err = json.NewDecoder(r.Body).Decode(&refundRequest); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if refundRequest.Amount.Sign() < 0 {
+			http.Error(w, errorNotPositiveAmount.Error(), http.StatusBadRequest)
+			return
+		}
+
+		refunded, err := service.Refund(r.Context(), strings.ToLower(userAlias(r.Context())), id, refundRequest.Amount)
+		if err != nil {
+			switch err {
+			case movement.ErrorMovementNotFound:
+				http.Error(w, err.Error(), http.StatusNotFound)
+			case movement.ErrorAlreadyRefunded:
+				http.Error(w, err.Error(), http.StatusConflict)
+			case movement.ErrorRefundExceedsMovement:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				sendError(w, err)
+			}
+			return
+		}
+
+		json.NewEncoder(w).Encode(refunded)
+		return
+	}
+}
+
+// createHold reserves money of the user, the hold expires after the requested seconds or the maximum duration
+func createHold(service Service, maxDuration time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alias := userAlias(r.Context())
+
+		var holdRequest struct {
+			Amount           money.Amount `json:"amount"`
+			CurrencyName     string       `json:"currencyname" validate:"required"`
+			InteractionAlias string       `json:"interactionalias" validate:"required"`
+			// ExpiresIn is the duration of the hold in seconds
+			ExpiresIn int64 `json:"expiresin" validate:"gte=0"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&holdRequest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := validate.Struct(holdRequest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if holdRequest.Amount.Sign() <= 0 {
+			http.Error(w, errorNotPositiveAmount.Error(), http.StatusBadRequest)
+			return
+		}
+
+		h := movement.Hold{
+			Alias:            strings.ToLower(alias),
+			InteractionAlias: strings.ToLower(holdRequest.InteractionAlias),
+			Amount:           holdRequest.Amount,
+			CurrencyName:     strings.ToUpper(holdRequest.CurrencyName),
+		}
+		if h.Alias == h.InteractionAlias {
+			http.Error(w, "the destiny and origin alias have to be different", http.StatusBadRequest)
+			return
+		}
+
+		duration := maxDuration
+		if holdRequest.ExpiresIn > 0 && holdRequest.ExpiresIn < int64(maxDuration/time.Second) {
+			duration = time.Duration(holdRequest.ExpiresIn) * time.Second
+		}
+		h.ExpiresAt = time.Now().Add(duration)
+
+		created, err := service.CreateHold(r.Context(), h)
+		if err != nil {
+			sendError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(created)
+		return
+	}
+}
+
+func getHold(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			http.Error(w, movement.ErrorHoldNotFound.Error(), http.StatusNotFound)
+			return
+		}
+
+		h, err := service.GetHold(r.Context(), strings.ToLower(userAlias(r.Context())), id)
+		if err != nil {
+			holdError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(h)
+		return
 	}
-	http.SetCookie(w, cookie)
 }
 
-func setSession(alias string, w http.ResponseWriter) {
-	value := map[string]string{
-		"alias": alias,
+// captureHold sends the held money, the request body is optional and without an amount all the held money is sent
+func captureHold(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			http.Error(w, movement.ErrorHoldNotFound.Error(), http.StatusNotFound)
+			return
+		}
+
+		var captureRequest struct {
+			Amount money.Amount `json:"amount"`
+		}
+
+		if err = json.NewDecoder(r.Body).Decode(&captureRequest); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if captureRequest.Amount.Sign() < 0 {
+			http.Error(w, errorNotPositiveAmount.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sent, err := service.CaptureHold(r.Context(), strings.ToLower(userAlias(r.Context())), id, captureRequest.Amount)
+		if err != nil {
+			holdError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(sent)
+		return
 	}
+}
 
-	encoded, err := cookieHandler.Encode("session", value)
-	if err == nil {
-		cookie := &http.Cookie{
-			Name:  "session",
-			Value: encoded,
-			Path:  "/",
+func releaseHold(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			http.Error(w, movement.ErrorHoldNotFound.Error(), http.StatusNotFound)
+			return
 		}
-		http.SetCookie(w, cookie)
+
+		h, err := service.ReleaseHold(r.Context(), strings.ToLower(userAlias(r.Context())), id)
+		if err != nil {
+			holdError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(h)
+		return
+	}
+}
+
+// holdError writes the response of a failed hold operation
+func holdError(w http.ResponseWriter, err error) {
+	switch err {
+	case movement.ErrorHoldNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case movement.ErrorHoldNotActive:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case movement.ErrorCaptureExceedsHold:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		sendError(w, err)
+	}
+}
+
+// getStatements returns the statements of the user accounts for a month like 2022-03, the months that did not
+// start yet are not found
+func getStatements(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		month, err := time.Parse(statement.MonthLayout, mux.Vars(r)["month"])
+		if err != nil {
+			http.Error(w, "wrong month", http.StatusNotFound)
+			return
+		}
+
+		statements, err := service.GetStatements(r.Context(), strings.ToLower(userAlias(r.Context())), month)
+		if err != nil {
+			if err == statement.ErrorMonthNotStarted {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(statements)
+		return
 	}
 }
 
-func getUserAlias(request *http.Request) (alias string) {
-	cookie, err := request.Cookie("session")
-	if err == nil {
-		cookieValue := make(map[string]string)
-		if err = cookieHandler.Decode("session", cookie.Value, &cookieValue); err == nil {
-			alias = cookieValue["alias"]
+func getCurrencies(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		currencies, err := service.GetCurrencies(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+
+		json.NewEncoder(w).Encode(currencies)
+		return
 	}
-	return alias
+}
+
+var errorNotPositiveAmount = errors.New("the amount has to be greater than zero")
+
+// isAmountError returns true if the amount can not be represented in the requested currency or is above its maximum
+func isAmountError(err error) bool {
+	return err == money.ErrorTooManyDecimals || err == money.ErrorOutOfRange || err == movement.ErrorAmountTooLarge
 }
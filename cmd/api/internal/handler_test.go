@@ -3,16 +3,24 @@ package internal
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/spolia/wallet-api/internal/wallet/exchange"
+	"github.com/spolia/wallet-api/internal/wallet/idempotency"
+	"github.com/spolia/wallet-api/internal/wallet/money"
 	"github.com/spolia/wallet-api/internal/wallet/movement"
+	"github.com/spolia/wallet-api/internal/wallet/session"
+	"github.com/spolia/wallet-api/internal/wallet/statement"
 	"github.com/spolia/wallet-api/internal/wallet/user"
+	"github.com/spolia/wallet-api/internal/wallet/withdrawal"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -26,6 +34,8 @@ func Test_Handler_API_createUser(t *testing.T) {
 	}{
 		{"Ok", "create_user_ok", http.StatusOK, nil},
 		{"WrongFormat", "create_user_wrong_format", http.StatusBadRequest, nil},
+		{"SystemAlias", "create_user_system_alias", http.StatusBadRequest, nil},
+		{"PasswordTooLong", "create_user_long_password", http.StatusBadRequest, nil},
 		{"ErrorAlreadyExist", "create_user_ok", http.StatusBadRequest, user.ErrorAlreadyExist},
 		{"InternalServerError", "create_user_ok", http.StatusInternalServerError, errors.New("fail")},
 	}
@@ -38,7 +48,7 @@ func Test_Handler_API_createUser(t *testing.T) {
 
 		rr := httptest.NewRecorder()
 		router := mux.NewRouter()
-		API(router, service)
+		API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
 		body, err := ioutil.ReadFile(fmt.Sprintf("testdata/%s.json", tc.Filename))
 		require.NoError(t, err)
 		reader := bytes.NewReader(body)
@@ -59,7 +69,7 @@ func Test_Handler_API_Login(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 	router := mux.NewRouter()
-	API(router, service)
+	API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
 	body, err := ioutil.ReadFile("testdata/login.json")
 	require.NoError(t, err)
 	reader := bytes.NewReader(body)
@@ -71,6 +81,655 @@ func Test_Handler_API_Login(t *testing.T) {
 	require.Equal(t, http.StatusOK, rr.Code)
 }
 
+func Test_Handler_API_Sessions(t *testing.T) {
+	// Given
+	service := &serviceMock{}
+	service.On("ValidateCredential").Return(true, nil)
+	router := mux.NewRouter()
+	API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
+
+	login := func() *http.Cookie {
+		return loginCookie(t, router)
+	}
+	do := func(method, path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		request, err := http.NewRequest(method, path, nil)
+		require.NoError(t, err)
+		request.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request)
+		return rr
+	}
+
+	first, second, third := login(), login(), login()
+
+	// When
+	rr := do(http.MethodGet, "/internal/sessions", first)
+
+	// Then
+	require.Equal(t, http.StatusOK, rr.Code)
+	var listed []struct {
+		ID      string `json:"id"`
+		Current bool   `json:"current"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&listed))
+	require.Len(t, listed, 3)
+
+	var secondID string
+	for _, v := range listed {
+		if !v.Current {
+			secondID = v.ID
+		}
+	}
+
+	// revoke one of the other sessions
+	require.Equal(t, http.StatusOK, do(http.MethodDelete, "/internal/sessions/"+secondID, first).Code)
+	require.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/internal/sessions/"+secondID, first).Code)
+
+	// logout revokes the session on the server, not only the cookie
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/logout", first).Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/internal/sessions", first).Code)
+
+	// revoke all
+	fourth := login()
+	require.Equal(t, http.StatusOK, do(http.MethodDelete, "/internal/sessions", fourth).Code)
+	for _, c := range []*http.Cookie{second, third, fourth} {
+		require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/internal/sessions", c).Code)
+	}
+}
+
+func Test_Handler_API_BearerToken(t *testing.T) {
+	// Given
+	service := &serviceMock{}
+	service.On("ValidateCredential").Return(true, nil)
+	service.On("GetBalance").Return(movement.AccountBalance{}, nil)
+	router := mux.NewRouter()
+	API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{Token: TokenConfig{AccessTTL: time.Minute, RefreshTTL: time.Hour}})
+
+	do := func(method, path, authorization string, body []byte) *httptest.ResponseRecorder {
+		request, err := http.NewRequest(method, path, bytes.NewReader(body))
+		require.NoError(t, err)
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request)
+		return rr
+	}
+
+	body, err := ioutil.ReadFile("testdata/login_token.json")
+	require.NoError(t, err)
+
+	// When
+	rr := do(http.MethodPost, "/login", "", body)
+
+	// Then
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Empty(t, rr.Result().Cookies(), "tokens are issued instead of the cookie")
+	var tokens tokenResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&tokens))
+
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/internal/movements/balance", "Bearer "+tokens.AccessToken, nil).Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/internal/movements/balance", "", nil).Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/internal/movements/balance", "Bearer "+tokens.RefreshToken, nil).Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/internal/movements/balance", "Bearer wrong", nil).Code)
+
+	// refresh
+	rr = do(http.MethodPost, "/token/refresh", "", []byte(`{"refreshtoken":"`+tokens.AccessToken+`"}`))
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr = do(http.MethodPost, "/token/refresh", "", []byte(`{"refreshtoken":"`+tokens.RefreshToken+`"}`))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var refreshed tokenResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&refreshed))
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/internal/movements/balance", "Bearer "+refreshed.AccessToken, nil).Code)
+
+	// revoking the session revokes its tokens
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/logout", "Bearer "+refreshed.AccessToken, nil).Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/internal/movements/balance", "Bearer "+tokens.AccessToken, nil).Code)
+	rr = do(http.MethodPost, "/token/refresh", "", []byte(`{"refreshtoken":"`+refreshed.RefreshToken+`"}`))
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func Test_Handler_API_Deposit(t *testing.T) {
+	tt := []struct {
+		TestName, Body string
+		ServiceError   error
+		ExpectedStatus int
+	}{
+		{"Ok", `{"amount": 100.5, "currencyname": "ars"}`, nil, http.StatusOK},
+		{"OkText", `{"amount": "0.00000001", "currencyname": "btc"}`, nil, http.StatusOK},
+		{"TooManyDecimals", `{"amount": 100.505, "currencyname": "ars"}`, money.ErrorTooManyDecimals, http.StatusBadRequest},
+		{"UnknownCurrency", `{"amount": 1, "currencyname": "eur"}`, movement.ErrorWrongCurrency, http.StatusBadRequest},
+		{"TooLarge", `{"amount": "90000000000000000", "currencyname": "ars"}`, movement.ErrorAmountTooLarge, http.StatusBadRequest},
+		{"FeeExceedsAmount", `{"amount": 1, "currencyname": "ars"}`, movement.ErrorFeeExceedsAmount, http.StatusBadRequest},
+		{"InsufficientFunds", `{"amount": 1, "currencyname": "ars"}`, movement.ErrorInsufficientFunds, http.StatusBadRequest},
+		{"MissingCurrency", `{"amount": 1}`, nil, http.StatusBadRequest},
+		{"Negative", `{"amount": -1, "currencyname": "ars"}`, nil, http.StatusBadRequest},
+		{"Zero", `{"amount": 0, "currencyname": "ars"}`, nil, http.StatusBadRequest},
+		{"Missing", `{"currencyname": "ars"}`, nil, http.StatusBadRequest},
+		{"NotANumber", `{"amount": "ten", "currencyname": "ars"}`, nil, http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		// Given
+		service := &serviceMock{}
+		service.On("ValidateCredential").Return(true, nil)
+		service.On("AutoDeposit").Return(tc.ServiceError)
+		router := mux.NewRouter()
+		API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
+		cookie := loginCookie(t, router)
+
+		// When
+		request, err := http.NewRequest(http.MethodPost, "/internal/movements/deposit", bytes.NewReader([]byte(tc.Body)))
+		require.NoError(t, err)
+		request.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request)
+
+		// Then
+		require.Equal(t, tc.ExpectedStatus, rr.Code, "%s failed. Response: %s", tc.TestName, rr.Body.String())
+	}
+}
+
+func Test_Handler_API_Send(t *testing.T) {
+	tt := []struct {
+		TestName, Body string
+		ServiceError   error
+		ExpectedStatus int
+	}{
+		{"Ok", `{"amount": "10", "currencyname": "usdt", "interactionalias": "other"}`, nil, http.StatusOK},
+		{"OkConversion", `{"amount": "10", "currencyname": "usdt", "destinationcurrency": "ars", "interactionalias": "other"}`, nil, http.StatusOK},
+		{"RateNotFound", `{"amount": "10", "currencyname": "usdt", "destinationcurrency": "btc", "interactionalias": "other"}`, exchange.ErrorRateNotFound, http.StatusBadRequest},
+		{"TooSmall", `{"amount": "0.01", "currencyname": "ars", "destinationcurrency": "usdt", "interactionalias": "other"}`, exchange.ErrorAmountTooSmall, http.StatusBadRequest},
+		{"SameAlias", `{"amount": "10", "currencyname": "usdt", "interactionalias": "sayi"}`, nil, http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		// Given
+		sent := movement.Movement{ID: 1, Type: movement.SendMov, Amount: money.MustParse("10"), CurrencyName: movement.USDT,
+			Conversion: &movement.Conversion{DestinationAmount: money.MustParse("3467.47"), DestinationCurrency: movement.ARS,
+				Rate: money.MustParse("346.7475"), Spread: money.MustParse("35.03")}}
+		service := &serviceMock{}
+		service.On("ValidateCredential").Return(true, nil)
+		service.On("Send").Return(sent, tc.ServiceError)
+		router := mux.NewRouter()
+		API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
+		cookie := loginCookie(t, router)
+
+		// When
+		request, err := http.NewRequest(http.MethodPost, "/internal/movements/send", bytes.NewReader([]byte(tc.Body)))
+		require.NoError(t, err)
+		request.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request)
+
+		// Then
+		require.Equal(t, tc.ExpectedStatus, rr.Code, "%s failed. Response: %s", tc.TestName, rr.Body.String())
+		if tc.ExpectedStatus == http.StatusOK {
+			var response movement.Movement
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&response), tc.TestName)
+			require.Equal(t, "3467.47", response.Conversion.DestinationAmount.String(), tc.TestName)
+			require.Equal(t, "35.03", response.Conversion.Spread.String(), tc.TestName)
+		}
+	}
+}
+
+func Test_Handler_API_QuoteSend(t *testing.T) {
+	// Given
+	service := &serviceMock{}
+	service.On("ValidateCredential").Return(true, nil)
+	service.On("QuoteSend").Return(movement.Movement{Type: movement.SendMov, Amount: money.MustParse("100.00"),
+		CurrencyName: movement.ARS, Fee: money.MustParse("1.50")}, nil)
+	router := mux.NewRouter()
+	API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
+	cookie := loginCookie(t, router)
+
+	// When
+	body := `{"amount": "100", "currencyname": "ars", "interactionalias": "other"}`
+	request, err := http.NewRequest(http.MethodPost, "/internal/movements/send/quote", bytes.NewReader([]byte(body)))
+	require.NoError(t, err)
+	request.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, request)
+
+	// Then
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var response struct {
+		Fee   string `json:"fee"`
+		Total string `json:"total"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	require.Equal(t, "1.50", response.Fee)
+	require.Equal(t, "101.50", response.Total)
+	service.AssertNumberOfCalls(t, "Send", 0)
+}
+
+func Test_Handler_API_Currencies(t *testing.T) {
+	// Given
+	service := &serviceMock{}
+	service.On("GetCurrencies").Return([]movement.Currency{
+		{Code: movement.ARS, Name: "Peso", Digits: 2, Enabled: true},
+		{Code: movement.BTC, Name: "Bitcoin", Digits: 8, Enabled: true},
+	}, nil)
+	router := mux.NewRouter()
+	API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
+
+	// When
+	request, err := http.NewRequest(http.MethodGet, "/currencies", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, request)
+
+	// Then
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.JSONEq(t, `[{"code":"ARS","name":"Peso","digits":2,"enabled":true},`+
+		`{"code":"BTC","name":"Bitcoin","digits":8,"enabled":true}]`, rr.Body.String())
+}
+
+func Test_Handler_API_Exchange(t *testing.T) {
+	tt := []struct {
+		TestName, Path, Body string
+		ServiceError         error
+		ExpectedStatus       int
+	}{
+		{"QuoteOk", "/internal/movements/exchange/quote", `{"amount": "10", "currencyname": "usdt", "destinationcurrency": "ars"}`, nil, http.StatusOK},
+		{"QuoteMissingDestination", "/internal/movements/exchange/quote", `{"amount": "10", "currencyname": "usdt"}`, nil, http.StatusBadRequest},
+		{"QuoteNegative", "/internal/movements/exchange/quote", `{"amount": "-10", "currencyname": "usdt", "destinationcurrency": "ars"}`, nil, http.StatusBadRequest},
+		{"QuoteRateNotFound", "/internal/movements/exchange/quote", `{"amount": "10", "currencyname": "usdt", "destinationcurrency": "btc"}`, exchange.ErrorRateNotFound, http.StatusBadRequest},
+		{"ExchangeOk", "/internal/movements/exchange", `{"quoteid": "id"}`, nil, http.StatusOK},
+		{"ExchangeMissingQuote", "/internal/movements/exchange", `{}`, nil, http.StatusBadRequest},
+		{"ExchangeQuoteNotFound", "/internal/movements/exchange", `{"quoteid": "id"}`, exchange.ErrorQuoteNotFound, http.StatusNotFound},
+		{"ExchangeQuoteExpired", "/internal/movements/exchange", `{"quoteid": "id"}`, exchange.ErrorQuoteExpired, http.StatusGone},
+		{"ExchangeInsufficientFunds", "/internal/movements/exchange", `{"quoteid": "id"}`, movement.ErrorInsufficientFunds, http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		// Given
+		service := &serviceMock{}
+		service.On("ValidateCredential").Return(true, nil)
+		service.On("QuoteExchange").Return(exchange.Quote{ID: "id"}, tc.ServiceError)
+		service.On("Exchange").Return(movement.Movement{ID: 1, Type: movement.ExchangeMov}, tc.ServiceError)
+		router := mux.NewRouter()
+		API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
+		cookie := loginCookie(t, router)
+
+		// When
+		request, err := http.NewRequest(http.MethodPost, tc.Path, bytes.NewReader([]byte(tc.Body)))
+		require.NoError(t, err)
+		request.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request)
+
+		// Then
+		require.Equal(t, tc.ExpectedStatus, rr.Code, "%s failed. Response: %s", tc.TestName, rr.Body.String())
+	}
+}
+
+func Test_Handler_API_Withdrawals(t *testing.T) {
+	tt := []struct {
+		TestName, Method, Path, Body string
+		ServiceError                 error
+		ExpectedStatus               int
+	}{
+		{"CreateOk", http.MethodPost, "/internal/withdrawals", `{"amount": "10", "currencyname": "ars", "destination": "bank"}`, nil, http.StatusOK},
+		{"CreateMissingDestination", http.MethodPost, "/internal/withdrawals", `{"amount": "10", "currencyname": "ars"}`, nil, http.StatusBadRequest},
+		{"CreateNegative", http.MethodPost, "/internal/withdrawals", `{"amount": "-10", "currencyname": "ars", "destination": "bank"}`, nil, http.StatusBadRequest},
+		{"CreateInsufficientFunds", http.MethodPost, "/internal/withdrawals", `{"amount": "10", "currencyname": "ars", "destination": "bank"}`, movement.ErrorInsufficientFunds, http.StatusBadRequest},
+		{"List", http.MethodGet, "/internal/withdrawals", "", nil, http.StatusOK},
+		{"Get", http.MethodGet, "/internal/withdrawals/1", "", nil, http.StatusOK},
+		{"GetNotFound", http.MethodGet, "/internal/withdrawals/1", "", withdrawal.ErrorNotFound, http.StatusNotFound},
+		{"GetWrongID", http.MethodGet, "/internal/withdrawals/abc", "", nil, http.StatusNotFound},
+	}
+
+	for _, tc := range tt {
+		// Given
+		service := &serviceMock{}
+		service.On("ValidateCredential").Return(true, nil)
+		service.On("Withdraw").Return(withdrawal.Withdrawal{ID: 1, Status: withdrawal.StatusPending}, tc.ServiceError)
+		service.On("GetWithdrawals").Return([]withdrawal.Withdrawal{{ID: 1}}, tc.ServiceError)
+		service.On("GetWithdrawal").Return(withdrawal.Withdrawal{ID: 1}, tc.ServiceError)
+		router := mux.NewRouter()
+		API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
+		cookie := loginCookie(t, router)
+
+		// When
+		request, err := http.NewRequest(tc.Method, tc.Path, bytes.NewReader([]byte(tc.Body)))
+		require.NoError(t, err)
+		request.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request)
+
+		// Then
+		require.Equal(t, tc.ExpectedStatus, rr.Code, "%s failed. Response: %s", tc.TestName, rr.Body.String())
+	}
+}
+
+func Test_Handler_API_Holds(t *testing.T) {
+	tt := []struct {
+		TestName, Method, Path, Body string
+		ServiceError                 error
+		ExpectedStatus               int
+	}{
+		{"CreateOk", http.MethodPost, "/internal/holds", `{"amount": "10", "currencyname": "ars", "interactionalias": "merchant", "expiresin": 60}`, nil, http.StatusOK},
+		{"CreateNegative", http.MethodPost, "/internal/holds", `{"amount": "-10", "currencyname": "ars", "interactionalias": "merchant"}`, nil, http.StatusBadRequest},
+		{"CreateToItself", http.MethodPost, "/internal/holds", `{"amount": "10", "currencyname": "ars", "interactionalias": "sayi"}`, nil, http.StatusBadRequest},
+		{"CreateInsufficientFunds", http.MethodPost, "/internal/holds", `{"amount": "10", "currencyname": "ars", "interactionalias": "merchant"}`, movement.ErrorInsufficientFunds, http.StatusBadRequest},
+		{"Get", http.MethodGet, "/internal/holds/1", "", nil, http.StatusOK},
+		{"GetNotFound", http.MethodGet, "/internal/holds/1", "", movement.ErrorHoldNotFound, http.StatusNotFound},
+		{"CaptureAll", http.MethodPost, "/internal/holds/1/capture", "", nil, http.StatusOK},
+		{"CapturePartial", http.MethodPost, "/internal/holds/1/capture", `{"amount": "4"}`, nil, http.StatusOK},
+		{"CaptureMoreThanHeld", http.MethodPost, "/internal/holds/1/capture", `{"amount": "40"}`, movement.ErrorCaptureExceedsHold, http.StatusBadRequest},
+		{"ReleaseOk", http.MethodPost, "/internal/holds/1/release", "", nil, http.StatusOK},
+		{"ReleaseNotActive", http.MethodPost, "/internal/holds/1/release", "", movement.ErrorHoldNotActive, http.StatusConflict},
+	}
+
+	for _, tc := range tt {
+		// Given
+		service := &serviceMock{}
+		service.On("ValidateCredential").Return(true, nil)
+		service.On("CreateHold").Return(movement.Hold{ID: 1, Status: movement.HoldActive}, tc.ServiceError)
+		service.On("GetHold").Return(movement.Hold{ID: 1}, tc.ServiceError)
+		service.On("CaptureHold").Return(movement.Movement{ID: 2}, tc.ServiceError)
+		service.On("ReleaseHold").Return(movement.Hold{ID: 1, Status: movement.HoldReleased}, tc.ServiceError)
+		router := mux.NewRouter()
+		API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{HoldMaxDuration: time.Hour})
+		cookie := loginCookie(t, router)
+
+		// When
+		request, err := http.NewRequest(tc.Method, tc.Path, bytes.NewReader([]byte(tc.Body)))
+		require.NoError(t, err)
+		request.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request)
+
+		// Then
+		require.Equal(t, tc.ExpectedStatus, rr.Code, "%s failed. Response: %s", tc.TestName, rr.Body.String())
+	}
+}
+
+func Test_Handler_API_Balance(t *testing.T) {
+	tt := []struct {
+		TestName, Path string
+		ExpectedStatus int
+		ExpectedCall   string
+	}{
+		{"Current", "/internal/movements/balance", http.StatusOK, "GetBalance"},
+		{"At", "/internal/movements/balance?at=2022-03-01T10:00:00Z", http.StatusOK, "GetAccountBalanceAt"},
+		{"AtDay", "/internal/movements/balance?at=2022-03-01", http.StatusOK, "GetAccountBalanceAt"},
+		{"WrongAt", "/internal/movements/balance?at=yesterday", http.StatusBadRequest, ""},
+		{"FutureAt", "/internal/movements/balance?at=2999-01-01", http.StatusBadRequest, ""},
+	}
+
+	for _, tc := range tt {
+		// Given
+		service := &serviceMock{}
+		service.On("ValidateCredential").Return(true, nil)
+		service.On("GetBalance").Return(movement.AccountBalance{}, nil)
+		service.On("GetAccountBalanceAt").Return(movement.AccountBalanceAt{
+			movement.ARS: {Ledger: money.MustParse("100.00")}}, nil)
+		router := mux.NewRouter()
+		API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
+		cookie := loginCookie(t, router)
+
+		// When
+		request, err := http.NewRequest(http.MethodGet, tc.Path, nil)
+		require.NoError(t, err)
+		request.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request)
+
+		// Then
+		require.Equal(t, tc.ExpectedStatus, rr.Code, "%s failed. Response: %s", tc.TestName, rr.Body.String())
+		if tc.ExpectedCall != "" {
+			service.AssertCalled(t, tc.ExpectedCall)
+		}
+	}
+}
+
+func Test_Handler_API_History(t *testing.T) {
+	tt := []struct {
+		TestName, Path string
+		ServiceError   error
+		ExpectedStatus int
+	}{
+		{"Ok", "/internal/movements/history", nil, http.StatusOK},
+		{"Filtered", "/internal/movements/history?currencyname=ars&type=send&interactionalias=otheruser&from=2022-03-01" +
+			"&to=2022-04-01T00:00:00Z&minamount=10&maxamount=50.5&order=asc&limit=20", nil, http.StatusOK},
+		{"UnknownType", "/internal/movements/history?type=other", nil, http.StatusBadRequest},
+		{"WrongDate", "/internal/movements/history?from=yesterday", nil, http.StatusBadRequest},
+		{"FromAfterTo", "/internal/movements/history?from=2022-04-01&to=2022-03-01", nil, http.StatusBadRequest},
+		{"WrongAmount", "/internal/movements/history?minamount=ten", nil, http.StatusBadRequest},
+		{"WrongOrder", "/internal/movements/history?order=amount", nil, http.StatusBadRequest},
+		{"LimitTooBig", "/internal/movements/history?limit=1000", nil, http.StatusBadRequest},
+		{"WrongCurrency", "/internal/movements/history?currencyname=eur", movement.ErrorWrongCurrency, http.StatusBadRequest},
+		{"WrongCursor", "/internal/movements/history?cursor=abc", movement.ErrorInvalidCursor, http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		// Given
+		service := &serviceMock{}
+		service.On("ValidateCredential").Return(true, nil)
+		service.On("GetHistory").Return(movement.History{Rows: []movement.Row{{ID: 5, Type: movement.SendMov}}, Next: "next"},
+			tc.ServiceError)
+		router := mux.NewRouter()
+		API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
+		cookie := loginCookie(t, router)
+
+		// When
+		request, err := http.NewRequest(http.MethodGet, tc.Path, nil)
+		require.NoError(t, err)
+		request.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request)
+
+		// Then
+		require.Equal(t, tc.ExpectedStatus, rr.Code, "%s failed. Response: %s", tc.TestName, rr.Body.String())
+		if tc.ExpectedStatus == http.StatusOK {
+			var history movement.History
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&history))
+			require.Len(t, history.Rows, 1)
+			require.Equal(t, "next", history.Next)
+		}
+	}
+}
+
+func Test_Handler_API_GetMovement(t *testing.T) {
+	tt := []struct {
+		TestName, Path string
+		ServiceError   error
+		ExpectedStatus int
+	}{
+		{"Ok", "/internal/movements/5", nil, http.StatusOK},
+		// the movements of other users are not found
+		{"NotFound", "/internal/movements/5", movement.ErrorMovementNotFound, http.StatusNotFound},
+		{"NotAnID", "/internal/movements/abc", nil, http.StatusNotFound},
+	}
+
+	for _, tc := range tt {
+		// Given
+		service := &serviceMock{}
+		service.On("ValidateCredential").Return(true, nil)
+		service.On("GetMovement").Return(movement.Movement{ID: 5, Type: movement.SendMov, Status: movement.StatusCompleted}, tc.ServiceError)
+		router := mux.NewRouter()
+		API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
+		cookie := loginCookie(t, router)
+
+		// When
+		request, err := http.NewRequest(http.MethodGet, tc.Path, nil)
+		require.NoError(t, err)
+		request.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request)
+
+		// Then
+		require.Equal(t, tc.ExpectedStatus, rr.Code, "%s failed. Response: %s", tc.TestName, rr.Body.String())
+		if tc.ExpectedStatus == http.StatusOK {
+			var found movement.Movement
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&found))
+			require.Equal(t, int64(5), found.ID)
+			require.Equal(t, movement.StatusCompleted, found.Status)
+		}
+	}
+}
+
+func Test_Handler_API_Statements(t *testing.T) {
+	tt := []struct {
+		TestName, Path string
+		ServiceError   error
+		ExpectedStatus int
+	}{
+		{"Ok", "/internal/statements/2022-03", nil, http.StatusOK},
+		{"NotStarted", "/internal/statements/2099-01", statement.ErrorMonthNotStarted, http.StatusNotFound},
+		{"WrongMonth", "/internal/statements/2022-13", nil, http.StatusNotFound},
+		{"NotAMonth", "/internal/statements/march", nil, http.StatusNotFound},
+	}
+
+	for _, tc := range tt {
+		// Given
+		service := &serviceMock{}
+		service.On("ValidateCredential").Return(true, nil)
+		service.On("GetStatements").Return([]statement.Statement{{Month: "2022-03", CurrencyName: movement.ARS, Closed: true}},
+			tc.ServiceError)
+		router := mux.NewRouter()
+		API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
+		cookie := loginCookie(t, router)
+
+		// When
+		request, err := http.NewRequest(http.MethodGet, tc.Path, nil)
+		require.NoError(t, err)
+		request.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request)
+
+		// Then
+		require.Equal(t, tc.ExpectedStatus, rr.Code, "%s failed. Response: %s", tc.TestName, rr.Body.String())
+		if tc.ExpectedStatus == http.StatusOK {
+			var statements []statement.Statement
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&statements))
+			require.Len(t, statements, 1)
+			require.True(t, statements[0].Closed)
+		}
+	}
+}
+
+func Test_Handler_API_Profile(t *testing.T) {
+	tt := []struct {
+		TestName, Method, Body string
+		ServiceError           error
+		ExpectedStatus         int
+	}{
+		{"Get", http.MethodGet, "", nil, http.StatusOK},
+		{"Update", http.MethodPatch, `{"firstname": "name", "email": "new@email.com"}`, nil, http.StatusOK},
+		{"EmptyName", http.MethodPatch, `{"firstname": ""}`, nil, http.StatusBadRequest},
+		{"WrongEmail", http.MethodPatch, `{"email": "email"}`, nil, http.StatusBadRequest},
+		{"EmailOfOtherUser", http.MethodPatch, `{"email": "new@email.com"}`, user.ErrorAlreadyExist, http.StatusBadRequest},
+		{"Close", http.MethodDelete, "", nil, http.StatusOK},
+		{"CloseWithMoney", http.MethodDelete, "", user.ErrorBalanceNotZero, http.StatusConflict},
+		{"CloseWithWithdrawals", http.MethodDelete, "", user.ErrorPendingWithdrawals, http.StatusConflict},
+	}
+
+	for _, tc := range tt {
+		// Given
+		service := &serviceMock{}
+		service.On("ValidateCredential").Return(true, nil)
+		profile := user.Profile{Alias: "user", FirstName: "name", LastName: "lastname", Email: "new@email.com"}
+		service.On("GetUser").Return(profile, tc.ServiceError)
+		service.On("UpdateUser").Return(profile, tc.ServiceError)
+		service.On("CloseUser").Return(tc.ServiceError)
+		router := mux.NewRouter()
+		API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
+		cookie := loginCookie(t, router)
+
+		// When
+		request, err := http.NewRequest(tc.Method, "/internal/users/me", bytes.NewReader([]byte(tc.Body)))
+		require.NoError(t, err)
+		request.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request)
+
+		// Then
+		require.Equal(t, tc.ExpectedStatus, rr.Code, "%s failed. Response: %s", tc.TestName, rr.Body.String())
+		if tc.ExpectedStatus == http.StatusOK && tc.Method != http.MethodDelete {
+			var response user.Profile
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+			require.Equal(t, profile, response, tc.TestName)
+		}
+	}
+}
+
+func Test_Handler_API_CloseAccount_RevokesTheSessions(t *testing.T) {
+	// Given
+	service := &serviceMock{}
+	service.On("ValidateCredential").Return(true, nil)
+	service.On("CloseUser").Return(nil)
+	router := mux.NewRouter()
+	API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
+	cookie := loginCookie(t, router)
+	request, err := http.NewRequest(http.MethodDelete, "/internal/users/me", nil)
+	require.NoError(t, err)
+	request.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, request)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// When
+	request, err = http.NewRequest(http.MethodGet, "/internal/users/me", nil)
+	require.NoError(t, err)
+	request.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, request)
+
+	// Then
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func Test_Handler_API_Refund(t *testing.T) {
+	tt := []struct {
+		TestName, Path, Body string
+		ServiceError         error
+		ExpectedStatus       int
+	}{
+		{"All", "/internal/movements/5/refund", "", nil, http.StatusOK},
+		{"Partial", "/internal/movements/5/refund", `{"amount": "10"}`, nil, http.StatusOK},
+		{"Negative", "/internal/movements/5/refund", `{"amount": "-10"}`, nil, http.StatusBadRequest},
+		{"NotFound", "/internal/movements/5/refund", "", movement.ErrorMovementNotFound, http.StatusNotFound},
+		{"AlreadyRefunded", "/internal/movements/5/refund", "", movement.ErrorAlreadyRefunded, http.StatusConflict},
+		{"MoreThanTheRest", "/internal/movements/5/refund", `{"amount": "1000"}`, movement.ErrorRefundExceedsMovement, http.StatusBadRequest},
+		{"InsufficientFunds", "/internal/movements/5/refund", "", movement.ErrorInsufficientFunds, http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		// Given
+		service := &serviceMock{}
+		service.On("ValidateCredential").Return(true, nil)
+		service.On("Refund").Return(movement.Movement{ID: 6, RefundOf: 5}, tc.ServiceError)
+		router := mux.NewRouter()
+		API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{})
+		cookie := loginCookie(t, router)
+
+		// When
+		request, err := http.NewRequest(http.MethodPost, tc.Path, bytes.NewReader([]byte(tc.Body)))
+		require.NoError(t, err)
+		request.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request)
+
+		// Then
+		require.Equal(t, tc.ExpectedStatus, rr.Code, "%s failed. Response: %s", tc.TestName, rr.Body.String())
+	}
+}
+
+func loginCookie(t *testing.T, router *mux.Router) *http.Cookie {
+	body, err := ioutil.ReadFile("testdata/login.json")
+	require.NoError(t, err)
+	request, err := http.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, request)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	return rr.Result().Cookies()[0]
+}
+
 type serviceMock struct {
 	mock.Mock
 }
@@ -80,27 +739,133 @@ func (s *serviceMock) CreateUser(ctx context.Context, u user.User) error {
 	return args.Error(0)
 }
 
+func (s *serviceMock) GetUser(ctx context.Context, alias string) (user.Profile, error) {
+	args := s.Called()
+	return args.Get(0).(user.Profile), args.Error(1)
+}
+
+func (s *serviceMock) UpdateUser(ctx context.Context, alias string, update user.ProfileUpdate) (user.Profile, error) {
+	args := s.Called()
+	return args.Get(0).(user.Profile), args.Error(1)
+}
+
+func (s *serviceMock) CloseUser(ctx context.Context, alias string) error {
+	args := s.Called()
+	return args.Error(0)
+}
+
 func (s *serviceMock) GetBalance(ctx context.Context, alias string) (movement.AccountBalance, error) {
 	args := s.Called()
 	return args.Get(0).(movement.AccountBalance), args.Error(1)
 }
 
-func (s *serviceMock) Send(ctx context.Context, m movement.Movement) error {
+func (s *serviceMock) Send(ctx context.Context, m movement.Movement) (movement.Movement, error) {
 	args := s.Called()
-	return args.Error(0)
+	return args.Get(0).(movement.Movement), args.Error(1)
 }
 
-func (s *serviceMock) AutoDeposit(ctx context.Context, m movement.Movement) error {
+func (s *serviceMock) QuoteSend(ctx context.Context, m movement.Movement) (movement.Movement, error) {
 	args := s.Called()
-	return args.Error(0)
+	return args.Get(0).(movement.Movement), args.Error(1)
 }
 
-func (s *serviceMock) GetHistory(ctx context.Context, alias string) (movement.AccountHistory, error) {
+// AutoDeposit returns the movement it receives
+func (s *serviceMock) AutoDeposit(ctx context.Context, m movement.Movement) (movement.Movement, error) {
 	args := s.Called()
-	return args.Get(0).(movement.AccountHistory), args.Error(1)
+	return m, args.Error(0)
+}
+
+func (s *serviceMock) GetAccountBalanceAt(ctx context.Context, alias string, at time.Time) (movement.AccountBalanceAt, error) {
+	args := s.Called()
+	return args.Get(0).(movement.AccountBalanceAt), args.Error(1)
+}
+
+func (s *serviceMock) GetHistory(ctx context.Context, alias string, filter movement.HistoryFilter) (movement.History, error) {
+	args := s.Called()
+	return args.Get(0).(movement.History), args.Error(1)
+}
+
+func (s *serviceMock) ExportHistory(ctx context.Context, alias string, filter movement.HistoryFilter, fn func(movement.Row) error) error {
+	args := s.Called()
+	for _, row := range args.Get(0).([]movement.Row) {
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
+func (s *serviceMock) GetBalanceAt(ctx context.Context, alias, currencyName string, at time.Time) (money.Amount, error) {
+	args := s.Called()
+	return args.Get(0).(money.Amount), args.Error(1)
 }
 
 func (s *serviceMock) ValidateCredential(ctx context.Context, alias, password string) (bool, error) {
 	args := s.Called()
 	return args.Bool(0), args.Error(1)
 }
+
+func (s *serviceMock) Withdraw(ctx context.Context, w withdrawal.Withdrawal) (withdrawal.Withdrawal, error) {
+	args := s.Called()
+	return args.Get(0).(withdrawal.Withdrawal), args.Error(1)
+}
+
+func (s *serviceMock) GetWithdrawals(ctx context.Context, alias string) ([]withdrawal.Withdrawal, error) {
+	args := s.Called()
+	return args.Get(0).([]withdrawal.Withdrawal), args.Error(1)
+}
+
+func (s *serviceMock) GetWithdrawal(ctx context.Context, alias string, id int64) (withdrawal.Withdrawal, error) {
+	args := s.Called()
+	return args.Get(0).(withdrawal.Withdrawal), args.Error(1)
+}
+
+func (s *serviceMock) CreateHold(ctx context.Context, h movement.Hold) (movement.Hold, error) {
+	args := s.Called()
+	return args.Get(0).(movement.Hold), args.Error(1)
+}
+
+func (s *serviceMock) GetHold(ctx context.Context, alias string, id int64) (movement.Hold, error) {
+	args := s.Called()
+	return args.Get(0).(movement.Hold), args.Error(1)
+}
+
+func (s *serviceMock) CaptureHold(ctx context.Context, alias string, id int64, amount money.Amount) (movement.Movement, error) {
+	args := s.Called()
+	return args.Get(0).(movement.Movement), args.Error(1)
+}
+
+func (s *serviceMock) ReleaseHold(ctx context.Context, alias string, id int64) (movement.Hold, error) {
+	args := s.Called()
+	return args.Get(0).(movement.Hold), args.Error(1)
+}
+
+func (s *serviceMock) GetMovement(ctx context.Context, alias string, id int64) (movement.Movement, error) {
+	args := s.Called()
+	return args.Get(0).(movement.Movement), args.Error(1)
+}
+
+func (s *serviceMock) GetStatements(ctx context.Context, alias string, month time.Time) ([]statement.Statement, error) {
+	args := s.Called()
+	return args.Get(0).([]statement.Statement), args.Error(1)
+}
+
+func (s *serviceMock) Refund(ctx context.Context, alias string, id int64, amount money.Amount) (movement.Movement, error) {
+	args := s.Called()
+	return args.Get(0).(movement.Movement), args.Error(1)
+}
+
+func (s *serviceMock) GetCurrencies(ctx context.Context) ([]movement.Currency, error) {
+	args := s.Called()
+	return args.Get(0).([]movement.Currency), args.Error(1)
+}
+
+func (s *serviceMock) QuoteExchange(ctx context.Context, alias string, amount money.Amount, from, to string) (exchange.Quote, error) {
+	args := s.Called()
+	return args.Get(0).(exchange.Quote), args.Error(1)
+}
+
+func (s *serviceMock) Exchange(ctx context.Context, alias, quoteID string) (movement.Movement, error) {
+	args := s.Called()
+	return args.Get(0).(movement.Movement), args.Error(1)
+}
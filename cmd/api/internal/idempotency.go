@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/idempotency"
+)
+
+const (
+	idempotencyKeyHeader   = "Idempotency-Key"
+	maxIdempotencyKey      = 255
+	maxIdempotentBody      = 1 << 20
+	idempotencySaveTimeout = 5 * time.Second
+)
+
+// idempotent makes the handler honor the Idempotency-Key header: the first response of a request is saved
+// with the key, repeating the request within the retention returns the saved response without executing it
+// again, and using the key with a different request returns 422. Requests without the header are not changed.
+// Server errors and panics are not saved so the request can be retried
+func idempotent(store idempotency.Store, retention time.Duration, now func() time.Time) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(key) > maxIdempotencyKey {
+				http.Error(w, "the idempotency key is too long", http.StatusBadRequest)
+				return
+			}
+
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			ctx := r.Context()
+			record := idempotency.Record{
+				Key:         key,
+				Alias:       userAlias(ctx),
+				RequestHash: requestHash(r, body),
+				CreatedAt:   now(),
+			}
+
+			saved, reserved, err := store.Reserve(ctx, record)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if !reserved && saved.CreatedAt.Before(record.CreatedAt.Add(-retention)) {
+				// the key expired, it can be used for a new request
+				if err = store.Release(ctx, saved.Alias, saved.Key); err == nil {
+					saved, reserved, err = store.Reserve(ctx, record)
+				}
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+
+			if !reserved {
+				switch {
+				case saved.RequestHash != record.RequestHash:
+					http.Error(w, "the idempotency key was used with a different request", http.StatusUnprocessableEntity)
+				case !saved.Completed():
+					http.Error(w, "a request with the same idempotency key is in progress", http.StatusConflict)
+				default:
+					if saved.ContentType != "" {
+						w.Header().Set("Content-Type", saved.ContentType)
+					}
+					w.Header().Set("Idempotent-Replayed", "true")
+					w.WriteHeader(saved.StatusCode)
+					w.Write(saved.Body)
+				}
+				return
+			}
+
+			defer func() {
+				if v := recover(); v != nil {
+					ctx, cancel := detachedContext()
+					defer cancel()
+					if err := store.Release(ctx, record.Alias, record.Key); err != nil {
+						log.Printf("releasing idempotency key %s of %s: %v", record.Key, record.Alias, err)
+					}
+					panic(v)
+				}
+			}()
+
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			saveCtx, cancel := detachedContext()
+			defer cancel()
+			if rec.status >= http.StatusInternalServerError {
+				err = store.Release(saveCtx, record.Alias, record.Key)
+			} else {
+				err = store.Complete(saveCtx, record.Alias, record.Key, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes())
+			}
+			if err != nil {
+				log.Printf("saving idempotency key %s of %s: %v", record.Key, record.Alias, err)
+			}
+		})
+	}
+}
+
+// detachedContext returns the context to save the response, the context of the request is cancelled when
+// the client times out and the key would stay in progress
+func detachedContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), idempotencySaveTimeout)
+}
+
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder writes the response and keeps a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
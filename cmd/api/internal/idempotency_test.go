@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spolia/wallet-api/internal/wallet/idempotency"
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+	"github.com/spolia/wallet-api/internal/wallet/session"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Idempotency(t *testing.T) {
+	// Given
+	service := &serviceMock{}
+	service.On("ValidateCredential").Return(true, nil)
+	service.On("Send").Return(movement.Movement{}, nil).Once()
+	service.On("Send").Return(movement.Movement{}, errors.New("database error")).Once()
+	service.On("Send").Return(movement.Movement{}, nil).Once()
+	router := mux.NewRouter()
+	API(router, service, session.NewMemory(), idempotency.NewMemory(), Config{IdempotencyRetention: time.Hour})
+	cookie := loginCookie(t, router)
+
+	send := func(key, body string) *httptest.ResponseRecorder {
+		request, err := http.NewRequest(http.MethodPost, "/internal/movements/send", bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		request.AddCookie(cookie)
+		if key != "" {
+			request.Header.Set(idempotencyKeyHeader, key)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request)
+		return rr
+	}
+	body := `{"amount": 100, "currencyname": "usdt", "interactionalias": "other"}`
+
+	// When
+	first := send("key-1", body)
+	repeated := send("key-1", body)
+
+	// Then
+	require.Equal(t, http.StatusOK, first.Code)
+	require.Equal(t, http.StatusOK, repeated.Code)
+	require.Equal(t, first.Body.String(), repeated.Body.String())
+	require.Equal(t, first.Header().Get("Content-Type"), repeated.Header().Get("Content-Type"))
+	require.Equal(t, "true", repeated.Header().Get("Idempotent-Replayed"))
+	service.AssertNumberOfCalls(t, "Send", 1)
+
+	// the key is used with a different request
+	require.Equal(t, http.StatusUnprocessableEntity, send("key-1", `{"amount": 200, "currencyname": "usdt", "interactionalias": "other"}`).Code)
+	service.AssertNumberOfCalls(t, "Send", 1)
+
+	// server errors are not saved so the request can be retried
+	require.Equal(t, http.StatusInternalServerError, send("key-2", body).Code)
+	require.Equal(t, http.StatusOK, send("key-2", body).Code)
+	service.AssertNumberOfCalls(t, "Send", 3)
+
+	// requests without key are not changed, the validation errors are saved too
+	require.Equal(t, http.StatusBadRequest, send("", `{}`).Code)
+	require.Equal(t, http.StatusBadRequest, send("key-3", `{}`).Code)
+	require.Equal(t, "true", send("key-3", `{}`).Header().Get("Idempotent-Replayed"))
+}
+
+func Test_Idempotency_InProgressAndExpired(t *testing.T) {
+	// Given
+	store := idempotency.NewMemory()
+	now := time.Now()
+	calls := 0
+	handler := idempotent(store, time.Hour, func() time.Time { return now })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("ok"))
+	}))
+
+	request := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte("body")))
+		r.Header.Set(idempotencyKeyHeader, "key")
+		r = r.WithContext(context.WithValue(r.Context(), sessionContextKey, session.Session{Alias: "user"}))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+		return rr
+	}
+
+	// When
+	_, _, err := store.Reserve(context.Background(), idempotency.Record{
+		Key: "key", Alias: "user", RequestHash: requestHash(httptest.NewRequest(http.MethodPost, "/", nil), []byte("body")),
+		CreatedAt: now,
+	})
+	require.NoError(t, err)
+
+	// Then
+	require.Equal(t, http.StatusConflict, request().Code)
+	require.Equal(t, 0, calls)
+
+	now = now.Add(2 * time.Hour)
+	require.Equal(t, http.StatusOK, request().Code)
+	require.Equal(t, 1, calls)
+	require.Equal(t, http.StatusOK, request().Code)
+	require.Equal(t, 1, calls)
+}
+
+func Test_Idempotency_When_ClientLeavesOrHandlerPanics_Then_KeyIsNotInProgress(t *testing.T) {
+	// Given
+	store := idempotency.NewMemory()
+	now := time.Now()
+	calls, panics := 0, true
+	handler := idempotent(store, time.Hour, func() time.Time { return now })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/panic" && panics {
+			panics = false
+			panic("handler failed")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`"ok"`))
+	}))
+
+	request := func(path string, cancelled bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader([]byte("body")))
+		r.Header.Set(idempotencyKeyHeader, path)
+		ctx, cancel := context.WithCancel(context.WithValue(r.Context(), sessionContextKey, session.Session{Alias: "user"}))
+		if cancelled {
+			// the client timed out
+			cancel()
+		}
+		defer cancel()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r.WithContext(ctx))
+		return rr
+	}
+
+	// When
+	request("/cancelled", true)
+	replayed := request("/cancelled", false)
+
+	// Then
+	require.Equal(t, http.StatusOK, replayed.Code)
+	require.Equal(t, "true", replayed.Header().Get("Idempotent-Replayed"))
+	require.Equal(t, "application/json", replayed.Header().Get("Content-Type"))
+	require.Equal(t, `"ok"`, replayed.Body.String())
+	require.Equal(t, 1, calls)
+
+	// When
+	require.Panics(t, func() { request("/panic", false) })
+	retried := request("/panic", false)
+
+	// Then
+	require.Equal(t, http.StatusOK, retried.Code)
+	require.Empty(t, retried.Header().Get("Idempotent-Replayed"))
+	require.Equal(t, 3, calls)
+}
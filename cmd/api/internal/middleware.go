@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/spolia/wallet-api/internal/wallet/session"
+)
+
+type contextKey int
+
+const sessionContextKey contextKey = iota
+
+// authenticate rejects the requests without a valid session cookie or bearer token,
+// the session is available to the handlers through sessionFromContext
+func authenticate(sessions *sessionManager) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			current, ok := sessions.currentSession(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "log in is required", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionContextKey, current)))
+		})
+	}
+}
+
+func sessionFromContext(ctx context.Context) session.Session {
+	current, _ := ctx.Value(sessionContextKey).(session.Session)
+	return current
+}
+
+// userAlias returns the alias of the authenticated user
+func userAlias(ctx context.Context) string {
+	return sessionFromContext(ctx).Alias
+}
@@ -3,32 +3,87 @@ package internal
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/spolia/wallet-api/internal/wallet/exchange"
+	"github.com/spolia/wallet-api/internal/wallet/idempotency"
+	"github.com/spolia/wallet-api/internal/wallet/money"
 	"github.com/spolia/wallet-api/internal/wallet/movement"
+	"github.com/spolia/wallet-api/internal/wallet/session"
+	"github.com/spolia/wallet-api/internal/wallet/statement"
 	"github.com/spolia/wallet-api/internal/wallet/user"
+	"github.com/spolia/wallet-api/internal/wallet/withdrawal"
 )
 
 type Service interface {
 	CreateUser(ctx context.Context, u user.User) error
+	GetUser(ctx context.Context, alias string) (user.Profile, error)
+	UpdateUser(ctx context.Context, alias string, update user.ProfileUpdate) (user.Profile, error)
+	CloseUser(ctx context.Context, alias string) error
 	GetBalance(ctx context.Context, alias string) (movement.AccountBalance, error)
-	Send(ctx context.Context, m movement.Movement) error
-	AutoDeposit(ctx context.Context, m movement.Movement) error
-	GetHistory(ctx context.Context, alias string) (movement.AccountHistory, error)
+	GetAccountBalanceAt(ctx context.Context, alias string, at time.Time) (movement.AccountBalanceAt, error)
+	Send(ctx context.Context, m movement.Movement) (movement.Movement, error)
+	QuoteSend(ctx context.Context, m movement.Movement) (movement.Movement, error)
+	AutoDeposit(ctx context.Context, m movement.Movement) (movement.Movement, error)
+	GetHistory(ctx context.Context, alias string, filter movement.HistoryFilter) (movement.History, error)
+	ExportHistory(ctx context.Context, alias string, filter movement.HistoryFilter, fn func(movement.Row) error) error
+	GetBalanceAt(ctx context.Context, alias, currencyName string, at time.Time) (money.Amount, error)
 	ValidateCredential(ctx context.Context, alias, password string) (bool, error)
+	GetCurrencies(ctx context.Context) ([]movement.Currency, error)
+	QuoteExchange(ctx context.Context, alias string, amount money.Amount, from, to string) (exchange.Quote, error)
+	Exchange(ctx context.Context, alias, quoteID string) (movement.Movement, error)
+	Withdraw(ctx context.Context, w withdrawal.Withdrawal) (withdrawal.Withdrawal, error)
+	GetWithdrawals(ctx context.Context, alias string) ([]withdrawal.Withdrawal, error)
+	GetWithdrawal(ctx context.Context, alias string, id int64) (withdrawal.Withdrawal, error)
+	CreateHold(ctx context.Context, h movement.Hold) (movement.Hold, error)
+	GetHold(ctx context.Context, alias string, id int64) (movement.Hold, error)
+	CaptureHold(ctx context.Context, alias string, id int64, amount money.Amount) (movement.Movement, error)
+	ReleaseHold(ctx context.Context, alias string, id int64) (movement.Hold, error)
+	Refund(ctx context.Context, alias string, id int64, amount money.Amount) (movement.Movement, error)
+	GetMovement(ctx context.Context, alias string, id int64) (movement.Movement, error)
+	GetStatements(ctx context.Context, alias string, month time.Time) ([]statement.Statement, error)
 }
 
-func API(r *mux.Router, service Service) {
-	r.HandleFunc("/login", login(service)).Methods(http.MethodPost)
-	r.HandleFunc("/logout", logout).Methods(http.MethodPost)
-	r.HandleFunc("/internal/movements/balance", getBalance(service)).Methods(http.MethodGet)
-	r.HandleFunc("/internal/movements/history", getHistory(service)).Methods(http.MethodGet)
-	r.HandleFunc("/internal/movements/send", send(service)).Methods(http.MethodPost)
+func API(r *mux.Router, service Service, sessionStore session.Store, idempotencyStore idempotency.Store, config Config) {
+	sessions := newSessionManager(config.Session, newTokenIssuer(config.Token), sessionStore)
+	idempotentRequest := idempotent(idempotencyStore, config.IdempotencyRetention, time.Now)
+
+	r.HandleFunc("/login", login(service, sessions)).Methods(http.MethodPost)
+	r.HandleFunc("/logout", logout(sessions)).Methods(http.MethodPost)
+	r.HandleFunc("/token/refresh", refreshToken(sessions)).Methods(http.MethodPost)
+	r.HandleFunc("/currencies", getCurrencies(service)).Methods(http.MethodGet)
+
+	// every route under /internal requires a session cookie or a bearer token
+	internal := r.PathPrefix("/internal").Subrouter()
+	internal.Use(authenticate(sessions))
+	internal.HandleFunc("/sessions", listSessions(sessions)).Methods(http.MethodGet)
+	internal.HandleFunc("/sessions", revokeAllSessions(sessions)).Methods(http.MethodDelete)
+	internal.HandleFunc("/sessions/{id}", revokeSession(sessions)).Methods(http.MethodDelete)
+	internal.HandleFunc("/users/me", getProfile(service)).Methods(http.MethodGet)
+	internal.HandleFunc("/users/me", updateProfile(service)).Methods(http.MethodPatch)
+	internal.HandleFunc("/users/me", closeAccount(service, sessions)).Methods(http.MethodDelete)
+	internal.HandleFunc("/movements/balance", getBalance(service)).Methods(http.MethodGet)
+	internal.HandleFunc("/movements/history", getHistory(service)).Methods(http.MethodGet)
+	internal.HandleFunc("/movements/history/export", exportHistory(service)).Methods(http.MethodGet)
+	internal.Handle("/movements/send", idempotentRequest(send(service))).Methods(http.MethodPost)
+	internal.HandleFunc("/movements/send/quote", quoteSend(service)).Methods(http.MethodPost)
+	internal.HandleFunc("/movements/exchange/quote", quoteExchange(service)).Methods(http.MethodPost)
+	internal.HandleFunc("/movements/{id:[0-9]+}", getMovement(service)).Methods(http.MethodGet)
+	internal.Handle("/movements/{id:[0-9]+}/refund", idempotentRequest(refund(service))).Methods(http.MethodPost)
+	internal.Handle("/movements/exchange", idempotentRequest(exchangeMoney(service))).Methods(http.MethodPost)
+	internal.Handle("/withdrawals", idempotentRequest(withdraw(service))).Methods(http.MethodPost)
+	internal.HandleFunc("/withdrawals", getWithdrawals(service)).Methods(http.MethodGet)
+	internal.HandleFunc("/withdrawals/{id}", getWithdrawal(service)).Methods(http.MethodGet)
+	internal.Handle("/holds", idempotentRequest(createHold(service, config.HoldMaxDuration))).Methods(http.MethodPost)
+	internal.HandleFunc("/holds/{id}", getHold(service)).Methods(http.MethodGet)
+	internal.Handle("/holds/{id}/capture", idempotentRequest(captureHold(service))).Methods(http.MethodPost)
+	internal.HandleFunc("/holds/{id}/release", releaseHold(service)).Methods(http.MethodPost)
+	internal.HandleFunc("/statements/{month:[0-9]{4}-[0-9]{2}}", getStatements(service)).Methods(http.MethodGet)
 
 	// Useful to test with more users and fund accounts of different currencies
 	r.HandleFunc("/users", createUser(service)).Methods(http.MethodPost)
-	r.HandleFunc("/internal/movements/deposit", deposit(service)).Methods(http.MethodPost)
+	internal.Handle("/movements/deposit", idempotentRequest(deposit(service))).Methods(http.MethodPost)
 }
 
 // hacer la respuesta de history mas linda
-// encrypt passport
@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/spolia/wallet-api/internal/wallet/session"
+)
+
+const sessionCookieName = "session"
+
+// KeyPair holds the keys used to authenticate and encrypt the session cookie.
+type KeyPair struct {
+	HashKey  []byte
+	BlockKey []byte
+}
+
+// SessionConfig configures the session cookie and the session timeouts.
+// The first key pair signs new cookies, the remaining ones are only used to read cookies
+// signed before a rotation.
+type SessionConfig struct {
+	Keys            []KeyPair
+	Secure          bool
+	HTTPOnly        bool
+	SameSite        http.SameSite
+	MaxAge          time.Duration
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+}
+
+// touchInterval avoids writing the last seen time of a session on every request
+const touchInterval = time.Minute
+
+type sessionManager struct {
+	codecs []securecookie.Codec
+	config SessionConfig
+	tokens *tokenIssuer
+	store  session.Store
+	now    func() time.Time
+}
+
+func newSessionManager(config SessionConfig, tokens *tokenIssuer, store session.Store) *sessionManager {
+	if len(config.Keys) == 0 {
+		log.Println("no session keys configured, using random keys: sessions will not survive a restart")
+		config.Keys = []KeyPair{{
+			HashKey:  securecookie.GenerateRandomKey(64),
+			BlockKey: securecookie.GenerateRandomKey(32),
+		}}
+	}
+
+	pairs := make([][]byte, 0, len(config.Keys)*2)
+	for _, k := range config.Keys {
+		pairs = append(pairs, k.HashKey, k.BlockKey)
+	}
+
+	codecs := securecookie.CodecsFromPairs(pairs...)
+	for _, c := range codecs {
+		if s, ok := c.(*securecookie.SecureCookie); ok {
+			s.MaxAge(int(config.MaxAge.Seconds()))
+		}
+	}
+
+	return &sessionManager{codecs: codecs, config: config, tokens: tokens, store: store, now: time.Now}
+}
+
+func (s *sessionManager) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   s.config.Secure,
+		HttpOnly: s.config.HTTPOnly,
+		SameSite: s.config.SameSite,
+	}
+}
+
+func (s *sessionManager) clearSession(w http.ResponseWriter) {
+	http.SetCookie(w, s.cookie("", -1))
+}
+
+// createSession stores a new session for the user
+func (s *sessionManager) createSession(alias string, r *http.Request) (session.Session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return session.Session{}, err
+	}
+
+	now := s.now()
+	userAgent := r.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	created := session.Session{
+		ID:         id,
+		Alias:      alias,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		UserAgent:  userAgent,
+		IP:         ip,
+	}
+
+	if err = s.store.Create(r.Context(), created); err != nil {
+		return session.Session{}, err
+	}
+
+	return created, nil
+}
+
+// setSession creates a new session for the user and sends its id in the session cookie
+func (s *sessionManager) setSession(alias string, w http.ResponseWriter, r *http.Request) error {
+	created, err := s.createSession(alias, r)
+	if err != nil {
+		return err
+	}
+
+	value := map[string]string{
+		"sid": created.ID,
+	}
+
+	encoded, err := securecookie.EncodeMulti(sessionCookieName, value, s.codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, s.cookie(encoded, int(s.config.MaxAge.Seconds())))
+	return nil
+}
+
+// currentSession returns the session of the request, taken from the bearer token if the request has one
+// or from the session cookie otherwise
+func (s *sessionManager) currentSession(request *http.Request) (session.Session, bool) {
+	if authorization := request.Header.Get("Authorization"); authorization != "" {
+		const prefix = "Bearer "
+		if s.tokens == nil || len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+			return session.Session{}, false
+		}
+
+		claims, err := s.tokens.parse(authorization[len(prefix):], accessTokenType)
+		if err != nil {
+			return session.Session{}, false
+		}
+
+		return s.validSession(request.Context(), claims.SessionID, claims.Subject)
+	}
+
+	cookie, err := request.Cookie(sessionCookieName)
+	if err != nil {
+		return session.Session{}, false
+	}
+
+	cookieValue := make(map[string]string)
+	if err = securecookie.DecodeMulti(sessionCookieName, cookie.Value, &cookieValue, s.codecs...); err != nil {
+		return session.Session{}, false
+	}
+
+	return s.validSession(request.Context(), cookieValue["sid"], "")
+}
+
+// validSession returns the session if it exists and did not time out, expired sessions are revoked.
+// When alias is not empty the session must belong to that user
+func (s *sessionManager) validSession(ctx context.Context, id, alias string) (session.Session, bool) {
+	current, err := s.store.Get(ctx, id)
+	if err != nil {
+		if err != session.ErrorNotFound {
+			log.Printf("getting session: %v", err)
+		}
+		return session.Session{}, false
+	}
+
+	if alias != "" && current.Alias != alias {
+		return session.Session{}, false
+	}
+
+	now := s.now()
+	if current.Expired(now, s.config.IdleTimeout, s.config.AbsoluteTimeout) {
+		if err = s.store.Revoke(ctx, current.Alias, current.ID); err != nil && err != session.ErrorNotFound {
+			log.Printf("revoking expired session: %v", err)
+		}
+		return session.Session{}, false
+	}
+
+	if now.Sub(current.LastSeenAt) > touchInterval {
+		if err = s.store.Touch(ctx, current.ID, now); err != nil {
+			log.Printf("touching session: %v", err)
+		}
+		current.LastSeenAt = now
+	}
+
+	return current, true
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/spolia/wallet-api/internal/wallet/session"
+	"github.com/stretchr/testify/require"
+)
+
+func newSessionRequest(t *testing.T, sessions *sessionManager, alias string) *http.Request {
+	rr := httptest.NewRecorder()
+	require.NoError(t, sessions.setSession(alias, rr, httptest.NewRequest(http.MethodPost, "/login", nil)))
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.AddCookie(rr.Result().Cookies()[0])
+	return request
+}
+
+func Test_SessionManager_KeyRotation(t *testing.T) {
+	// Given
+	store := session.NewMemory()
+	oldKey := KeyPair{HashKey: securecookie.GenerateRandomKey(64), BlockKey: securecookie.GenerateRandomKey(32)}
+	newKey := KeyPair{HashKey: securecookie.GenerateRandomKey(64), BlockKey: securecookie.GenerateRandomKey(32)}
+	before := newSessionManager(SessionConfig{Keys: []KeyPair{oldKey}, MaxAge: time.Hour}, nil, store)
+	after := newSessionManager(SessionConfig{Keys: []KeyPair{newKey, oldKey}, MaxAge: time.Hour}, nil, store)
+	removed := newSessionManager(SessionConfig{Keys: []KeyPair{newKey}, MaxAge: time.Hour}, nil, store)
+
+	// When
+	request := newSessionRequest(t, before, "user")
+
+	// Then
+	current, ok := after.currentSession(request)
+	require.True(t, ok)
+	require.Equal(t, "user", current.Alias)
+	_, ok = removed.currentSession(request)
+	require.False(t, ok)
+}
+
+func Test_SessionManager_CookieAttributes(t *testing.T) {
+	// Given
+	sessions := newSessionManager(SessionConfig{
+		Secure:   true,
+		HTTPOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   time.Hour,
+	}, nil, session.NewMemory())
+
+	// When
+	rr := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/login", nil)
+	request.Header.Set("User-Agent", "test-agent")
+	require.NoError(t, sessions.setSession("user", rr, request))
+
+	// Then
+	cookies := rr.Result().Cookies()
+	require.Len(t, cookies, 1)
+	require.Equal(t, sessionCookieName, cookies[0].Name)
+	require.True(t, cookies[0].Secure)
+	require.True(t, cookies[0].HttpOnly)
+	require.Equal(t, http.SameSiteStrictMode, cookies[0].SameSite)
+	require.Equal(t, 3600, cookies[0].MaxAge)
+
+	stored, err := sessions.store.List(context.Background(), "user")
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	require.Equal(t, "test-agent", stored[0].UserAgent)
+	require.Equal(t, "192.0.2.1", stored[0].IP)
+}
+
+func Test_SessionManager_Timeouts(t *testing.T) {
+	tt := []struct {
+		TestName      string
+		Requests      []time.Duration
+		ExpectedValid bool
+	}{
+		{"Active", []time.Duration{10 * time.Minute, 20 * time.Minute, 30 * time.Minute}, true},
+		{"Idle", []time.Duration{31 * time.Minute}, false},
+		{"ActiveButTooOld", []time.Duration{25 * time.Minute, 50 * time.Minute, 75 * time.Minute, 100 * time.Minute,
+			125 * time.Minute}, false},
+	}
+
+	for _, tc := range tt {
+		// Given
+		start := time.Now()
+		now := start
+		sessions := newSessionManager(SessionConfig{IdleTimeout: 30 * time.Minute, AbsoluteTimeout: 2 * time.Hour},
+			nil, session.NewMemory())
+		sessions.now = func() time.Time { return now }
+		request := newSessionRequest(t, sessions, "user")
+
+		// When
+		var valid bool
+		for _, d := range tc.Requests {
+			now = start.Add(d)
+			_, valid = sessions.currentSession(request)
+		}
+
+		// Then
+		require.Equal(t, tc.ExpectedValid, valid, tc.TestName)
+		stored, err := sessions.store.List(context.Background(), "user")
+		require.NoError(t, err)
+		require.Equal(t, tc.ExpectedValid, len(stored) == 1, "%s: expired sessions are revoked", tc.TestName)
+	}
+}
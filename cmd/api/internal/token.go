@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/spolia/wallet-api/internal/wallet/session"
+)
+
+const (
+	accessTokenType  = "access"
+	refreshTokenType = "refresh"
+)
+
+var errInvalidToken = errors.New("invalid token")
+
+// TokenConfig configures the bearer tokens.
+// The first key signs new tokens, the remaining ones are only used to verify tokens
+// signed before a rotation.
+type TokenConfig struct {
+	Keys       [][]byte
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// tokenClaims are the claims of both access and refresh tokens, they are bound to a server side
+// session so revoking the session also revokes its tokens.
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid"`
+	Type      string `json:"typ"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"accesstoken"`
+	RefreshToken string `json:"refreshtoken"`
+	TokenType    string `json:"tokentype"`
+	ExpiresIn    int    `json:"expiresin"`
+}
+
+type tokenIssuer struct {
+	keys   map[string][]byte
+	kid    string
+	config TokenConfig
+}
+
+func newTokenIssuer(config TokenConfig) *tokenIssuer {
+	if len(config.Keys) == 0 {
+		log.Println("no token keys configured, using a random key: tokens will not survive a restart")
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatal(err)
+		}
+		config.Keys = [][]byte{key}
+	}
+
+	t := &tokenIssuer{keys: make(map[string][]byte, len(config.Keys)), config: config}
+	for i, k := range config.Keys {
+		kid := keyID(k)
+		if i == 0 {
+			t.kid = kid
+		}
+		t.keys[kid] = k
+	}
+
+	return t
+}
+
+// keyID identifies a key without exposing it, so tokens can be verified after the key order changes
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// issue signs a new access and refresh token pair for the session
+func (t *tokenIssuer) issue(s session.Session, now time.Time) (tokenResponse, error) {
+	access, err := t.sign(s, accessTokenType, now, t.config.AccessTTL)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	refresh, err := t.sign(s, refreshTokenType, now, t.config.RefreshTTL)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	return tokenResponse{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(t.config.AccessTTL.Seconds()),
+	}, nil
+}
+
+func (t *tokenIssuer) sign(s session.Session, tokenType string, now time.Time, ttl time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   s.Alias,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		SessionID: s.ID,
+		Type:      tokenType,
+	})
+	token.Header["kid"] = t.kid
+
+	return token.SignedString(t.keys[t.kid])
+}
+
+// parse verifies the token signature, expiry and type, and returns its claims
+func (t *tokenIssuer) parse(value, tokenType string) (tokenClaims, error) {
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(value, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := t.keys[kid]
+		if !ok {
+			return nil, errInvalidToken
+		}
+		return key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return tokenClaims{}, errInvalidToken
+	}
+
+	if claims.Type != tokenType || claims.SessionID == "" {
+		return tokenClaims{}, errInvalidToken
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/session"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_TokenIssuer(t *testing.T) {
+	// Given
+	oldKey, newKey := make([]byte, 32), make([]byte, 32)
+	newKey[0] = 1
+	config := TokenConfig{AccessTTL: time.Minute, RefreshTTL: time.Hour}
+	config.Keys = [][]byte{oldKey}
+	before := newTokenIssuer(config)
+	config.Keys = [][]byte{newKey, oldKey}
+	after := newTokenIssuer(config)
+	config.Keys = [][]byte{newKey}
+	removed := newTokenIssuer(config)
+
+	// When
+	tokens, err := before.issue(session.Session{ID: "sid", Alias: "user"}, time.Now())
+	require.NoError(t, err)
+
+	// Then
+	require.Equal(t, "Bearer", tokens.TokenType)
+	require.Equal(t, 60, tokens.ExpiresIn)
+
+	claims, err := after.parse(tokens.AccessToken, accessTokenType)
+	require.NoError(t, err)
+	require.Equal(t, "sid", claims.SessionID)
+	require.Equal(t, "user", claims.Subject)
+
+	_, err = after.parse(tokens.RefreshToken, refreshTokenType)
+	require.NoError(t, err)
+
+	_, err = after.parse(tokens.RefreshToken, accessTokenType)
+	require.Error(t, err, "a refresh token is not an access token")
+
+	_, err = removed.parse(tokens.AccessToken, accessTokenType)
+	require.Error(t, err, "the signing key was removed")
+}
+
+func Test_TokenIssuer_Expired(t *testing.T) {
+	// Given
+	issuer := newTokenIssuer(TokenConfig{Keys: [][]byte{make([]byte, 32)}, AccessTTL: time.Minute, RefreshTTL: time.Hour})
+
+	// When
+	tokens, err := issuer.issue(session.Session{ID: "sid", Alias: "user"}, time.Now().Add(-2*time.Minute))
+	require.NoError(t, err)
+
+	// Then
+	_, err = issuer.parse(tokens.AccessToken, accessTokenType)
+	require.Error(t, err)
+	_, err = issuer.parse(tokens.RefreshToken, refreshTokenType)
+	require.NoError(t, err)
+}
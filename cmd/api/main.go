@@ -1,31 +1,169 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
+	"github.com/joho/godotenv"
 	"github.com/spolia/wallet-api/cmd/api/internal"
 	"github.com/spolia/wallet-api/internal/wallet"
+	"github.com/spolia/wallet-api/internal/wallet/exchange"
+	"github.com/spolia/wallet-api/internal/wallet/fee"
+	"github.com/spolia/wallet-api/internal/wallet/idempotency"
 	"github.com/spolia/wallet-api/internal/wallet/movement"
+	"github.com/spolia/wallet-api/internal/wallet/session"
+	"github.com/spolia/wallet-api/internal/wallet/statement"
 	"github.com/spolia/wallet-api/internal/wallet/user"
+	"github.com/spolia/wallet-api/internal/wallet/withdrawal"
 )
 
 func main() {
 	log.Println("starting")
 
+	// the .env file is optional, variables already set in the environment take precedence
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+
+	config, err := internal.ConfigFromEnv(os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	db, err := sql.Open("mysql", "tester:secret@tcp(db:3306)/test?charset=utf8&parseTime=True&loc=Local")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	service := wallet.New(user.New(db), movement.New(db))
+	// the currencies are loaded once, adding a currency requires a restart
+	currencies, err := movement.LoadRegistry(context.Background(), db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rates := exchange.StaticRates{}
+	if config.Exchange.RatesFile != "" {
+		if rates, err = exchange.LoadRates(config.Exchange.RatesFile); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		log.Println("no exchange rates file configured, exchanges are disabled")
+	}
+
+	quotes := exchange.New(db)
+	quoter := exchange.NewQuoter(rates, quotes, currencies, config.Exchange.QuoteTTL, config.Exchange.Spread)
+
+	movements := movement.New(db, currencies)
+	var rules []fee.Rule
+	if config.FeeRulesFile != "" {
+		if rules, err = fee.LoadRules(config.FeeRulesFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fees, err := fee.NewEngine(rules, movements, currencies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	payout, err := internal.NewPayout(config.Payout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if config.Payout.Provider == internal.PayoutFake {
+		log.Println("using the fake payout provider, the withdrawals are settled without being paid")
+	}
+
+	withdrawals := withdrawal.New(db)
+	statements := statement.NewGenerator(statement.New(db, currencies), movements, currencies)
+	service := wallet.New(user.New(db, user.DefaultHashCost), movements, currencies, quoter, fees, withdrawals, statements)
 	log.Println("service successfully configured")
 
+	idempotencyStore := idempotency.New(db)
+	go purgeIdempotencyKeys(idempotencyStore, config.IdempotencyRetention)
+	sessions := session.New(db)
+	go purgeSessions(sessions, config.Session.IdleTimeout, config.Session.AbsoluteTimeout)
+	go purgeExchangeQuotes(quotes)
+	go processWithdrawals(withdrawal.NewProcessor(withdrawals, payout, movements, currencies), config.WithdrawalInterval)
+	go expireHolds(movements, config.HoldExpiryInterval)
+	if config.LedgerVerifyInterval > 0 {
+		go verifyLedger(movements, config.LedgerVerifyInterval)
+	}
+
 	router := mux.NewRouter()
-	internal.API(router, service)
+	internal.API(router, service, sessions, idempotencyStore, config)
 	// localhost:8080
 	http.ListenAndServe(":8080", router)
 }
+
+// purgeIdempotencyKeys deletes the expired idempotency keys every hour
+func purgeIdempotencyKeys(store idempotency.Store, retention time.Duration) {
+	for range time.Tick(time.Hour) {
+		if err := store.DeleteExpired(context.Background(), time.Now().Add(-retention)); err != nil {
+			log.Printf("purging idempotency keys: %v", err)
+		}
+	}
+}
+
+// purgeSessions deletes the expired sessions every hour
+func purgeSessions(store session.Store, idleTimeout, absoluteTimeout time.Duration) {
+	for range time.Tick(time.Hour) {
+		if err := store.DeleteExpired(context.Background(), time.Now(), idleTimeout, absoluteTimeout); err != nil {
+			log.Printf("purging sessions: %v", err)
+		}
+	}
+}
+
+// purgeExchangeQuotes deletes the expired exchange quotes every hour
+func purgeExchangeQuotes(store exchange.Store) {
+	for range time.Tick(time.Hour) {
+		if err := store.DeleteExpired(context.Background(), time.Now()); err != nil {
+			log.Printf("purging exchange quotes: %v", err)
+		}
+	}
+}
+
+// processWithdrawals sends the withdrawals to the payout provider and updates their status
+func processWithdrawals(processor *withdrawal.Processor, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := processor.Process(context.Background()); err != nil {
+			log.Printf("processing withdrawals: %v", err)
+		}
+	}
+}
+
+// expireHolds marks the holds that expired, the expired holds stop holding money even before they are marked
+func expireHolds(holds interface {
+	ExpireHolds(ctx context.Context, before time.Time) error
+}, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := holds.ExpireHolds(context.Background(), time.Now()); err != nil {
+			log.Printf("expiring holds: %v", err)
+		}
+	}
+}
+
+// verifyLedger checks the balances and the entries of the ledger and logs the report when it finds discrepancies
+func verifyLedger(ledger interface {
+	Verify(ctx context.Context) (movement.Report, error)
+}, interval time.Duration) {
+	for range time.Tick(interval) {
+		report, err := ledger.Verify(context.Background())
+		if err != nil {
+			log.Printf("verifying the ledger: %v", err)
+			continue
+		}
+
+		if !report.OK() {
+			b, _ := json.Marshal(report)
+			log.Printf("verifying the ledger: %d discrepancies found: %s", report.DiscrepancyCount, b)
+		}
+	}
+}
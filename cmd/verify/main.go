@@ -0,0 +1,52 @@
+// verify checks the integrity of the ledger and prints the report as JSON. It exits with 1 when the ledger has
+// discrepancies and with 2 when the verification fails.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+)
+
+func main() {
+	dsn := flag.String("dsn", "tester:secret@tcp(db:3306)/test?charset=utf8&parseTime=True&loc=Local", "database to verify")
+	flag.Parse()
+
+	db, err := sql.Open("mysql", *dsn)
+	if err != nil {
+		fail(err)
+	}
+	defer db.Close()
+
+	currencies, err := movement.LoadRegistry(context.Background(), db)
+	if err != nil {
+		fail(err)
+	}
+
+	report, err := movement.New(db, currencies).Verify(context.Background())
+	if err != nil {
+		fail(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(report); err != nil {
+		fail(err)
+	}
+
+	if !report.OK() {
+		log.Printf("%d discrepancies found", report.DiscrepancyCount)
+		os.Exit(1)
+	}
+}
+
+func fail(err error) {
+	log.Printf("verifying the ledger: %v", err)
+	os.Exit(2)
+}
@@ -0,0 +1,229 @@
+package exchange
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+)
+
+var (
+	ErrorRateNotFound   = errors.New("exchange: rate not found")
+	ErrorSameCurrency   = errors.New("exchange: the currencies have to be different")
+	ErrorAmountTooSmall = errors.New("exchange: the converted amount is zero")
+	ErrorQuoteNotFound  = errors.New("exchange: quote not found")
+	ErrorQuoteExpired   = errors.New("exchange: quote expired")
+)
+
+// RateProvider returns the exchange rates, the rate is the amount of the destination currency paid
+// for one unit of the origin currency
+type RateProvider interface {
+	Rate(ctx context.Context, from, to string) (money.Amount, error)
+}
+
+// Store keeps the quotes until the user confirms them, a quote can only be used once
+type Store interface {
+	Create(ctx context.Context, q Quote) error
+	// Use marks the quote of the user as used and returns it, returns ErrorQuoteNotFound if it does not exist
+	// or was already used
+	Use(ctx context.Context, alias, id string) (Quote, error)
+	// Release marks the used quote of the user as not used, so it can be confirmed again
+	Release(ctx context.Context, alias, id string) error
+	// DeleteExpired deletes the quotes that expired before the given time
+	DeleteExpired(ctx context.Context, before time.Time) error
+}
+
+// Quote is the price offered to exchange an amount, the user has to confirm it before it expires
+type Quote struct {
+	ID                  string       `json:"id"`
+	Alias               string       `json:"-"`
+	Amount              money.Amount `json:"amount"`
+	CurrencyName        string       `json:"currencyname"`
+	DestinationAmount   money.Amount `json:"destinationamount"`
+	DestinationCurrency string       `json:"destinationcurrency"`
+	Rate                money.Amount `json:"rate"`
+	Spread              money.Amount `json:"spread"`
+	CreatedAt           time.Time    `json:"createdat"`
+	ExpiresAt           time.Time    `json:"expiresat"`
+}
+
+// Quoter prices the exchanges with the rate provider and keeps the quotes until they are confirmed
+type Quoter struct {
+	rates      RateProvider
+	store      Store
+	currencies *movement.Registry
+	ttl        time.Duration
+	spread     money.Amount
+	now        func() time.Time
+}
+
+// NewQuoter creates a Quoter, the quotes expire after the ttl. The spread is the fraction of the rate kept
+// by the wallet, e.g. 0.01 applies a rate 1% lower than the one of the provider
+func NewQuoter(rates RateProvider, store Store, currencies *movement.Registry, ttl time.Duration, spread money.Amount) *Quoter {
+	return &Quoter{rates: rates, store: store, currencies: currencies, ttl: ttl, spread: spread, now: time.Now}
+}
+
+// Convert returns the conversion of the amount to the currency at the current rate minus the spread
+func (q *Quoter) Convert(ctx context.Context, amount money.Amount, from, to string) (movement.Conversion, error) {
+	if from == to {
+		return movement.Conversion{}, ErrorSameCurrency
+	}
+
+	amount, err := q.currencies.Amount(amount, from)
+	if err != nil {
+		return movement.Conversion{}, err
+	}
+
+	destination, ok := q.currencies.Get(to)
+	if !ok || !destination.Enabled {
+		return movement.Conversion{}, movement.ErrorWrongCurrency
+	}
+
+	rate, err := q.rates.Rate(ctx, from, to)
+	if err != nil {
+		return movement.Conversion{}, err
+	}
+
+	applied, err := q.applySpread(rate)
+	if err != nil {
+		return movement.Conversion{}, err
+	}
+
+	converted, err := amount.Convert(applied, destination.Code, destination.Digits)
+	if err != nil {
+		return movement.Conversion{}, err
+	}
+
+	if converted.IsZero() {
+		return movement.Conversion{}, ErrorAmountTooSmall
+	}
+
+	market, err := amount.Convert(rate, destination.Code, destination.Digits)
+	if err != nil {
+		return movement.Conversion{}, err
+	}
+
+	spread, err := market.Sub(converted)
+	if err != nil {
+		return movement.Conversion{}, err
+	}
+
+	return movement.Conversion{
+		DestinationAmount:   converted,
+		DestinationCurrency: to,
+		Rate:                applied,
+		Spread:              spread,
+	}, nil
+}
+
+// applySpread returns the rate minus the spread, rounded down to the maximum number of decimals
+func (q *Quoter) applySpread(rate money.Amount) (money.Amount, error) {
+	if q.spread.IsZero() {
+		return rate, nil
+	}
+
+	scale := rate.Scale() + q.spread.Scale()
+	if scale > money.MaxScale {
+		scale = money.MaxScale
+	}
+
+	factor, err := money.MustParse("1").Sub(q.spread)
+	if err != nil {
+		return money.Amount{}, err
+	}
+
+	return rate.Convert(factor, "", scale)
+}
+
+// Quote prices the exchange of the amount and saves the quote for the user
+func (q *Quoter) Quote(ctx context.Context, alias string, amount money.Amount, from, to string) (Quote, error) {
+	amount, err := q.currencies.Amount(amount, from)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	conversion, err := q.Convert(ctx, amount, from, to)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	id, err := newQuoteID()
+	if err != nil {
+		return Quote{}, err
+	}
+
+	now := q.now()
+	quote := Quote{
+		ID:                  id,
+		Alias:               alias,
+		Amount:              amount,
+		CurrencyName:        from,
+		DestinationAmount:   conversion.DestinationAmount,
+		DestinationCurrency: to,
+		Rate:                conversion.Rate,
+		Spread:              conversion.Spread,
+		CreatedAt:           now,
+		ExpiresAt:           now.Add(q.ttl),
+	}
+
+	if err = q.store.Create(ctx, quote); err != nil {
+		return Quote{}, err
+	}
+
+	return quote, nil
+}
+
+// Confirm uses the quote of the user, the quote can not be confirmed again unless it is released
+func (q *Quoter) Confirm(ctx context.Context, alias, id string) (Quote, error) {
+	quote, err := q.store.Use(ctx, alias, id)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	if !q.now().Before(quote.ExpiresAt) {
+		return Quote{}, ErrorQuoteExpired
+	}
+
+	// the store does not know the decimals of the currencies
+	if quote.Amount, err = q.currencies.Amount(quote.Amount, quote.CurrencyName); err != nil {
+		return Quote{}, err
+	}
+
+	if quote.DestinationAmount, err = q.currencies.Amount(quote.DestinationAmount, quote.DestinationCurrency); err != nil {
+		return Quote{}, err
+	}
+
+	if quote.Spread, err = q.currencies.Amount(quote.Spread, quote.DestinationCurrency); err != nil {
+		return Quote{}, err
+	}
+
+	return quote, nil
+}
+
+// Release lets the quote of the user be confirmed again, used when the exchange of a confirmed quote fails
+func (q *Quoter) Release(ctx context.Context, alias, id string) error {
+	return q.store.Release(ctx, alias, id)
+}
+
+// Conversion returns the conversion of the quoted amount
+func (q Quote) Conversion() *movement.Conversion {
+	return &movement.Conversion{
+		DestinationAmount:   q.DestinationAmount,
+		DestinationCurrency: q.DestinationCurrency,
+		Rate:                q.Rate,
+		Spread:              q.Spread,
+	}
+}
+
+func newQuoteID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,137 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+	"github.com/stretchr/testify/require"
+)
+
+var testCurrencies = func() *movement.Registry {
+	registry, err := movement.NewRegistry([]movement.Currency{
+		{Code: movement.ARS, Name: "Peso", Digits: 2, Enabled: true},
+		{Code: movement.BTC, Name: "Bitcoin", Digits: 8, Enabled: true},
+		{Code: movement.USDT, Name: "Tether", Digits: 2, Enabled: true},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return registry
+}()
+
+var testRates = StaticRates{
+	"USDT/ARS": money.MustParse("350.25"),
+	"ARS/USDT": money.MustParse("0.002855"),
+}
+
+func TestQuoter_Quote(t *testing.T) {
+	tt := []struct {
+		TestName         string
+		Amount, From, To string
+		ExpectedAmount   string
+		ExpectedError    error
+	}{
+		{"Ok", "10.5", movement.USDT, movement.ARS, "3677.62", nil},
+		{"RoundsDown", "100", movement.ARS, movement.USDT, "0.28", nil},
+		{"SameCurrency", "10", movement.ARS, movement.ARS, "", ErrorSameCurrency},
+		{"RateNotFound", "10", movement.ARS, movement.BTC, "", ErrorRateNotFound},
+		{"UnknownCurrency", "10", movement.ARS, "EUR", "", movement.ErrorWrongCurrency},
+		{"TooManyDecimals", "10.001", movement.USDT, movement.ARS, "", money.ErrorTooManyDecimals},
+		{"TooSmall", "0.01", movement.ARS, movement.USDT, "", ErrorAmountTooSmall},
+	}
+
+	for _, tc := range tt {
+		// Given
+		quoter := NewQuoter(testRates, NewMemory(), testCurrencies, time.Minute, money.Amount{})
+
+		// When
+		quote, err := quoter.Quote(context.Background(), "user", money.MustParse(tc.Amount), tc.From, tc.To)
+
+		// Then
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		if tc.ExpectedError == nil {
+			require.Equal(t, tc.ExpectedAmount, quote.DestinationAmount.String(), tc.TestName)
+			require.Equal(t, tc.To, quote.DestinationAmount.Currency(), tc.TestName)
+			require.NotEmpty(t, quote.ID, tc.TestName)
+		}
+	}
+}
+
+func TestQuoter_Confirm(t *testing.T) {
+	// Given
+	now := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	quoter := NewQuoter(testRates, NewMemory(), testCurrencies, 30*time.Second, money.Amount{})
+	quoter.now = func() time.Time { return now }
+	quote, err := quoter.Quote(context.Background(), "user", money.MustParse("10"), movement.USDT, movement.ARS)
+	require.NoError(t, err)
+	expired, err := quoter.Quote(context.Background(), "user", money.MustParse("10"), movement.USDT, movement.ARS)
+	require.NoError(t, err)
+
+	// When
+	_, otherUserErr := quoter.Confirm(context.Background(), "other", quote.ID)
+	confirmed, err := quoter.Confirm(context.Background(), "user", quote.ID)
+	_, usedErr := quoter.Confirm(context.Background(), "user", quote.ID)
+	now = now.Add(30 * time.Second)
+	_, expiredErr := quoter.Confirm(context.Background(), "user", expired.ID)
+
+	// Then
+	require.Equal(t, ErrorQuoteNotFound, otherUserErr)
+	require.NoError(t, err)
+	require.Equal(t, quote, confirmed)
+	require.Equal(t, ErrorQuoteNotFound, usedErr)
+	require.Equal(t, ErrorQuoteExpired, expiredErr)
+}
+
+func TestQuoter_Release(t *testing.T) {
+	// Given
+	quoter := NewQuoter(testRates, NewMemory(), testCurrencies, 30*time.Second, money.Amount{})
+	quote, err := quoter.Quote(context.Background(), "user", money.MustParse("10"), movement.USDT, movement.ARS)
+	require.NoError(t, err)
+	_, err = quoter.Confirm(context.Background(), "user", quote.ID)
+	require.NoError(t, err)
+
+	// When
+	otherUserErr := quoter.Release(context.Background(), "other", quote.ID)
+	_, stillUsedErr := quoter.Confirm(context.Background(), "user", quote.ID)
+	err = quoter.Release(context.Background(), "user", quote.ID)
+	confirmed, confirmErr := quoter.Confirm(context.Background(), "user", quote.ID)
+
+	// Then
+	require.NoError(t, otherUserErr)
+	require.Equal(t, ErrorQuoteNotFound, stillUsedErr)
+	require.NoError(t, err)
+	require.NoError(t, confirmErr)
+	require.Equal(t, quote, confirmed)
+}
+
+func TestQuoter_Convert_Spread(t *testing.T) {
+	// Given
+	quoter := NewQuoter(testRates, NewMemory(), testCurrencies, time.Minute, money.MustParse("0.01"))
+
+	// When
+	conversion, err := quoter.Convert(context.Background(), money.MustParse("10"), movement.USDT, movement.ARS)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "3467.47", conversion.DestinationAmount.String())
+	require.Equal(t, "346.7475", conversion.Rate.String())
+	require.Equal(t, "35.03", conversion.Spread.String())
+	require.Equal(t, movement.ARS, conversion.Spread.Currency())
+}
+
+func TestLoadRates(t *testing.T) {
+	// When
+	rates, err := LoadRates("testdata/rates.json")
+
+	// Then
+	require.NoError(t, err)
+	rate, err := rates.Rate(context.Background(), movement.BTC, movement.USDT)
+	require.NoError(t, err)
+	require.Equal(t, "43000.5", rate.String())
+	_, err = rates.Rate(context.Background(), movement.USDT, movement.BTC)
+	require.Equal(t, ErrorRateNotFound, err)
+}
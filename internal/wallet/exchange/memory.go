@@ -0,0 +1,64 @@
+package exchange
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memory struct {
+	mu     sync.Mutex
+	quotes map[string]Quote
+	used   map[string]bool
+}
+
+// NewMemory creates a Store that keeps the quotes in memory, useful for tests
+func NewMemory() *memory {
+	return &memory{quotes: make(map[string]Quote), used: make(map[string]bool)}
+}
+
+func (m *memory) Create(ctx context.Context, q Quote) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.quotes[q.ID] = q
+	return nil
+}
+
+func (m *memory) Use(ctx context.Context, alias, id string) (Quote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.quotes[id]
+	if !ok || q.Alias != alias || m.used[id] {
+		return Quote{}, ErrorQuoteNotFound
+	}
+
+	m.used[id] = true
+	return q, nil
+}
+
+func (m *memory) Release(ctx context.Context, alias, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if q, ok := m.quotes[id]; ok && q.Alias == alias {
+		delete(m.used, id)
+	}
+
+	return nil
+}
+
+func (m *memory) DeleteExpired(ctx context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, q := range m.quotes {
+		if q.ExpiresAt.Before(before) {
+			delete(m.quotes, id)
+			delete(m.used, id)
+		}
+	}
+
+	return nil
+}
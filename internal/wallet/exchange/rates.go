@@ -0,0 +1,42 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+)
+
+// StaticRates is a RateProvider with fixed rates keyed by "FROM/TO", e.g. "USDT/ARS"
+type StaticRates map[string]money.Amount
+
+// LoadRates reads the rates from a JSON file like {"USDT/ARS": "350.25"}
+func LoadRates(path string) (StaticRates, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rates := make(StaticRates)
+	if err = json.Unmarshal(data, &rates); err != nil {
+		return nil, err
+	}
+
+	for _, rate := range rates {
+		if rate.Sign() <= 0 {
+			return nil, money.ErrorInvalidAmount
+		}
+	}
+
+	return rates, nil
+}
+
+func (s StaticRates) Rate(ctx context.Context, from, to string) (money.Amount, error) {
+	rate, ok := s[from+"/"+to]
+	if !ok {
+		return money.Amount{}, ErrorRateNotFound
+	}
+
+	return rate, nil
+}
@@ -0,0 +1,69 @@
+package exchange
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type repository struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *repository {
+	return &repository{db: db}
+}
+
+// Create inserts a new quote
+func (r repository) Create(ctx context.Context, q Quote) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO exchange_quotes(id,alias,currency_name,amount,destination_currency,"+
+		"destination_amount,rate,spread,created_at,expires_at) VALUES(?,?,?,?,?,?,?,?,?,?);",
+		q.ID, q.Alias, q.CurrencyName, q.Amount, q.DestinationCurrency, q.DestinationAmount, q.Rate, q.Spread, q.CreatedAt, q.ExpiresAt)
+
+	return err
+}
+
+// Use marks the quote as used, the update only succeeds once so two confirmations of the same quote can not
+// both exchange the money
+func (r repository) Use(ctx context.Context, alias, id string) (Quote, error) {
+	result, err := r.db.ExecContext(ctx, "UPDATE exchange_quotes SET used_at = ? WHERE id = ? AND alias = ? AND used_at IS NULL;",
+		time.Now(), id, alias)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return Quote{}, err
+	}
+
+	if affected == 0 {
+		return Quote{}, ErrorQuoteNotFound
+	}
+
+	row := r.db.QueryRowContext(ctx, "SELECT id,alias,currency_name,amount,destination_currency,destination_amount,rate,"+
+		"spread,created_at,expires_at FROM exchange_quotes WHERE id = ?;", id)
+
+	var q Quote
+	err = row.Scan(&q.ID, &q.Alias, &q.CurrencyName, &q.Amount, &q.DestinationCurrency, &q.DestinationAmount, &q.Rate,
+		&q.Spread, &q.CreatedAt, &q.ExpiresAt)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	return q, nil
+}
+
+// Release marks the quote as not used
+func (r repository) Release(ctx context.Context, alias, id string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE exchange_quotes SET used_at = NULL WHERE id = ? AND alias = ?;", id, alias)
+
+	return err
+}
+
+// DeleteExpired deletes the quotes that expired before the given time
+func (r repository) DeleteExpired(ctx context.Context, before time.Time) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM exchange_quotes WHERE expires_at < ?;", before)
+
+	return err
+}
@@ -0,0 +1,71 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUse_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	repository := New(db)
+	created := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	// When
+	mock.ExpectExec("UPDATE exchange_quotes SET used_at = ? WHERE id = ? AND alias = ? AND used_at IS NULL;").
+		WithArgs(sqlmock.AnyArg(), "quote", "user").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id,alias,currency_name,amount,destination_currency,destination_amount,rate," +
+		"spread,created_at,expires_at FROM exchange_quotes WHERE id = ?;").WithArgs("quote").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "alias", "currency_name", "amount", "destination_currency",
+			"destination_amount", "rate", "spread", "created_at", "expires_at"}).
+			AddRow("quote", "user", movement.USDT, "10.000000000000000000", movement.ARS, "3502.500000000000000000",
+				"350.250000000000000000", "0.000000000000000000", created, created.Add(time.Minute)))
+
+	// Then
+	quote, err := repository.Use(context.Background(), "user", "quote")
+	require.NoError(t, err)
+	require.Equal(t, "3502.5", quote.DestinationAmount.String())
+	require.Equal(t, "350.25", quote.Rate.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUse_When_QuoteWasUsed_Then_ReturnsNotFound(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	repository := New(db)
+
+	// When
+	mock.ExpectExec("UPDATE exchange_quotes SET used_at = ? WHERE id = ? AND alias = ? AND used_at IS NULL;").
+		WithArgs(sqlmock.AnyArg(), "quote", "user").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Then
+	_, err = repository.Use(context.Background(), "user", "quote")
+	require.Equal(t, ErrorQuoteNotFound, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRelease_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	repository := New(db)
+
+	// When
+	mock.ExpectExec("UPDATE exchange_quotes SET used_at = NULL WHERE id = ? AND alias = ?;").
+		WithArgs("quote", "user").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Then
+	err = repository.Release(context.Background(), "user", "quote")
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,147 @@
+package fee
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+)
+
+var ErrorInvalidRule = errors.New("fee: invalid rule")
+
+// Rule is the fee charged for a movement type in a currency, the fee is the flat amount plus the percentage
+// of the movement amount, bounded by the min and max amounts
+type Rule struct {
+	MovementType string `json:"type"`
+	Currency     string `json:"currency"`
+	// Flat is charged on every movement
+	Flat money.Amount `json:"flat"`
+	// Percentage of the movement amount, e.g. 1.5 charges 1.5%
+	Percentage money.Amount `json:"percentage"`
+	// Min and Max bound the fee, a zero value is no bound
+	Min money.Amount `json:"min"`
+	Max money.Amount `json:"max"`
+	// FreePerMonth is the number of movements of the type a user can do every calendar month without fee
+	FreePerMonth int `json:"freepermonth"`
+}
+
+// Counter counts the movements of the users, it is used to know if a movement is in the free tier
+type Counter interface {
+	// CountMovements returns the number of movements of the type of the user in the currency since the given time,
+	// only the movements that can be charged a fee are counted
+	CountMovements(ctx context.Context, alias, movType, currencyName string, since time.Time) (int, error)
+}
+
+// Engine computes the fees of the movements with the configured rules
+type Engine struct {
+	rules   map[string]Rule
+	counter Counter
+	now     func() time.Time
+}
+
+// LoadRules reads the rules from a JSON file like
+// [{"type": "send", "currency": "ARS", "flat": "10", "percentage": "1.5", "min": "15", "max": "500", "freepermonth": 3}]
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// NewEngine creates an Engine, the amounts of every rule are bound to the decimals of its currency.
+// Returns ErrorInvalidRule if a rule has negative values, an unknown currency or more than one rule
+// has the same movement type and currency
+func NewEngine(rules []Rule, counter Counter, currencies *movement.Registry) (*Engine, error) {
+	e := &Engine{rules: make(map[string]Rule), counter: counter, now: time.Now}
+	for _, r := range rules {
+		key := r.MovementType + "/" + r.Currency
+		if _, ok := e.rules[key]; ok {
+			return nil, fmt.Errorf("%w: duplicated rule %s", ErrorInvalidRule, key)
+		}
+
+		currency, ok := currencies.Get(r.Currency)
+		if !ok || r.MovementType == "" {
+			return nil, fmt.Errorf("%w: %s", ErrorInvalidRule, key)
+		}
+
+		var err error
+		for _, a := range []*money.Amount{&r.Flat, &r.Min, &r.Max} {
+			if *a, err = a.In(currency.Code, currency.Digits); err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrorInvalidRule, key, err)
+			}
+		}
+
+		if r.Flat.Sign() < 0 || r.Percentage.Sign() < 0 || r.Min.Sign() < 0 || r.Max.Sign() < 0 || r.FreePerMonth < 0 ||
+			r.Percentage.Cmp(money.MustParse("100")) > 0 || r.Percentage.Scale()+2 > money.MaxScale ||
+			(!r.Max.IsZero() && r.Min.Cmp(r.Max) > 0) {
+			return nil, fmt.Errorf("%w: %s", ErrorInvalidRule, key)
+		}
+
+		e.rules[key] = r
+	}
+
+	return e, nil
+}
+
+// Fee returns the fee of a movement of the user, the fee is zero when there is no rule for the movement type
+// and currency or the user did not use the free movements of the month yet.
+// The movements are counted before the movement is saved and without locking the account, so concurrent
+// movements of a user can all use the same free movement. The race is tolerated, only the movements a user
+// sends at the same time can skip their fee
+func (e *Engine) Fee(ctx context.Context, alias, movType string, amount money.Amount) (money.Amount, error) {
+	zero := money.Zero(amount.Currency(), amount.Scale())
+	if e == nil {
+		return zero, nil
+	}
+
+	r, ok := e.rules[movType+"/"+amount.Currency()]
+	if !ok {
+		return zero, nil
+	}
+
+	if r.FreePerMonth > 0 {
+		now := e.now().UTC()
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		count, err := e.counter.CountMovements(ctx, alias, movType, amount.Currency(), month)
+		if err != nil {
+			return money.Amount{}, err
+		}
+
+		if count < r.FreePerMonth {
+			return zero, nil
+		}
+	}
+
+	// the percentage is rounded down to the decimals of the currency
+	percentage := money.New(r.Percentage.Units(), "", r.Percentage.Scale()+2)
+	fee, err := amount.Convert(percentage, amount.Currency(), amount.Scale())
+	if err != nil {
+		return money.Amount{}, err
+	}
+
+	if fee, err = fee.Add(r.Flat); err != nil {
+		return money.Amount{}, err
+	}
+
+	if fee.Cmp(r.Min) < 0 {
+		fee = r.Min
+	}
+
+	if !r.Max.IsZero() && fee.Cmp(r.Max) > 0 {
+		fee = r.Max
+	}
+
+	return fee, nil
+}
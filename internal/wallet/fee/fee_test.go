@@ -0,0 +1,159 @@
+package fee
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+	"github.com/stretchr/testify/require"
+)
+
+var testCurrencies = func() *movement.Registry {
+	registry, err := movement.NewRegistry([]movement.Currency{
+		{Code: movement.ARS, Name: "Peso", Digits: 2, Enabled: true},
+		{Code: movement.BTC, Name: "Bitcoin", Digits: 8, Enabled: true},
+		{Code: movement.USDT, Name: "Tether", Digits: 2, Enabled: true},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return registry
+}()
+
+type counter struct {
+	count int
+	err   error
+	since time.Time
+}
+
+func (c *counter) CountMovements(ctx context.Context, alias, movType, currencyName string, since time.Time) (int, error) {
+	c.since = since
+	return c.count, c.err
+}
+
+func amount(value, currency string) money.Amount {
+	c, _ := testCurrencies.Get(currency)
+	a, err := money.MustParse(value).In(c.Code, c.Digits)
+	if err != nil {
+		panic(err)
+	}
+
+	return a
+}
+
+func TestEngine_Fee(t *testing.T) {
+	rules := []Rule{
+		{MovementType: movement.SendMov, Currency: movement.ARS, Flat: money.MustParse("10"), Percentage: money.MustParse("1.5"),
+			Min: money.MustParse("15"), Max: money.MustParse("500")},
+		{MovementType: movement.SendMov, Currency: movement.USDT, Percentage: money.MustParse("0.5"), FreePerMonth: 3},
+		{MovementType: movement.DepositMov, Currency: movement.BTC, Flat: money.MustParse("0.0001")},
+	}
+
+	tt := []struct {
+		TestName      string
+		MovementType  string
+		Amount        money.Amount
+		Count         int
+		ExpectedFee   string
+		ExpectedError error
+	}{
+		{"FlatAndPercentage", movement.SendMov, amount("1000", movement.ARS), 0, "25.00", nil},
+		{"RoundsDown", movement.SendMov, amount("1000.99", movement.ARS), 0, "25.01", nil},
+		{"Min", movement.SendMov, amount("100", movement.ARS), 0, "15.00", nil},
+		{"Max", movement.SendMov, amount("100000", movement.ARS), 0, "500.00", nil},
+		{"FreeTier", movement.SendMov, amount("1000", movement.USDT), 2, "0.00", nil},
+		{"FreeTierUsed", movement.SendMov, amount("1000", movement.USDT), 3, "5.00", nil},
+		{"Flat", movement.DepositMov, amount("1", movement.BTC), 0, "0.00010000", nil},
+		{"NoRule", movement.DepositMov, amount("1000", movement.ARS), 0, "0.00", nil},
+		{"CounterError", movement.SendMov, amount("1000", movement.USDT), 0, "", errors.New("database error")},
+	}
+
+	for _, tc := range tt {
+		// Given
+		c := &counter{count: tc.Count}
+		if tc.ExpectedError != nil {
+			c.err = tc.ExpectedError
+		}
+		engine, err := NewEngine(rules, c, testCurrencies)
+		require.NoError(t, err)
+		engine.now = func() time.Time { return time.Date(2022, 3, 15, 10, 0, 0, 0, time.UTC) }
+
+		// When
+		fee, err := engine.Fee(context.Background(), "user", tc.MovementType, tc.Amount)
+
+		// Then
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		if tc.ExpectedError == nil {
+			require.Equal(t, tc.ExpectedFee, fee.String(), tc.TestName)
+			require.Equal(t, tc.Amount.Currency(), fee.Currency(), tc.TestName)
+		}
+	}
+}
+
+func TestEngine_Fee_CountsTheMovementsOfTheMonth(t *testing.T) {
+	// Given
+	c := &counter{}
+	engine, err := NewEngine([]Rule{{MovementType: movement.SendMov, Currency: movement.ARS, FreePerMonth: 1}}, c, testCurrencies)
+	require.NoError(t, err)
+	engine.now = func() time.Time { return time.Date(2022, 3, 15, 10, 0, 0, 0, time.UTC) }
+
+	// When
+	_, err = engine.Fee(context.Background(), "user", movement.SendMov, amount("10", movement.ARS))
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), c.since)
+}
+
+func TestNewEngine_InvalidRule(t *testing.T) {
+	tt := []struct {
+		TestName string
+		Rules    []Rule
+	}{
+		{"UnknownCurrency", []Rule{{MovementType: movement.SendMov, Currency: "EUR"}}},
+		{"MissingType", []Rule{{Currency: movement.ARS}}},
+		{"TooManyDecimals", []Rule{{MovementType: movement.SendMov, Currency: movement.ARS, Flat: money.MustParse("0.001")}}},
+		{"NegativeFlat", []Rule{{MovementType: movement.SendMov, Currency: movement.ARS, Flat: money.MustParse("-1")}}},
+		{"PercentageOverHundred", []Rule{{MovementType: movement.SendMov, Currency: movement.ARS, Percentage: money.MustParse("101")}}},
+		{"MinOverMax", []Rule{{MovementType: movement.SendMov, Currency: movement.ARS, Min: money.MustParse("10"), Max: money.MustParse("5")}}},
+		{"Duplicated", []Rule{
+			{MovementType: movement.SendMov, Currency: movement.ARS},
+			{MovementType: movement.SendMov, Currency: movement.ARS},
+		}},
+	}
+
+	for _, tc := range tt {
+		// When
+		_, err := NewEngine(tc.Rules, &counter{}, testCurrencies)
+
+		// Then
+		require.ErrorIs(t, err, ErrorInvalidRule, tc.TestName)
+	}
+}
+
+func TestEngine_Fee_When_EngineIsNil_Then_ReturnsZero(t *testing.T) {
+	// Given
+	var engine *Engine
+
+	// When
+	fee, err := engine.Fee(context.Background(), "user", movement.SendMov, amount("10", movement.ARS))
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "0.00", fee.String())
+}
+
+func TestLoadRules(t *testing.T) {
+	// When
+	rules, err := LoadRules("testdata/rules.json")
+
+	// Then
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, "1.5", rules[0].Percentage.String())
+	require.Equal(t, 3, rules[1].FreePerMonth)
+}
@@ -0,0 +1,38 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrorNotFound = errors.New("idempotency: not found")
+
+// Store keeps the responses of the requests made with an idempotency key, so a retried request
+// returns the first response instead of being executed again
+type Store interface {
+	// Reserve saves the record of a new request, if the user already used the key it returns the saved record and false
+	Reserve(ctx context.Context, r Record) (Record, bool, error)
+	// Complete saves the response of a reserved request
+	Complete(ctx context.Context, alias, key string, statusCode int, contentType string, body []byte) error
+	// Release deletes the record so the key can be used again
+	Release(ctx context.Context, alias, key string) error
+	// DeleteExpired deletes the records created before the given time
+	DeleteExpired(ctx context.Context, before time.Time) error
+}
+
+type Record struct {
+	Key         string
+	Alias       string
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+// Completed returns true if the response of the request was saved,
+// otherwise the request is still being executed
+func (r Record) Completed() bool {
+	return r.StatusCode != 0
+}
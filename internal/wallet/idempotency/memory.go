@@ -0,0 +1,65 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memory struct {
+	mu      sync.Mutex
+	records map[[2]string]Record
+}
+
+// NewMemory creates a Store that keeps the records in memory, useful for tests
+func NewMemory() *memory {
+	return &memory{records: make(map[[2]string]Record)}
+}
+
+func (m *memory) Reserve(ctx context.Context, r Record) (Record, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if saved, ok := m.records[[2]string{r.Alias, r.Key}]; ok {
+		return saved, false, nil
+	}
+
+	m.records[[2]string{r.Alias, r.Key}] = r
+	return r, true, nil
+}
+
+func (m *memory) Complete(ctx context.Context, alias, key string, statusCode int, contentType string, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.records[[2]string{alias, key}]
+	if !ok {
+		return ErrorNotFound
+	}
+
+	r.StatusCode, r.ContentType = statusCode, contentType
+	r.Body = append([]byte(nil), body...)
+	m.records[[2]string{alias, key}] = r
+	return nil
+}
+
+func (m *memory) Release(ctx context.Context, alias, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, [2]string{alias, key})
+	return nil
+}
+
+func (m *memory) DeleteExpired(ctx context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for k, r := range m.records {
+		if r.CreatedAt.Before(before) {
+			delete(m.records, k)
+		}
+	}
+
+	return nil
+}
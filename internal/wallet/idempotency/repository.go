@@ -0,0 +1,77 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type repository struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *repository {
+	return &repository{db: db}
+}
+
+// Reserve inserts a new record, if the user already used the key the saved record is returned
+func (r repository) Reserve(ctx context.Context, record Record) (Record, bool, error) {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO idempotency_keys(alias,idempotency_key,request_hash,created_at) VALUES(?,?,?,?);",
+		record.Alias, record.Key, record.RequestHash, record.CreatedAt)
+	if err == nil {
+		return record, true, nil
+	}
+
+	if v, ok := err.(*mysql.MySQLError); !ok || v.Number != 1062 {
+		return Record{}, false, err
+	}
+
+	saved, err := r.get(ctx, record.Alias, record.Key)
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	return saved, false, nil
+}
+
+func (r repository) get(ctx context.Context, alias, key string) (Record, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT alias,idempotency_key,request_hash,status_code,content_type,response_body,created_at "+
+		"FROM idempotency_keys WHERE alias = ? AND idempotency_key = ?;", alias, key)
+
+	var record Record
+	var statusCode sql.NullInt64
+	if err := row.Scan(&record.Alias, &record.Key, &record.RequestHash, &statusCode, &record.ContentType, &record.Body, &record.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return Record{}, ErrorNotFound
+		}
+
+		return Record{}, err
+	}
+
+	record.StatusCode = int(statusCode.Int64)
+	return record, nil
+}
+
+// Complete saves the response of the request
+func (r repository) Complete(ctx context.Context, alias, key string, statusCode int, contentType string, body []byte) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE idempotency_keys SET status_code = ?, content_type = ?, response_body = ? "+
+		"WHERE alias = ? AND idempotency_key = ?;", statusCode, contentType, body, alias, key)
+
+	return err
+}
+
+// Release deletes the record
+func (r repository) Release(ctx context.Context, alias, key string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE alias = ? AND idempotency_key = ?;", alias, key)
+
+	return err
+}
+
+// DeleteExpired deletes the records created before the given time
+func (r repository) DeleteExpired(ctx context.Context, before time.Time) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created_at < ?;", before)
+
+	return err
+}
@@ -0,0 +1,100 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserve_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		require.NoError(t, err)
+	}
+	repository := New(db)
+	defer db.Close()
+
+	input := Record{Key: "key", Alias: "user", RequestHash: "hash", CreatedAt: time.Now()}
+
+	// When
+	mock.ExpectExec("INSERT INTO idempotency_keys(alias,idempotency_key,request_hash,created_at) VALUES(?,?,?,?);").
+		WithArgs(input.Alias, input.Key, input.RequestHash, input.CreatedAt).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// then
+	result, reserved, err := repository.Reserve(context.Background(), input)
+	require.NoError(t, err)
+	require.True(t, reserved)
+	require.Equal(t, input, result)
+}
+
+func TestReserve_AlreadyUsed(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		require.NoError(t, err)
+	}
+	repository := New(db)
+	defer db.Close()
+
+	now := time.Now()
+	input := Record{Key: "key", Alias: "user", RequestHash: "hash", CreatedAt: now}
+
+	// When
+	mock.ExpectExec("INSERT INTO idempotency_keys(alias,idempotency_key,request_hash,created_at) VALUES(?,?,?,?);").
+		WithArgs(input.Alias, input.Key, input.RequestHash, input.CreatedAt).WillReturnError(&mysql.MySQLError{Number: 1062})
+	mock.ExpectQuery("SELECT alias,idempotency_key,request_hash,status_code,content_type,response_body,created_at "+
+		"FROM idempotency_keys WHERE alias = ? AND idempotency_key = ?;").WithArgs("user", "key").
+		WillReturnRows(sqlmock.NewRows([]string{"alias", "idempotency_key", "request_hash", "status_code", "content_type", "response_body", "created_at"}).
+			AddRow("user", "key", "hash", 200, "application/json", []byte(`"ok"`), now))
+
+	// then
+	result, reserved, err := repository.Reserve(context.Background(), input)
+	require.NoError(t, err)
+	require.False(t, reserved)
+	require.True(t, result.Completed())
+	require.Equal(t, "application/json", result.ContentType)
+	require.Equal(t, []byte(`"ok"`), result.Body)
+}
+
+func TestComplete_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		require.NoError(t, err)
+	}
+	repository := New(db)
+	defer db.Close()
+
+	// When
+	mock.ExpectExec("UPDATE idempotency_keys SET status_code = ?, content_type = ?, response_body = ? "+
+		"WHERE alias = ? AND idempotency_key = ?;").
+		WithArgs(200, "application/json", []byte(`"ok"`), "user", "key").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// then
+	err = repository.Complete(context.Background(), "user", "key", 200, "application/json", []byte(`"ok"`))
+	require.NoError(t, err)
+}
+
+func TestDeleteExpired_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		require.NoError(t, err)
+	}
+	repository := New(db)
+	defer db.Close()
+
+	before := time.Now()
+
+	// When
+	mock.ExpectExec("DELETE FROM idempotency_keys WHERE created_at < ?;").WithArgs(before).WillReturnResult(sqlmock.NewResult(0, 3))
+
+	// then
+	err = repository.DeleteExpired(context.Background(), before)
+	require.NoError(t, err)
+}
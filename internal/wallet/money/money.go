@@ -0,0 +1,377 @@
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// MaxScale is the maximum number of decimals an amount can have
+const MaxScale = 18
+
+var (
+	ErrorInvalidAmount    = errors.New("money: invalid amount")
+	ErrorTooManyDecimals  = errors.New("money: too many decimals for the currency")
+	ErrorOutOfRange       = errors.New("money: amount out of range")
+	ErrorCurrencyMismatch = errors.New("money: amounts of different currencies")
+)
+
+// Amount is an exact decimal amount of a currency, stored as an integer number of the smallest unit of the
+// currency (units / 10^scale). The zero value is a zero amount without currency.
+//
+// An amount parsed from user input has no currency and keeps the decimals it was written with until it is
+// bound to a currency with In.
+type Amount struct {
+	units    int64
+	scale    int
+	currency string
+}
+
+// New returns the amount of units of the smallest unit of the currency, e.g. New(150, "ARS", 2) is 1.50 ARS
+func New(units int64, currency string, scale int) Amount {
+	return Amount{units: units, scale: scale, currency: currency}
+}
+
+// Zero returns a zero amount of the currency
+func Zero(currency string, scale int) Amount {
+	return Amount{scale: scale, currency: currency}
+}
+
+// Parse parses a decimal number like "-12.345", the amount has no currency
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	if negative || strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+
+	integer, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		integer, fraction = s[:i], s[i+1:]
+	}
+
+	if integer == "" && fraction == "" {
+		return Amount{}, ErrorInvalidAmount
+	}
+
+	if len(fraction) > MaxScale {
+		return Amount{}, ErrorTooManyDecimals
+	}
+
+	var units int64
+	for _, c := range integer + fraction {
+		if c < '0' || c > '9' {
+			return Amount{}, ErrorInvalidAmount
+		}
+
+		if units > (math.MaxInt64-int64(c-'0'))/10 {
+			return Amount{}, ErrorOutOfRange
+		}
+		units = units*10 + int64(c-'0')
+	}
+
+	if negative {
+		units = -units
+	}
+
+	return Amount{units: units, scale: len(fraction)}, nil
+}
+
+// MustParse is like Parse but panics if the value can not be parsed, useful for constants and tests
+func MustParse(s string) Amount {
+	a, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return a
+}
+
+// In returns the amount in the currency with the given scale, returns ErrorTooManyDecimals if the amount has
+// more significant decimals than the currency allows
+func (a Amount) In(currency string, scale int) (Amount, error) {
+	rescaled, err := a.rescale(scale)
+	if err != nil {
+		return Amount{}, err
+	}
+
+	rescaled.currency = currency
+	return rescaled, nil
+}
+
+func (a Amount) rescale(scale int) (Amount, error) {
+	if scale < 0 || scale > MaxScale {
+		return Amount{}, ErrorOutOfRange
+	}
+
+	units := a.units
+	for s := a.scale; s > scale; s-- {
+		if units%10 != 0 {
+			return Amount{}, ErrorTooManyDecimals
+		}
+		units /= 10
+	}
+
+	for s := a.scale; s < scale; s++ {
+		if units > math.MaxInt64/10 || units < math.MinInt64/10 {
+			return Amount{}, ErrorOutOfRange
+		}
+		units *= 10
+	}
+
+	return Amount{units: units, scale: scale, currency: a.currency}, nil
+}
+
+// Convert returns the amount multiplied by the rate in the currency with the given scale. The result is rounded
+// down, so converting an amount never creates money
+func (a Amount) Convert(rate Amount, currency string, scale int) (Amount, error) {
+	if scale < 0 || scale > MaxScale {
+		return Amount{}, ErrorOutOfRange
+	}
+
+	product := new(big.Int).Mul(big.NewInt(a.units), big.NewInt(rate.units))
+	shift := a.scale + rate.scale - scale
+	if shift > 0 {
+		product.Quo(product, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(shift)), nil))
+	} else {
+		product.Mul(product, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-shift)), nil))
+	}
+
+	if !product.IsInt64() {
+		return Amount{}, ErrorOutOfRange
+	}
+
+	return Amount{units: product.Int64(), scale: scale, currency: currency}, nil
+}
+
+// Currency returns the currency code of the amount, empty if it is not bound to a currency
+func (a Amount) Currency() string {
+	return a.currency
+}
+
+// Scale returns the number of decimals of the amount
+func (a Amount) Scale() int {
+	return a.scale
+}
+
+// Units returns the amount as a number of the smallest unit of the currency
+func (a Amount) Units() int64 {
+	return a.units
+}
+
+// align returns both amounts with the same scale, returns ErrorCurrencyMismatch when they have different
+// currencies and ErrorOutOfRange when one of them does not fit in the scale of the other
+func align(a, b Amount) (Amount, Amount, error) {
+	if a.currency != "" && b.currency != "" && a.currency != b.currency {
+		return Amount{}, Amount{}, ErrorCurrencyMismatch
+	}
+
+	currency := a.currency
+	if currency == "" {
+		currency = b.currency
+	}
+
+	scale := a.scale
+	if b.scale > scale {
+		scale = b.scale
+	}
+
+	var err error
+	if a, err = a.rescale(scale); err != nil {
+		return Amount{}, Amount{}, err
+	}
+	if b, err = b.rescale(scale); err != nil {
+		return Amount{}, Amount{}, err
+	}
+
+	a.currency, b.currency = currency, currency
+	return a, b, nil
+}
+
+// Add returns a + b, returns ErrorOutOfRange if the result does not fit in an amount and
+// ErrorCurrencyMismatch if the amounts have different currencies
+func (a Amount) Add(b Amount) (Amount, error) {
+	a, b, err := align(a, b)
+	if err != nil {
+		return Amount{}, err
+	}
+
+	if (b.units > 0 && a.units > math.MaxInt64-b.units) || (b.units < 0 && a.units < math.MinInt64-b.units) {
+		return Amount{}, ErrorOutOfRange
+	}
+
+	a.units += b.units
+	return a, nil
+}
+
+// Sub returns a - b, it fails like Add
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if b.units == math.MinInt64 {
+		return Amount{}, ErrorOutOfRange
+	}
+
+	return a.Add(b.Neg())
+}
+
+// Neg returns -a
+func (a Amount) Neg() Amount {
+	a.units = -a.units
+	return a
+}
+
+// Cmp returns -1 if a < b, 0 if a == b and +1 if a > b, it panics when the amounts have different currencies
+// because comparing them is a programming error
+func (a Amount) Cmp(b Amount) int {
+	if a.currency != "" && b.currency != "" && a.currency != b.currency {
+		panic(fmt.Sprintf("money: comparing %s and %s amounts", a.currency, b.currency))
+	}
+
+	// the amounts are compared as big integers so aligning the scales never overflows
+	x, y := big.NewInt(a.units), big.NewInt(b.units)
+	if a.scale < b.scale {
+		x.Mul(x, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(b.scale-a.scale)), nil))
+	} else if b.scale < a.scale {
+		y.Mul(y, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(a.scale-b.scale)), nil))
+	}
+
+	return x.Cmp(y)
+}
+
+// Sign returns -1 if the amount is negative, 0 if it is zero and +1 if it is positive
+func (a Amount) Sign() int {
+	switch {
+	case a.units < 0:
+		return -1
+	case a.units > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// IsZero returns true if the amount is zero
+func (a Amount) IsZero() bool {
+	return a.units == 0
+}
+
+// String returns the amount as a decimal number with all the decimals of its scale, e.g. "1.50"
+func (a Amount) String() string {
+	units := a.units
+	sign := ""
+	if units < 0 {
+		sign = "-"
+	}
+
+	digits := strconv.FormatUint(uint64(units), 10)
+	if units < 0 {
+		digits = strconv.FormatUint(uint64(-units), 10)
+	}
+
+	if a.scale == 0 {
+		return sign + digits
+	}
+
+	if len(digits) <= a.scale {
+		digits = strings.Repeat("0", a.scale-len(digits)+1) + digits
+	}
+
+	return sign + digits[:len(digits)-a.scale] + "." + digits[len(digits)-a.scale:]
+}
+
+// MarshalJSON encodes the amount as a string to keep all its decimals
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(a.String())), nil
+}
+
+// UnmarshalJSON decodes a string or a number. When the amount is already bound to a currency the decoded
+// value is bound to the same currency
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	s := string(data)
+	if strings.HasPrefix(s, `"`) {
+		var err error
+		if s, err = strconv.Unquote(s); err != nil {
+			return ErrorInvalidAmount
+		}
+	}
+
+	return a.set(s)
+}
+
+// Scan implements sql.Scanner, decimal columns are read as text so no precision is lost.
+// When the amount is already bound to a currency the scanned value is bound to the same currency
+func (a *Amount) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return a.set(string(v))
+	case string:
+		return a.set(v)
+	case int64:
+		return a.set(strconv.FormatInt(v, 10))
+	case float64:
+		return a.set(strconv.FormatFloat(v, 'f', -1, 64))
+	case nil:
+		*a = Amount{scale: a.scale, currency: a.currency}
+		return nil
+	default:
+		return fmt.Errorf("money: can not scan %T", src)
+	}
+}
+
+// Value implements driver.Valuer
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+func (a *Amount) set(s string) error {
+	if a.currency != "" {
+		s = trimZeros(s, a.scale)
+	}
+
+	parsed, err := Parse(s)
+	if err == ErrorOutOfRange && a.currency == "" {
+		// a value read from a wide decimal column, the zeros are not significant
+		parsed, err = Parse(trimZeros(s, 0))
+	}
+	if err != nil {
+		return err
+	}
+
+	if a.currency != "" {
+		if parsed, err = parsed.In(a.currency, a.scale); err != nil {
+			return err
+		}
+	}
+
+	*a = parsed
+	return nil
+}
+
+// trimZeros removes the trailing zeros after the first scale decimals, so a value read from a column with
+// more decimals than the currency, like "1.500000000000000000", does not overflow while it is parsed
+func trimZeros(s string, scale int) string {
+	i := strings.IndexByte(s, '.')
+	if i < 0 {
+		return s
+	}
+
+	end := len(s)
+	for end > i+1+scale && s[end-1] == '0' {
+		end--
+	}
+
+	if end == i+1 {
+		end = i
+	}
+
+	return s[:end]
+}
@@ -0,0 +1,211 @@
+package money
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tt := []struct {
+		Input          string
+		ExpectedUnits  int64
+		ExpectedScale  int
+		ExpectedString string
+		ExpectedError  error
+	}{
+		{"100", 100, 0, "100", nil},
+		{"100.50", 10050, 2, "100.50", nil},
+		{"-0.00000001", -1, 8, "-0.00000001", nil},
+		{".5", 5, 1, "0.5", nil},
+		{"+3.", 3, 0, "3", nil},
+		{"", 0, 0, "", ErrorInvalidAmount},
+		{"1.2.3", 0, 0, "", ErrorInvalidAmount},
+		{"1e5", 0, 0, "", ErrorInvalidAmount},
+		{"abc", 0, 0, "", ErrorInvalidAmount},
+		{"99999999999999999999", 0, 0, "", ErrorOutOfRange},
+		{"0.1234567890123456789", 0, 0, "", ErrorTooManyDecimals},
+	}
+
+	for _, tc := range tt {
+		// When
+		amount, err := Parse(tc.Input)
+
+		// Then
+		require.Equal(t, tc.ExpectedError, err, tc.Input)
+		if err == nil {
+			require.Equal(t, tc.ExpectedUnits, amount.Units(), tc.Input)
+			require.Equal(t, tc.ExpectedScale, amount.Scale(), tc.Input)
+			require.Equal(t, tc.ExpectedString, amount.String(), tc.Input)
+		}
+	}
+}
+
+func TestAmount_In(t *testing.T) {
+	tt := []struct {
+		Input          string
+		Scale          int
+		ExpectedString string
+		ExpectedError  error
+	}{
+		{"100", 2, "100.00", nil},
+		{"100.5", 8, "100.50000000", nil},
+		{"100.500", 2, "100.50", nil},
+		{"100.505", 2, "", ErrorTooManyDecimals},
+		{"0.000000001", 8, "", ErrorTooManyDecimals},
+	}
+
+	for _, tc := range tt {
+		// When
+		amount, err := MustParse(tc.Input).In("ARS", tc.Scale)
+
+		// Then
+		require.Equal(t, tc.ExpectedError, err, tc.Input)
+		if err == nil {
+			require.Equal(t, tc.ExpectedString, amount.String(), tc.Input)
+			require.Equal(t, "ARS", amount.Currency(), tc.Input)
+		}
+	}
+}
+
+func TestAmount_Arithmetic(t *testing.T) {
+	// Given
+	a := New(10050, "ARS", 2)
+	b := MustParse("0.5")
+
+	// When
+	sum, sumErr := a.Add(b)
+	difference, differenceErr := a.Sub(b)
+	_, mismatchErr := a.Add(New(1, "BTC", 8))
+
+	// Then
+	require.NoError(t, sumErr)
+	require.Equal(t, "101.00", sum.String())
+	require.Equal(t, "ARS", sum.Currency())
+	require.NoError(t, differenceErr)
+	require.Equal(t, "100.00", difference.String())
+	require.Equal(t, ErrorCurrencyMismatch, mismatchErr)
+	require.Equal(t, "-100.50", a.Neg().String())
+	require.Equal(t, 1, a.Cmp(b))
+	require.Equal(t, -1, b.Cmp(a))
+	require.Equal(t, 0, a.Cmp(New(100500, "ARS", 3)))
+	require.Equal(t, -1, a.Neg().Sign())
+	require.True(t, Zero("ARS", 2).IsZero())
+	require.Panics(t, func() { a.Cmp(New(1, "BTC", 8)) })
+}
+
+func TestAmount_Arithmetic_OutOfRange(t *testing.T) {
+	// Given
+	large := New(math.MaxInt64-1, "ARS", 2)
+	wide := MustParse("100000000000")
+
+	// When
+	_, addErr := large.Add(New(2, "ARS", 2))
+	_, subErr := large.Neg().Sub(New(3, "ARS", 2))
+	_, scaleErr := New(1, "BTC", 18).Add(wide)
+
+	// Then
+	require.Equal(t, ErrorOutOfRange, addErr)
+	require.Equal(t, ErrorOutOfRange, subErr)
+	require.Equal(t, ErrorOutOfRange, scaleErr)
+	require.Equal(t, -1, New(1, "BTC", 18).Cmp(wide))
+}
+
+func TestAmount_JSON(t *testing.T) {
+	// Given
+	var request struct {
+		Number Amount `json:"number"`
+		Text   Amount `json:"text"`
+	}
+
+	// When
+	err := json.Unmarshal([]byte(`{"number": 100.10, "text": "0.00000001"}`), &request)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "100.10", request.Number.String())
+	require.Equal(t, "0.00000001", request.Text.String())
+
+	encoded, err := json.Marshal(New(150, "ARS", 2))
+	require.NoError(t, err)
+	require.Equal(t, `"1.50"`, string(encoded))
+
+	require.Error(t, json.Unmarshal([]byte(`{"number": "abc"}`), &request))
+}
+
+func TestAmount_SQL(t *testing.T) {
+	tt := []struct {
+		Input          interface{}
+		ExpectedString string
+	}{
+		{[]byte("00000000100.50000000"), "100.50"},
+		{[]byte("9999999999999999.500000000000000000"), "9999999999999999.50"},
+		{[]byte("1.000000000000000000"), "1.00"},
+		{"7", "7.00"},
+		{int64(3), "3.00"},
+		{float64(2.5), "2.50"},
+		{nil, "0.00"},
+	}
+
+	for _, tc := range tt {
+		// Given
+		amount := Zero("ARS", 2)
+
+		// When
+		err := amount.Scan(tc.Input)
+
+		// Then
+		require.NoError(t, err)
+		require.Equal(t, tc.ExpectedString, amount.String())
+		require.Equal(t, "ARS", amount.Currency())
+	}
+
+	value, err := New(150, "ARS", 2).Value()
+	require.NoError(t, err)
+	require.Equal(t, "1.50", value)
+
+	amount := Zero("ARS", 2)
+	require.Equal(t, ErrorTooManyDecimals, amount.Scan("1.505"))
+}
+
+func TestAmount_Convert(t *testing.T) {
+	tt := []struct {
+		TestName      string
+		Amount, Rate  string
+		Scale         int
+		Expected      string
+		ExpectedError error
+	}{
+		{"Ok", "10.50", "350.25", 2, "3677.62", nil},
+		{"RoundsDown", "0.01", "0.3333", 2, "0.00", nil},
+		{"MoreDecimals", "100.00", "0.0000285", 8, "0.00285000", nil},
+		{"HighPrecisionRate", "1.00", "0.123456789012345678", 8, "0.12345678", nil},
+		{"OutOfRange", "92233720368547758.07", "10", 2, "", ErrorOutOfRange},
+	}
+
+	for _, tc := range tt {
+		// When
+		result, err := MustParse(tc.Amount).Convert(MustParse(tc.Rate), "ARS", tc.Scale)
+
+		// Then
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		if tc.ExpectedError == nil {
+			require.Equal(t, tc.Expected, result.String(), tc.TestName)
+			require.Equal(t, "ARS", result.Currency(), tc.TestName)
+		}
+	}
+}
+
+func TestAmount_Scan_UnboundWideColumn(t *testing.T) {
+	// Given
+	var rate Amount
+
+	// When
+	err := rate.Scan([]byte("350.250000000000000000"))
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "350.25", rate.String())
+}
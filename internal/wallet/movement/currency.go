@@ -0,0 +1,102 @@
+package movement
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+)
+
+// maxUnits is the maximum amount of a movement in the smallest unit of its currency, it keeps the balances far
+// from the range of an amount
+const maxUnits = 1_000_000_000_000_000
+
+// Currency is a currency the wallet operates with, the currencies are loaded from the currencies table
+type Currency struct {
+	Code    string `json:"code"`
+	Name    string `json:"name"`
+	Digits  int    `json:"digits"`
+	Enabled bool   `json:"enabled"`
+}
+
+// Registry holds the currencies loaded at startup
+type Registry struct {
+	currencies map[string]Currency
+	codes      []string
+}
+
+// NewRegistry creates a registry with the given currencies
+func NewRegistry(currencies []Currency) (*Registry, error) {
+	r := &Registry{currencies: make(map[string]Currency, len(currencies))}
+	for _, c := range currencies {
+		for _, char := range c.Code {
+			if (char < 'A' || char > 'Z') && (char < '0' || char > '9') {
+				return nil, fmt.Errorf("movement: currency code %q must be upper case letters and numbers", c.Code)
+			}
+		}
+
+		if c.Code == "" || c.Digits < 0 || c.Digits > money.MaxScale {
+			return nil, fmt.Errorf("movement: invalid currency %q", c.Code)
+		}
+
+		r.currencies[c.Code] = c
+		r.codes = append(r.codes, c.Code)
+	}
+
+	sort.Strings(r.codes)
+	return r, nil
+}
+
+// Get returns the currency with the given code, disabled currencies are returned too
+func (r *Registry) Get(code string) (Currency, bool) {
+	c, ok := r.currencies[code]
+	return c, ok
+}
+
+// All returns every currency, including the disabled ones, sorted by code
+func (r *Registry) All() []Currency {
+	currencies := make([]Currency, 0, len(r.codes))
+	for _, code := range r.codes {
+		currencies = append(currencies, r.currencies[code])
+	}
+
+	return currencies
+}
+
+// Enabled returns the currencies that can be used in new movements, sorted by code
+func (r *Registry) Enabled() []Currency {
+	currencies := make([]Currency, 0, len(r.codes))
+	for _, c := range r.All() {
+		if c.Enabled {
+			currencies = append(currencies, c)
+		}
+	}
+
+	return currencies
+}
+
+// Max returns the maximum amount of a movement of the currency
+func (c Currency) Max() money.Amount {
+	return money.New(maxUnits, c.Code, c.Digits)
+}
+
+// Amount binds the amount to the currency, returns ErrorWrongCurrency if the currency does not exist or
+// is disabled, money.ErrorTooManyDecimals if the amount has more decimals than the currency allows and
+// ErrorAmountTooLarge if it is above the maximum of the currency
+func (r *Registry) Amount(amount money.Amount, code string) (money.Amount, error) {
+	c, ok := r.currencies[code]
+	if !ok || !c.Enabled {
+		return money.Amount{}, ErrorWrongCurrency
+	}
+
+	if amount.Cmp(c.Max()) > 0 || amount.Neg().Cmp(c.Max()) > 0 {
+		return money.Amount{}, ErrorAmountTooLarge
+	}
+
+	return amount.In(c.Code, c.Digits)
+}
+
+// Zero returns a zero amount of the currency
+func (r *Registry) Zero(code string) money.Amount {
+	return money.Zero(code, r.currencies[code].Digits)
+}
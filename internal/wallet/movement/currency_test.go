@@ -0,0 +1,75 @@
+package movement
+
+import (
+	"testing"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRegistry_InvalidCurrency(t *testing.T) {
+	tt := []struct {
+		TestName string
+		Currency Currency
+	}{
+		{"EmptyCode", Currency{Code: "", Digits: 2}},
+		{"LowerCaseCode", Currency{Code: "ars", Digits: 2}},
+		{"TableNameInjection", Currency{Code: "ARS;DROP", Digits: 2}},
+		{"NegativeDigits", Currency{Code: "ARS", Digits: -1}},
+		{"TooManyDigits", Currency{Code: "ARS", Digits: money.MaxScale + 1}},
+	}
+
+	for _, tc := range tt {
+		_, err := NewRegistry([]Currency{tc.Currency})
+		require.Error(t, err, tc.TestName)
+	}
+}
+
+func TestRegistry_Amount(t *testing.T) {
+	// Given
+	registry, err := NewRegistry([]Currency{
+		{Code: USDT, Name: "Tether", Digits: 2, Enabled: true},
+		{Code: BTC, Name: "Bitcoin", Digits: 8, Enabled: true},
+		{Code: "DOGE", Name: "Dogecoin", Digits: 8, Enabled: false},
+	})
+	require.NoError(t, err)
+
+	tt := []struct {
+		TestName      string
+		Value, Code   string
+		Expected      string
+		ExpectedError error
+	}{
+		{"Ok", "1.5", BTC, "1.50000000", nil},
+		{"TooManyDecimals", "1.555", USDT, "", money.ErrorTooManyDecimals},
+		{"Maximum", "10000000", BTC, "10000000.00000000", nil},
+		{"AboveMaximum", "10000000.00000001", BTC, "", ErrorAmountTooLarge},
+		{"NegativeAboveMaximum", "-90000000000000000", USDT, "", ErrorAmountTooLarge},
+		{"Disabled", "1", "DOGE", "", ErrorWrongCurrency},
+		{"Unknown", "1", "EUR", "", ErrorWrongCurrency},
+	}
+
+	for _, tc := range tt {
+		// When
+		result, err := registry.Amount(money.MustParse(tc.Value), tc.Code)
+
+		// Then
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		if tc.ExpectedError == nil {
+			require.Equal(t, tc.Expected, result.String(), tc.TestName)
+			require.Equal(t, tc.Code, result.Currency(), tc.TestName)
+		}
+	}
+
+	require.Equal(t, []string{BTC, "DOGE", USDT}, codes(registry.All()))
+	require.Equal(t, []string{BTC, USDT}, codes(registry.Enabled()))
+}
+
+func codes(currencies []Currency) []string {
+	var result []string
+	for _, c := range currencies {
+		result = append(result, c.Code)
+	}
+
+	return result
+}
@@ -0,0 +1,184 @@
+package movement
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+)
+
+const (
+	// ExternalAccount is the counterparty of the money that enters or leaves the wallet, like deposits
+	ExternalAccount = "@external"
+	// FeesAccount collects the fees charged to the users
+	FeesAccount = "@fees"
+	// ExchangeAccount is the counterparty of the currency exchanges
+	ExchangeAccount = "@exchange"
+	// WithdrawalsAccount keeps the money of the withdrawals until they are paid or refunded
+	WithdrawalsAccount = "@withdrawals"
+)
+
+var ErrorUnbalancedEntry = errors.New("movement: unbalanced journal entry")
+
+// IsSystemAccount returns true for the accounts owned by the wallet, their balance can be negative
+// and users can not log in with them nor send money to them
+func IsSystemAccount(alias string) bool {
+	return strings.HasPrefix(alias, "@")
+}
+
+// Entry is a journal entry, every transfer is recorded as an entry with two or more postings
+// that sum to zero per currency
+type Entry struct {
+	ID         int64
+	Type       string
+	Postings   []Posting
+	Conversion *Conversion
+	// RefundOf is the id of the entry given back by this one
+	RefundOf int64
+	// Reference is unique among the entries, empty for the entries that do not need it
+	Reference string
+	// DateCreated is the date of the entry and its postings, it is set when the entry is saved
+	DateCreated time.Time
+}
+
+// Posting is one side of an entry, a negative amount debits the account and a positive one credits it
+type Posting struct {
+	Alias            string
+	InteractionAlias string
+	Type             string
+	Amount           money.Amount
+	// TotalAmount is the balance of the account after the posting, it is set when the entry is saved
+	TotalAmount money.Amount
+}
+
+// isDebit returns true for the movement types that take money out of the account
+func isDebit(movType string) bool {
+	return movType == SendMov || movType == ExchangeOutMov || movType == FeeMov || movType == WithdrawMov
+}
+
+// Validate returns ErrorUnbalancedEntry if the postings do not sum to zero for every currency, or if the
+// sign of a posting does not match its movement type
+func (e Entry) Validate() error {
+	if len(e.Postings) < 2 {
+		return ErrorUnbalancedEntry
+	}
+
+	sums := make(map[string]money.Amount)
+	for _, p := range e.Postings {
+		if p.Amount.Currency() == "" || p.Amount.IsZero() || isDebit(p.Type) != (p.Amount.Sign() < 0) {
+			return ErrorUnbalancedEntry
+		}
+
+		sum, err := sums[p.Amount.Currency()].Add(p.Amount)
+		if err != nil {
+			return err
+		}
+		sums[p.Amount.Currency()] = sum
+	}
+
+	for _, sum := range sums {
+		if !sum.IsZero() {
+			return ErrorUnbalancedEntry
+		}
+	}
+
+	return nil
+}
+
+// newEntry returns the journal entry of a movement, a send moves the money between both users,
+// a deposit takes it from the external account and an exchange trades it with the exchange account.
+// A send with a conversion is paid to the exchange account, that pays the receiver in the destination currency.
+// The fee of the movement is paid by the user to the fees account in the same entry
+func newEntry(m Movement) Entry {
+	var entry Entry
+	switch {
+	case m.Type == ExchangeMov:
+		entry = Entry{Type: m.Type, Conversion: m.Conversion, Postings: []Posting{
+			{Alias: m.Alias, InteractionAlias: ExchangeAccount, Type: ExchangeOutMov, Amount: m.Amount.Neg()},
+			{Alias: ExchangeAccount, InteractionAlias: m.Alias, Type: ExchangeInMov, Amount: m.Amount},
+			{Alias: ExchangeAccount, InteractionAlias: m.Alias, Type: ExchangeOutMov, Amount: m.Conversion.DestinationAmount.Neg()},
+			{Alias: m.Alias, InteractionAlias: ExchangeAccount, Type: ExchangeInMov, Amount: m.Conversion.DestinationAmount},
+		}}
+	case m.Type == DepositMov:
+		entry = Entry{Type: m.Type, Postings: []Posting{
+			{Alias: ExternalAccount, InteractionAlias: m.Alias, Type: SendMov, Amount: m.Amount.Neg()},
+			{Alias: m.Alias, InteractionAlias: ExternalAccount, Type: DepositMov, Amount: m.Amount},
+		}}
+	case m.Conversion != nil:
+		entry = Entry{Type: m.Type, Conversion: m.Conversion, Postings: []Posting{
+			{Alias: m.Alias, InteractionAlias: m.InteractionAlias, Type: m.Type, Amount: m.Amount.Neg()},
+			{Alias: ExchangeAccount, InteractionAlias: m.Alias, Type: ExchangeInMov, Amount: m.Amount},
+			{Alias: ExchangeAccount, InteractionAlias: m.InteractionAlias, Type: ExchangeOutMov, Amount: m.Conversion.DestinationAmount.Neg()},
+			{Alias: m.InteractionAlias, InteractionAlias: m.Alias, Type: ReceiveMov, Amount: m.Conversion.DestinationAmount},
+		}}
+	default:
+		entry = Entry{Type: m.Type, Postings: []Posting{
+			{Alias: m.Alias, InteractionAlias: m.InteractionAlias, Type: m.Type, Amount: m.Amount.Neg()},
+			{Alias: m.InteractionAlias, InteractionAlias: m.Alias, Type: ReceiveMov, Amount: m.Amount},
+		}}
+	}
+
+	entry.RefundOf, entry.Reference = m.RefundOf, m.Reference
+	if !m.Fee.IsZero() {
+		entry.Postings = append(entry.Postings,
+			Posting{Alias: m.Alias, InteractionAlias: FeesAccount, Type: FeeMov, Amount: m.Fee.Neg()},
+			Posting{Alias: FeesAccount, InteractionAlias: m.Alias, Type: ReceiveMov, Amount: m.Fee},
+		)
+	}
+
+	return entry
+}
+
+// aliases returns the accounts of the entry sorted and without duplicates
+func (e Entry) aliases() []string {
+	var aliases []string
+	seen := make(map[string]bool)
+	for _, p := range e.Postings {
+		if !seen[p.Alias] {
+			seen[p.Alias] = true
+			aliases = append(aliases, p.Alias)
+		}
+	}
+
+	sort.Strings(aliases)
+	return aliases
+}
+
+// movement returns the movement of the entry as seen by the user, it is false if the user has no postings in the entry.
+// The movement is the first posting of the user and its fee, the total amount is the balance after its last posting
+func (e Entry) movement(alias string) (Movement, bool) {
+	var m Movement
+	found := false
+	for _, p := range e.Postings {
+		if p.Alias != alias {
+			continue
+		}
+
+		amount := p.Amount
+		if amount.Sign() < 0 {
+			amount = amount.Neg()
+		}
+
+		switch {
+		case !found:
+			found = true
+			m = Movement{Type: p.Type, Alias: alias, InteractionAlias: p.InteractionAlias, Amount: amount,
+				CurrencyName: amount.Currency(), TotalAmount: p.TotalAmount, Fee: money.Zero(amount.Currency(), amount.Scale()),
+				DateCreated: e.DateCreated}
+		case p.Type == FeeMov:
+			m.Fee = amount
+		}
+
+		if amount.Currency() == m.CurrencyName {
+			m.TotalAmount = p.TotalAmount
+		}
+	}
+
+	if e.Type == ExchangeMov {
+		m.Type = ExchangeMov
+	}
+
+	return m, found
+}
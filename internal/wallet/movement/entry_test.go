@@ -0,0 +1,61 @@
+package movement
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntry_Validate(t *testing.T) {
+	tt := []struct {
+		TestName      string
+		Postings      []Posting
+		ExpectedError error
+	}{
+		{"Send", newEntry(Movement{Type: SendMov, Amount: amount("10", ARS), Alias: "a", InteractionAlias: "b"}).Postings, nil},
+		{"Deposit", newEntry(Movement{Type: DepositMov, Amount: amount("10", ARS), Alias: "a"}).Postings, nil},
+		{"SendWithFee", newEntry(Movement{Type: SendMov, Amount: amount("10", ARS), Fee: amount("0.5", ARS), Alias: "a", InteractionAlias: "b"}).Postings, nil},
+		{"Exchange", newEntry(Movement{Type: ExchangeMov, Amount: amount("10", USDT), Conversion: &Conversion{DestinationAmount: amount("3502.5", ARS), DestinationCurrency: ARS}, Alias: "a"}).Postings, nil},
+		{"MultipleCurrencies", []Posting{
+			{Alias: "a", Type: SendMov, Amount: amount("-10", ARS)},
+			{Alias: "b", Type: ReceiveMov, Amount: amount("10", ARS)},
+			{Alias: "b", Type: SendMov, Amount: amount("-0.1", BTC)},
+			{Alias: "a", Type: ReceiveMov, Amount: amount("0.1", BTC)},
+		}, nil},
+		{"SinglePosting", []Posting{{Alias: "a", Type: DepositMov, Amount: amount("10", ARS)}}, ErrorUnbalancedEntry},
+		{"Unbalanced", []Posting{
+			{Alias: "a", Type: SendMov, Amount: amount("-10", ARS)},
+			{Alias: "b", Type: ReceiveMov, Amount: amount("9.99", ARS)},
+		}, ErrorUnbalancedEntry},
+		{"UnbalancedPerCurrency", []Posting{
+			{Alias: "a", Type: SendMov, Amount: amount("-10", ARS)},
+			{Alias: "b", Type: ReceiveMov, Amount: amount("10", USDT)},
+		}, ErrorUnbalancedEntry},
+		{"SignDoesNotMatchType", []Posting{
+			{Alias: "a", Type: ReceiveMov, Amount: amount("-10", ARS)},
+			{Alias: "b", Type: SendMov, Amount: amount("10", ARS)},
+		}, ErrorUnbalancedEntry},
+		{"ZeroPostings", []Posting{
+			{Alias: "a", Type: ReceiveMov, Amount: amount("0", ARS)},
+			{Alias: "b", Type: ReceiveMov, Amount: amount("0", ARS)},
+		}, ErrorUnbalancedEntry},
+	}
+
+	for _, tc := range tt {
+		err := Entry{Type: SendMov, Postings: tc.Postings}.Validate()
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+	}
+}
+
+func TestEntry_Aliases(t *testing.T) {
+	// Given
+	entry := Entry{Postings: []Posting{
+		{Alias: "user", Type: SendMov, Amount: amount("-10", ARS)},
+		{Alias: ExternalAccount, Type: SendMov, Amount: amount("-1", USDT)},
+		{Alias: "other", Type: ReceiveMov, Amount: amount("10", ARS)},
+		{Alias: "user", Type: ReceiveMov, Amount: amount("1", USDT)},
+	}}
+
+	// Then
+	require.Equal(t, []string{ExternalAccount, "other", "user"}, entry.aliases())
+}
@@ -0,0 +1,293 @@
+package movement
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+)
+
+const (
+	// OrderDesc lists the newest rows first, OrderAsc the oldest ones
+	OrderDesc = "desc"
+	OrderAsc  = "asc"
+
+	DefaultHistoryLimit = 50
+	MaxHistoryLimit     = 200
+)
+
+var ErrorInvalidCursor = errors.New("movement: invalid history cursor")
+
+// HistoryFilter selects the rows of a history page, the zero value of a field does not filter
+type HistoryFilter struct {
+	CurrencyName     string
+	Type             string
+	InteractionAlias string
+	// From is included and To is excluded
+	From, To time.Time
+	// MinAmount and MaxAmount are compared with the amount of the rows, both are included
+	MinAmount, MaxAmount *money.Amount
+	// Cursor is the Next of the previous page, empty for the first page
+	Cursor string
+	// Order is OrderDesc by default
+	Order string
+	// Limit is the number of rows of the page, DefaultHistoryLimit by default and MaxHistoryLimit at most
+	Limit int
+}
+
+// History is a page of the history, Next is the cursor of the following page and it is empty in the last page
+type History struct {
+	Rows []Row  `json:"rows"`
+	Next string `json:"next,omitempty"`
+}
+
+// GetHistory returns a page of the account history of the user, the rows are sorted by the order they were saved
+// in so a cursor keeps working while new movements are saved. The refunds are linked with the refunded sends
+func (r repository) GetHistory(ctx context.Context, alias string, f HistoryFilter) (History, error) {
+	query, args, err := r.historyQuery(alias, f)
+	if err != nil {
+		return History{}, err
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	if limit > MaxHistoryLimit {
+		limit = MaxHistoryLimit
+	}
+	// one more row tells if there is a following page
+	rows, err := r.db.QueryContext(ctx, query+" LIMIT ?;", append(args, limit+1)...)
+	if err != nil {
+		return History{}, err
+	}
+	defer rows.Close()
+
+	history := History{Rows: []Row{}}
+	var lastID int64
+	for rows.Next() {
+		if len(history.Rows) == limit {
+			history.Next = encodeCursor(lastID)
+			break
+		}
+
+		row, err := r.scanHistoryRow(rows)
+		if err != nil {
+			return History{}, err
+		}
+		lastID = row.MovementID
+
+		history.Rows = append(history.Rows, row)
+	}
+
+	if err = rows.Err(); err != nil {
+		return History{}, err
+	}
+
+	if err = r.linkRefunds(ctx, history.Rows); err != nil {
+		return History{}, err
+	}
+
+	return history, nil
+}
+
+// ExportHistory calls fn with every row of the history that matches the filter, the rows are read one by one
+// so the history is never loaded in memory. The cursor and the limit of the filter are ignored and the
+// refunds are not linked
+func (r repository) ExportHistory(ctx context.Context, alias string, f HistoryFilter, fn func(Row) error) error {
+	f.Cursor = ""
+	query, args, err := r.historyQuery(alias, f)
+	if err != nil {
+		return err
+	}
+
+	rows, err := r.db.QueryContext(ctx, query+";", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row, err := r.scanHistoryRow(rows)
+		if err != nil {
+			return err
+		}
+
+		if err = fn(row); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// historyQuery returns the query of the rows of the history that match the filter sorted by id, without a limit
+func (r repository) historyQuery(alias string, f HistoryFilter) (string, []interface{}, error) {
+	var conditions []string
+	args := []interface{}{alias}
+	if f.CurrencyName != "" {
+		if _, ok := r.currencies.Get(f.CurrencyName); !ok {
+			return "", nil, ErrorWrongCurrency
+		}
+		conditions = append(conditions, "m.currency_name = ?")
+		args = append(args, f.CurrencyName)
+	}
+	if f.Type != "" {
+		conditions = append(conditions, "m.mov_type = ?")
+		args = append(args, f.Type)
+	}
+	if f.InteractionAlias != "" {
+		conditions = append(conditions, "m.interaction_alias = ?")
+		args = append(args, f.InteractionAlias)
+	}
+	if !f.From.IsZero() {
+		conditions = append(conditions, "m.date_created >= ?")
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, "m.date_created < ?")
+		args = append(args, f.To)
+	}
+	if f.MinAmount != nil {
+		conditions = append(conditions, "m.tx_amount >= ?")
+		args = append(args, *f.MinAmount)
+	}
+	if f.MaxAmount != nil {
+		conditions = append(conditions, "m.tx_amount <= ?")
+		args = append(args, *f.MaxAmount)
+	}
+
+	order, after := "DESC", "<"
+	if f.Order == OrderAsc {
+		order, after = "ASC", ">"
+	}
+	if f.Cursor != "" {
+		id, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, "m.id "+after+" ?")
+		args = append(args, id)
+	}
+
+	query := "SELECT m.id,m.entry_id,j.refund_of,m.currency_name,m.mov_type,m.date_created,m.tx_amount,m.total_amount," +
+		"m.interaction_alias FROM movements m LEFT JOIN journal_entries j ON j.id = m.entry_id WHERE m.alias = ?"
+	for _, c := range conditions {
+		query += " AND " + c
+	}
+
+	return query + " ORDER BY m.id " + order, args, nil
+}
+
+// scanHistoryRow reads a row of the history query
+func (r repository) scanHistoryRow(rows *sql.Rows) (Row, error) {
+	var entryID, refundOf sql.NullInt64
+	var amount, totalAmount string
+	var row Row
+	err := rows.Scan(&row.MovementID, &entryID, &refundOf, &row.CurrencyName, &row.Type, &row.DateCreated, &amount, &totalAmount,
+		&row.InteractionAlias)
+	if err != nil {
+		return Row{}, err
+	}
+
+	if row.Amount, err = r.bind(amount, row.CurrencyName); err != nil {
+		return Row{}, err
+	}
+	if row.TotalAmount, err = r.bind(totalAmount, row.CurrencyName); err != nil {
+		return Row{}, err
+	}
+
+	row.ID = entryID.Int64
+	row.RefundOf = refundOf.Int64
+
+	return row, nil
+}
+
+// GetBalanceAt returns the balance of the account of the user as of the given time, the balance after its
+// last movement saved before it. A movement saved at the given time is not included, like the history, the
+// statements and the exports that start at that time include it
+func (r repository) GetBalanceAt(ctx context.Context, alias, currencyName string, at time.Time) (money.Amount, error) {
+	currency, ok := r.currencies.Get(currencyName)
+	if !ok {
+		return money.Amount{}, ErrorWrongCurrency
+	}
+
+	var total string
+	err := r.db.QueryRowContext(ctx, "SELECT total_amount FROM movements WHERE alias = ? AND currency_name = ? "+
+		"AND date_created < ? ORDER BY id DESC LIMIT 1;", alias, currency.Code, at).Scan(&total)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return money.Zero(currency.Code, currency.Digits), nil
+		}
+		return money.Amount{}, err
+	}
+
+	return r.bind(total, currency.Code)
+}
+
+// linkRefunds sets the refunds of the rows, the refunds can be in other pages of the history
+func (r repository) linkRefunds(ctx context.Context, rows []Row) error {
+	var ids []interface{}
+	seen := make(map[int64]bool)
+	for _, row := range rows {
+		if row.ID != 0 && !seen[row.ID] {
+			seen[row.ID] = true
+			ids = append(ids, row.ID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	result, err := r.db.QueryContext(ctx, "SELECT refund_of,id FROM journal_entries WHERE refund_of IN (?"+
+		strings.Repeat(",?", len(ids)-1)+") ORDER BY id;", ids...)
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+
+	refunds := make(map[int64][]int64)
+	for result.Next() {
+		var refundOf, id int64
+		if err = result.Scan(&refundOf, &id); err != nil {
+			return err
+		}
+
+		refunds[refundOf] = append(refunds[refundOf], id)
+	}
+
+	if err = result.Err(); err != nil {
+		return err
+	}
+
+	for i := range rows {
+		rows[i].RefundedBy = refunds[rows[i].ID]
+	}
+
+	return nil
+}
+
+// encodeCursor hides the id of the last row of a page, the cursor is opaque for the clients
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	value, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrorInvalidCursor
+	}
+
+	id, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, ErrorInvalidCursor
+	}
+
+	return id, nil
+}
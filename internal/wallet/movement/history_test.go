@@ -0,0 +1,158 @@
+package movement
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+const historySQL = "SELECT m.id,m.entry_id,j.refund_of,m.currency_name,m.mov_type,m.date_created,m.tx_amount,m.total_amount," +
+	"m.interaction_alias FROM movements m LEFT JOIN journal_entries j ON j.id = m.entry_id WHERE m.alias = ?"
+
+var historyColumns = []string{"id", "entry_id", "refund_of", "currency_name", "mov_type", "date_created", "tx_amount",
+	"total_amount", "interaction_alias"}
+
+func TestGetHistory_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, testCurrencies)
+	defer db.Close()
+	created := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	// When
+	// the newest rows first, the fourth row is only read to know there is another page
+	mock.ExpectQuery(historySQL+" ORDER BY m.id DESC LIMIT ?;").WithArgs("user", 4).WillReturnRows(sqlmock.NewRows(historyColumns).
+		AddRow(9, 4, 2, ARS, ReceiveMov, created, "10.000000000000000000", "69.500000000000000000", "otheruser").
+		AddRow(8, 3, nil, BTC, ReceiveMov, created, "0.100000000000000000", "0.100000000000000000", "otheruser").
+		AddRow(6, 2, nil, ARS, SendMov, created, "40.500000000000000000", "59.500000000000000000", "otheruser").
+		AddRow(3, nil, nil, ARS, DepositMov, created, "100.000000000000000000", "100.000000000000000000", "user"))
+	mock.ExpectQuery("SELECT refund_of,id FROM journal_entries WHERE refund_of IN (?,?,?) ORDER BY id;").WithArgs(4, 3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"refund_of", "id"}).AddRow(2, 4).AddRow(2, 7))
+
+	// Then
+	result, err := repository.GetHistory(context.Background(), "user", HistoryFilter{Limit: 3})
+	require.NoError(t, err)
+	require.Equal(t, History{
+		Rows: []Row{
+			{ID: 4, MovementID: 9, CurrencyName: ARS, InteractionAlias: "otheruser", Type: ReceiveMov, DateCreated: created, Amount: amount("10", ARS),
+				TotalAmount: amount("69.5", ARS), RefundOf: 2},
+			{ID: 3, MovementID: 8, CurrencyName: BTC, InteractionAlias: "otheruser", Type: ReceiveMov, DateCreated: created, Amount: amount("0.1", BTC),
+				TotalAmount: amount("0.1", BTC)},
+			// the second refund is in another page
+			{ID: 2, MovementID: 6, CurrencyName: ARS, InteractionAlias: "otheruser", Type: SendMov, DateCreated: created,
+				Amount: amount("40.5", ARS), TotalAmount: amount("59.5", ARS), RefundedBy: []int64{4, 7}},
+		},
+		Next: encodeCursor(6),
+	}, result)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetHistory_When_FilteredPage_Then_ReturnsTheRowsAfterTheCursor(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, testCurrencies)
+	defer db.Close()
+	from := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)
+	min, max := amount("10", ARS), amount("50", ARS)
+	filter := HistoryFilter{CurrencyName: ARS, Type: SendMov, InteractionAlias: "otheruser", From: from, To: to,
+		MinAmount: &min, MaxAmount: &max, Cursor: encodeCursor(6), Order: OrderAsc}
+
+	// When
+	mock.ExpectQuery(historySQL+" AND m.currency_name = ? AND m.mov_type = ? AND m.interaction_alias = ? AND m.date_created >= ?"+
+		" AND m.date_created < ? AND m.tx_amount >= ? AND m.tx_amount <= ? AND m.id > ? ORDER BY m.id ASC LIMIT ?;").
+		WithArgs("user", ARS, SendMov, "otheruser", from, to, min, max, 6, DefaultHistoryLimit+1).
+		WillReturnRows(sqlmock.NewRows(historyColumns).
+			AddRow(10, 5, nil, ARS, SendMov, from, "20.000000000000000000", "49.500000000000000000", "otheruser"))
+	mock.ExpectQuery("SELECT refund_of,id FROM journal_entries WHERE refund_of IN (?) ORDER BY id;").WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"refund_of", "id"}))
+
+	// Then
+	result, err := repository.GetHistory(context.Background(), "user", filter)
+	require.NoError(t, err)
+	require.Len(t, result.Rows, 1)
+	require.Equal(t, int64(5), result.Rows[0].ID)
+	require.Empty(t, result.Next)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetHistory_When_CursorIsInvalid_Then_ReturnsError(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, testCurrencies)
+	defer db.Close()
+
+	// When
+	_, err = repository.GetHistory(context.Background(), "user", HistoryFilter{Cursor: "not a cursor"})
+
+	// Then
+	require.Equal(t, ErrorInvalidCursor, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExportHistory_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, testCurrencies)
+	defer db.Close()
+	from := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	// When
+	// the cursor and the limit are ignored
+	mock.ExpectQuery(historySQL+" AND m.currency_name = ? AND m.date_created >= ? AND m.date_created < ? ORDER BY m.id ASC;").
+		WithArgs("user", ARS, from, to).WillReturnRows(sqlmock.NewRows(historyColumns).
+		AddRow(6, 2, nil, ARS, SendMov, from, "40.500000000000000000", "59.500000000000000000", "otheruser").
+		AddRow(9, 4, 2, ARS, ReceiveMov, from, "10.000000000000000000", "69.500000000000000000", "otheruser"))
+
+	// Then
+	var exported []Row
+	err = repository.ExportHistory(context.Background(), "user", HistoryFilter{CurrencyName: ARS, From: from, To: to,
+		Order: OrderAsc, Cursor: encodeCursor(3), Limit: 1}, func(row Row) error {
+		exported = append(exported, row)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, exported, 2)
+	require.Equal(t, "-40.50", exported[0].SignedAmount().String())
+	require.Equal(t, int64(2), exported[1].RefundOf)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetBalanceAt(t *testing.T) {
+	tt := []struct {
+		TestName        string
+		Rows            *sqlmock.Rows
+		ExpectedBalance string
+	}{
+		{"Ok", sqlmock.NewRows([]string{"total_amount"}).AddRow("59.500000000000000000"), "59.50"},
+		// accounts without movements before the time had no money
+		{"NoMovements", sqlmock.NewRows([]string{"total_amount"}), "0.00"},
+	}
+
+	for _, tc := range tt {
+		// Given
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		repository := New(db, testCurrencies)
+		at := time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)
+
+		// When
+		mock.ExpectQuery("SELECT total_amount FROM movements WHERE alias = ? AND currency_name = ? AND date_created < ? "+
+			"ORDER BY id DESC LIMIT 1;").WithArgs("user", ARS, at).WillReturnRows(tc.Rows)
+
+		// Then
+		balance, err := repository.GetBalanceAt(context.Background(), "user", ARS, at)
+		require.NoError(t, err, tc.TestName)
+		require.Equal(t, tc.ExpectedBalance, balance.String(), tc.TestName)
+		require.NoError(t, mock.ExpectationsWereMet(), tc.TestName)
+		db.Close()
+	}
+}
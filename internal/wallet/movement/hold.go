@@ -0,0 +1,264 @@
+package movement
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+)
+
+const (
+	// HoldActive holds reserve the money until they are captured, released or expire
+	HoldActive    = "active"
+	HoldCaptured  = "captured"
+	HoldReleased  = "released"
+	HoldExpired   = "expired"
+	selectHoldSQL = "SELECT id,alias,interaction_alias,currency_name,amount,fee,captured_amount,status,expires_at,created_at FROM holds "
+)
+
+var (
+	ErrorHoldNotFound       = errors.New("movement: hold not found")
+	ErrorHoldNotActive      = errors.New("movement: the hold was already captured, released or expired")
+	ErrorCaptureExceedsHold = errors.New("movement: the captured amount is greater than the held amount")
+)
+
+// Hold reserves money of the user to be sent later to the interaction alias, the held money is not available
+// for other movements but it is still part of the balance
+type Hold struct {
+	ID               int64        `json:"id"`
+	Alias            string       `json:"-"`
+	InteractionAlias string       `json:"interactionalias"`
+	Amount           money.Amount `json:"amount"`
+	CurrencyName     string       `json:"currencyname"`
+	// Fee is the fee of the send of the held amount, it is held with the amount and charged when the hold is captured
+	Fee money.Amount `json:"fee"`
+	// CapturedAmount is the amount sent when the hold was captured, the rest was released
+	CapturedAmount money.Amount `json:"capturedamount"`
+	Status         string       `json:"status"`
+	ExpiresAt      time.Time    `json:"expiresat"`
+	CreatedAt      time.Time    `json:"createdat"`
+}
+
+// Balance is the balance of an account, the available balance is the ledger balance minus the active holds
+type Balance struct {
+	Ledger    money.Amount `json:"ledger"`
+	Available money.Amount `json:"available"`
+}
+
+// LedgerBalance is the balance of an account without the holds
+type LedgerBalance struct {
+	Ledger money.Amount `json:"ledger"`
+}
+
+// queryer runs a query in the database or in a transaction
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// heldFunds returns the money held by the active holds of the account, their amounts and fees
+func (r repository) heldFunds(ctx context.Context, q queryer, currency Currency, alias string) (money.Amount, error) {
+	query := "SELECT COALESCE(SUM(amount+fee),0) FROM holds WHERE alias = ? AND currency_name = ? AND status = ? AND expires_at > ?;"
+	var held string
+	if err := q.QueryRowContext(ctx, query, alias, currency.Code, HoldActive, time.Now()).Scan(&held); err != nil {
+		return money.Amount{}, err
+	}
+
+	return r.bind(held, currency.Code)
+}
+
+// CreateHold reserves the money of the hold and its fee if the user has it available
+func (r repository) CreateHold(ctx context.Context, h Hold) (Hold, error) {
+	currency, ok := r.currencies.Get(h.CurrencyName)
+	if !ok || !currency.Enabled {
+		return Hold{}, ErrorWrongCurrency
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return Hold{}, err
+	}
+
+	// the account is locked like in a movement, so the money can not be spent while it is held
+	if err = lockAccounts(ctx, tx, []string{h.Alias}); err != nil {
+		tx.Rollback()
+		return Hold{}, err
+	}
+
+	balance, err := lockFunds(ctx, tx, currency, h.Alias)
+	if err != nil {
+		tx.Rollback()
+		return Hold{}, err
+	}
+
+	held, err := r.heldFunds(ctx, tx, currency, h.Alias)
+	if err != nil {
+		tx.Rollback()
+		return Hold{}, err
+	}
+
+	if h.Fee, err = money.Zero(currency.Code, currency.Digits).Add(h.Fee); err != nil {
+		tx.Rollback()
+		return Hold{}, err
+	}
+
+	available, err := balance.Sub(held)
+	if err != nil {
+		tx.Rollback()
+		return Hold{}, err
+	}
+
+	total, err := h.Amount.Add(h.Fee)
+	if err != nil {
+		tx.Rollback()
+		return Hold{}, err
+	}
+
+	if available.Cmp(total) < 0 {
+		tx.Rollback()
+		return Hold{}, ErrorInsufficientFunds
+	}
+
+	h.Status = HoldActive
+	h.CapturedAmount = money.Zero(currency.Code, currency.Digits)
+	result, err := tx.ExecContext(ctx, "INSERT INTO holds(alias,interaction_alias,currency_name,amount,fee,captured_amount,status,"+
+		"expires_at,created_at) VALUES(?,?,?,?,?,?,?,?,?);",
+		h.Alias, h.InteractionAlias, h.CurrencyName, h.Amount, h.Fee, h.CapturedAmount, h.Status, h.ExpiresAt, h.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		return Hold{}, err
+	}
+
+	if h.ID, err = result.LastInsertId(); err != nil {
+		tx.Rollback()
+		return Hold{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Hold{}, err
+	}
+
+	return h, nil
+}
+
+// GetHold returns the hold of the user, an active hold past its expiration is returned as expired
+func (r repository) GetHold(ctx context.Context, alias string, id int64) (Hold, error) {
+	return r.getHold(ctx, r.db, selectHoldSQL+"WHERE id = ? AND alias = ?;", id, alias)
+}
+
+// CaptureHold sends the movement with the money of the hold, the hold ends captured and the held money
+// that was not sent is available again. The amount and the fee of the movement can not be greater than the held ones
+func (r repository) CaptureHold(ctx context.Context, id int64, m Movement) (Movement, error) {
+	entry := newEntry(m)
+	if err := r.validate(entry); err != nil {
+		return Movement{}, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return Movement{}, err
+	}
+
+	if err = lockAccounts(ctx, tx, entry.aliases()); err != nil {
+		tx.Rollback()
+		return Movement{}, err
+	}
+
+	h, err := r.getHold(ctx, tx, selectHoldSQL+"WHERE id = ? AND alias = ? FOR UPDATE;", id, m.Alias)
+	if err != nil {
+		tx.Rollback()
+		return Movement{}, err
+	}
+
+	if h.Status != HoldActive || h.CurrencyName != m.CurrencyName || h.InteractionAlias != m.InteractionAlias {
+		tx.Rollback()
+		return Movement{}, ErrorHoldNotActive
+	}
+
+	if m.Amount.Cmp(h.Amount) > 0 || m.Fee.Cmp(h.Fee) > 0 {
+		tx.Rollback()
+		return Movement{}, ErrorCaptureExceedsHold
+	}
+
+	// the hold stops holding the money before the funds of the send are checked
+	_, err = tx.ExecContext(ctx, "UPDATE holds SET status = ?, captured_amount = ? WHERE id = ?;", HoldCaptured, m.Amount, id)
+	if err != nil {
+		tx.Rollback()
+		return Movement{}, err
+	}
+
+	if entry, err = r.insertEntry(ctx, tx, entry); err != nil {
+		tx.Rollback()
+		return Movement{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Movement{}, err
+	}
+
+	return saved(m, entry), nil
+}
+
+// ReleaseHold ends the active hold of the user, the held money is available again
+func (r repository) ReleaseHold(ctx context.Context, alias string, id int64) (Hold, error) {
+	result, err := r.db.ExecContext(ctx, "UPDATE holds SET status = ? WHERE id = ? AND alias = ? AND status = ? AND expires_at > ?;",
+		HoldReleased, id, alias, HoldActive, time.Now())
+	if err != nil {
+		return Hold{}, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return Hold{}, err
+	}
+
+	h, err := r.GetHold(ctx, alias, id)
+	if err != nil {
+		return Hold{}, err
+	}
+
+	if affected == 0 {
+		return Hold{}, ErrorHoldNotActive
+	}
+
+	return h, nil
+}
+
+// ExpireHolds marks as expired the active holds that expired before the given time, the expired holds
+// do not hold money even before they are marked
+func (r repository) ExpireHolds(ctx context.Context, before time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE holds SET status = ? WHERE status = ? AND expires_at <= ?;",
+		HoldExpired, HoldActive, before)
+
+	return err
+}
+
+func (r repository) getHold(ctx context.Context, q queryer, query string, args ...interface{}) (Hold, error) {
+	var h Hold
+	var amount, fee, capturedAmount string
+	err := q.QueryRowContext(ctx, query, args...).Scan(&h.ID, &h.Alias, &h.InteractionAlias, &h.CurrencyName, &amount, &fee,
+		&capturedAmount, &h.Status, &h.ExpiresAt, &h.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Hold{}, ErrorHoldNotFound
+		}
+		return Hold{}, err
+	}
+
+	if h.Amount, err = r.bind(amount, h.CurrencyName); err != nil {
+		return Hold{}, err
+	}
+	if h.Fee, err = r.bind(fee, h.CurrencyName); err != nil {
+		return Hold{}, err
+	}
+	if h.CapturedAmount, err = r.bind(capturedAmount, h.CurrencyName); err != nil {
+		return Hold{}, err
+	}
+
+	if h.Status == HoldActive && !time.Now().Before(h.ExpiresAt) {
+		h.Status = HoldExpired
+	}
+
+	return h, nil
+}
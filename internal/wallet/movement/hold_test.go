@@ -0,0 +1,131 @@
+package movement
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+const createHoldQuery = "INSERT INTO holds(alias,interaction_alias,currency_name,amount,fee,captured_amount,status," +
+	"expires_at,created_at) VALUES(?,?,?,?,?,?,?,?,?);"
+
+var holdColumns = []string{"id", "alias", "interaction_alias", "currency_name", "amount", "fee", "captured_amount", "status",
+	"expires_at", "created_at"}
+
+func TestCreateHold(t *testing.T) {
+	tt := []struct {
+		TestName      string
+		Balance, Held string
+		ExpectedError error
+	}{
+		{"Ok", "150.000000000000000000", "50.000000000000000000", nil},
+		// the money of the other holds is not available
+		{"HeldByOtherHolds", "150.000000000000000000", "50.020000000000000000", ErrorInsufficientFunds},
+		// the fee is held with the amount
+		{"FeeNotAvailable", "150.000000000000000000", "50.010000000000000000", ErrorInsufficientFunds},
+	}
+
+	for _, tc := range tt {
+		// Given
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		repository := New(db, testCurrencies)
+		expires := time.Date(2022, 3, 2, 10, 0, 0, 0, time.UTC)
+		created := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+		hold := Hold{Alias: "user", InteractionAlias: "merchant", Amount: amount("99.98", ARS), Fee: amount("0.02", ARS),
+			CurrencyName: ARS, ExpiresAt: expires, CreatedAt: created}
+
+		// When
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockUserQuery).WithArgs("user").
+			WillReturnRows(sqlmock.NewRows([]string{"alias"}).AddRow("user"))
+		mock.ExpectQuery(lockFundsQuery).WithArgs("user", ARS).
+			WillReturnRows(sqlmock.NewRows([]string{"total_amount"}).AddRow(tc.Balance))
+		expectHeld(mock, "user", ARS, tc.Held)
+		if tc.ExpectedError == nil {
+			mock.ExpectExec(createHoldQuery).
+				WithArgs("user", "merchant", ARS, hold.Amount, hold.Fee, amount("0", ARS), HoldActive, expires, created).
+				WillReturnResult(sqlmock.NewResult(4, 1))
+			mock.ExpectCommit()
+		} else {
+			mock.ExpectRollback()
+		}
+
+		// Then
+		saved, err := repository.CreateHold(context.Background(), hold)
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		require.NoError(t, mock.ExpectationsWereMet(), tc.TestName)
+		if tc.ExpectedError == nil {
+			require.Equal(t, int64(4), saved.ID, tc.TestName)
+			require.Equal(t, HoldActive, saved.Status, tc.TestName)
+		}
+		db.Close()
+	}
+}
+
+func TestCaptureHold_When_AmountIsGreaterThanTheHold_Then_ReturnsError(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, testCurrencies)
+	defer db.Close()
+	movement := Movement{Type: SendMov, Alias: "user", InteractionAlias: "merchant", Amount: amount("100.01", ARS), CurrencyName: ARS}
+
+	// When
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockUsersQuery).WithArgs("merchant", "user").
+		WillReturnRows(sqlmock.NewRows([]string{"alias"}).AddRow("merchant").AddRow("user"))
+	mock.ExpectQuery(selectHoldSQL+"WHERE id = ? AND alias = ? FOR UPDATE;").WithArgs(3, "user").
+		WillReturnRows(sqlmock.NewRows(holdColumns).AddRow(3, "user", "merchant", ARS, "100.000000000000000000", "0.000000000000000000",
+			"0.000000000000000000", HoldActive, time.Now().Add(time.Hour), time.Now()))
+	mock.ExpectRollback()
+
+	// Then
+	_, err = repository.CaptureHold(context.Background(), 3, movement)
+	require.Equal(t, ErrorCaptureExceedsHold, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetHold_When_ActiveHoldExpired_Then_ReturnsExpired(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, testCurrencies)
+	defer db.Close()
+
+	// When
+	// the job did not mark it yet
+	mock.ExpectQuery(selectHoldSQL+"WHERE id = ? AND alias = ?;").WithArgs(3, "user").
+		WillReturnRows(sqlmock.NewRows(holdColumns).AddRow(3, "user", "merchant", ARS, "100.000000000000000000", "0.000000000000000000",
+			"0.000000000000000000", HoldActive, time.Now().Add(-time.Second), time.Now().Add(-time.Hour)))
+
+	// Then
+	hold, err := repository.GetHold(context.Background(), "user", 3)
+	require.NoError(t, err)
+	require.Equal(t, HoldExpired, hold.Status)
+	require.Equal(t, "100.00", hold.Amount.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReleaseHold_When_HoldIsNotActive_Then_ReturnsError(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, testCurrencies)
+	defer db.Close()
+
+	// When
+	mock.ExpectExec("UPDATE holds SET status = ? WHERE id = ? AND alias = ? AND status = ? AND expires_at > ?;").
+		WithArgs(HoldReleased, 3, "user", HoldActive, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(selectHoldSQL+"WHERE id = ? AND alias = ?;").WithArgs(3, "user").
+		WillReturnRows(sqlmock.NewRows(holdColumns).AddRow(3, "user", "merchant", ARS, "100.000000000000000000", "0.000000000000000000",
+			"100.000000000000000000", HoldCaptured, time.Now().Add(time.Hour), time.Now()))
+
+	// Then
+	_, err = repository.ReleaseHold(context.Background(), "user", 3)
+	require.Equal(t, ErrorHoldNotActive, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
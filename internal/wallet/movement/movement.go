@@ -4,78 +4,121 @@ import (
 	"context"
 	"errors"
 	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
 )
 
 const (
 	DepositMov = "deposit"
 	ReceiveMov = "receive"
 	SendMov    = "send"
+	// ExchangeMov converts money between two accounts of the same user, it is recorded as an
+	// ExchangeOutMov in the origin currency and an ExchangeInMov in the destination currency
+	ExchangeMov    = "exchange"
+	ExchangeOutMov = "exchange_out"
+	ExchangeInMov  = "exchange_in"
+	// FeeMov is the fee charged for a movement, it is shown in the history as a line of its own
+	FeeMov = "fee"
+	// WithdrawMov takes the money of a withdrawal to the withdrawals account until it is paid
+	WithdrawMov = "withdraw"
+
+	// StatusCompleted movements were saved, a send given back by its receiver is refunded or partially refunded
+	StatusCompleted         = "completed"
+	StatusPartiallyRefunded = "partially_refunded"
+	StatusRefunded          = "refunded"
 
 	BTC  = "BTC"
 	ARS  = "ARS"
 	USDT = "USDT"
 )
 
-var movementTables = map[string]string{
-	USDT: "movements_usdt",
-	ARS:  "movements_ars",
-	BTC:  "movements_btc",
-}
-
 var (
 	ErrorInsufficientFunds = errors.New("movement: insufficient funds")
 	ErrorWrongCurrency     = errors.New("movement: wrong currency")
+	ErrorAccountClosed     = errors.New("movement: the account is closed")
+	ErrorAmountTooLarge    = errors.New("movement: the amount is above the maximum of the currency")
+	// ErrorFeeExceedsAmount is returned when the fee of a deposit is greater than the deposited amount
+	ErrorFeeExceedsAmount = errors.New("movement: the fee is greater than the deposited amount")
+	// ErrorAlreadySaved is returned when an entry with the same reference was saved before
+	ErrorAlreadySaved = errors.New("movement: already saved")
 )
 
-type AccountBalance map[string]float64
-type AccountHistory map[string][]Row
+type AccountBalance map[string]Balance
+
+// AccountBalanceAt is the balance of every account at a past time, the holds of the past are not known so it
+// only has the ledger balance
+type AccountBalanceAt map[string]LedgerBalance
 
 type Repository interface {
-	Save(ctx context.Context, movement Movement) error
+	Save(ctx context.Context, movement Movement) (Movement, error)
 	InitSave(ctx context.Context, movement Movement) error
 	GetAccountExtract(ctx context.Context, alias string) (AccountBalance, error)
-	GetHistory(ctx context.Context, alias string) (AccountHistory, error)
-	GetFunds(ctx context.Context, currencyName, alias string) (float64, error)
+	GetAccountExtractAt(ctx context.Context, alias string, at time.Time) (AccountBalanceAt, error)
+	GetHistory(ctx context.Context, alias string, filter HistoryFilter) (History, error)
+	ExportHistory(ctx context.Context, alias string, filter HistoryFilter, fn func(Row) error) error
+	GetBalanceAt(ctx context.Context, alias, currencyName string, at time.Time) (money.Amount, error)
+	GetFunds(ctx context.Context, currencyName, alias string) (money.Amount, error)
+	CreateHold(ctx context.Context, h Hold) (Hold, error)
+	GetHold(ctx context.Context, alias string, id int64) (Hold, error)
+	CaptureHold(ctx context.Context, id int64, m Movement) (Movement, error)
+	ReleaseHold(ctx context.Context, alias string, id int64) (Hold, error)
+	Refund(ctx context.Context, alias string, id int64, amount money.Amount) (Movement, error)
+	GetMovement(ctx context.Context, alias string, id int64) (Movement, error)
 }
 
 type Movement struct {
-	ID               int64   `json:"id"`
-	Type             string  `json:"type" binding:"required,oneof=send"`
-	Amount           float64 `json:"amount" binding:"required,gte=0"`
-	CurrencyName     string  `json:"currencyname" binding:"required,oneof=usdt btc ars"`
-	Alias            string  `json:"alias" binding:"required"`
-	TotalAmount      float64 `json:"totalamount"`
-	InteractionAlias string  `json:"interactionalias" binding:"required"`
+	ID               int64        `json:"id"`
+	Type             string       `json:"type" binding:"required,oneof=send"`
+	Amount           money.Amount `json:"amount" binding:"required,gte=0"`
+	CurrencyName     string       `json:"currencyname"`
+	Alias            string       `json:"alias" binding:"required"`
+	TotalAmount      money.Amount `json:"totalamount"`
+	InteractionAlias string       `json:"interactionalias" binding:"required"`
+	// Conversion is set when the movement is paid in one currency and credited in another one
+	Conversion *Conversion `json:"conversion,omitempty"`
+	// Fee is charged to the user in the currency of the movement on top of the amount
+	Fee money.Amount `json:"fee"`
+	// RefundOf is the id of the send given back by this movement
+	RefundOf int64 `json:"refundof,omitempty"`
+	// Reference identifies a movement that must be saved once, a second movement with the same reference
+	// is rejected with ErrorAlreadySaved
+	Reference   string    `json:"-"`
+	Status      string    `json:"status"`
+	DateCreated time.Time `json:"datecreated"`
 }
 
-type Currency struct {
-	ID     int64
-	Name   string
-	Digits float64
+// Conversion is the conversion of a movement, a request sets only the destination currency and the rest
+// is filled with the applied rate
+type Conversion struct {
+	DestinationAmount   money.Amount `json:"destinationamount"`
+	DestinationCurrency string       `json:"destinationcurrency"`
+	// Rate is the applied rate, the market rate minus the spread
+	Rate money.Amount `json:"rate"`
+	// Spread is the part of the converted amount kept by the wallet, in the destination currency
+	Spread money.Amount `json:"spread"`
 }
 
 type Row struct {
+	// ID is the id of the movement of the row, the rows of the initialized accounts have no movement
+	ID int64 `json:",omitempty"`
+	// MovementID is the id of the row in the movements table, unlike the ID every row has one
+	MovementID       int64
+	CurrencyName     string
 	InteractionAlias string
 	Type             string
 	DateCreated      time.Time
-	Amount           float64
-	TotalAmount      float64
-}
-
-func getCurrencyTable(currency string) string {
-	return movementTables[currency]
+	Amount           money.Amount
+	TotalAmount      money.Amount
+	// RefundOf is the id of the send given back by the row, RefundedBy the ids of the refunds of the row
+	RefundOf   int64   `json:",omitempty"`
+	RefundedBy []int64 `json:",omitempty"`
 }
 
-func getCurrenciesTables(currency string) []string {
-	var tables = make([]string, 0)
-
-	if movementTables[currency] == "" {
-		for _, v := range movementTables {
-			tables = append(tables, v)
-		}
-	} else {
-		tables = append(tables, movementTables[currency])
+// SignedAmount returns the amount of the row, negative when the row takes money out of the account
+func (r Row) SignedAmount() money.Amount {
+	if isDebit(r.Type) {
+		return r.Amount.Neg()
 	}
 
-	return tables
+	return r.Amount
 }
@@ -0,0 +1,129 @@
+package movement
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+)
+
+var (
+	ErrorMovementNotFound      = errors.New("movement: movement not found")
+	ErrorAlreadyRefunded       = errors.New("movement: the movement was already refunded")
+	ErrorRefundExceedsMovement = errors.New("movement: the refunded amount is greater than the rest of the movement")
+)
+
+// Refund gives back to the sender the money received by the user in a send, a zero amount refunds all the money
+// that was not refunded yet. The refund is a send from the user to the sender in the received currency that
+// references the refunded entry, the fees are not refunded
+func (r repository) Refund(ctx context.Context, alias string, id int64, amount money.Amount) (Movement, error) {
+	received, err := r.received(ctx, alias, id)
+	if err != nil {
+		return Movement{}, err
+	}
+
+	m := Movement{
+		Type:             SendMov,
+		Alias:            alias,
+		InteractionAlias: received.InteractionAlias,
+		CurrencyName:     received.CurrencyName,
+		RefundOf:         id,
+	}
+	if !amount.IsZero() {
+		if m.Amount, err = r.currencies.Amount(amount, m.CurrencyName); err != nil {
+			return Movement{}, err
+		}
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return Movement{}, err
+	}
+
+	// only the receiver can refund the send, so the lock of its account also stops concurrent refunds of the send
+	if err = lockAccounts(ctx, tx, []string{alias, m.InteractionAlias}); err != nil {
+		tx.Rollback()
+		return Movement{}, err
+	}
+
+	refunded, err := r.refunded(ctx, tx, alias, id, m.CurrencyName)
+	if err != nil {
+		tx.Rollback()
+		return Movement{}, err
+	}
+
+	rest, err := received.Amount.Sub(refunded)
+	if err != nil {
+		tx.Rollback()
+		return Movement{}, err
+	}
+
+	if rest.Sign() <= 0 {
+		tx.Rollback()
+		return Movement{}, ErrorAlreadyRefunded
+	}
+
+	if m.Amount.IsZero() {
+		m.Amount = rest
+	}
+	if m.Amount.Cmp(rest) > 0 {
+		tx.Rollback()
+		return Movement{}, ErrorRefundExceedsMovement
+	}
+
+	entry := newEntry(m)
+	if err = r.validate(entry); err != nil {
+		tx.Rollback()
+		return Movement{}, err
+	}
+
+	if entry, err = r.insertEntry(ctx, tx, entry); err != nil {
+		tx.Rollback()
+		return Movement{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Movement{}, err
+	}
+
+	return saved(m, entry), nil
+}
+
+// received returns the money received by the user in a send of other user, the refunds and the sends
+// of the system accounts can not be refunded
+func (r repository) received(ctx context.Context, alias string, id int64) (Movement, error) {
+	query := "SELECT m.interaction_alias,m.currency_name,m.tx_amount FROM movements m JOIN journal_entries j ON j.id = m.entry_id " +
+		"WHERE m.entry_id = ? AND m.alias = ? AND m.mov_type = ? AND j.entry_type = ? AND j.refund_of IS NULL;"
+	var m Movement
+	var received string
+	err := r.db.QueryRowContext(ctx, query, id, alias, ReceiveMov, SendMov).Scan(&m.InteractionAlias, &m.CurrencyName, &received)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Movement{}, ErrorMovementNotFound
+		}
+		return Movement{}, err
+	}
+
+	if IsSystemAccount(m.InteractionAlias) {
+		return Movement{}, ErrorMovementNotFound
+	}
+
+	if m.Amount, err = r.bind(received, m.CurrencyName); err != nil {
+		return Movement{}, err
+	}
+
+	return m, nil
+}
+
+// refunded returns the money of the send already refunded by the user
+func (r repository) refunded(ctx context.Context, q queryer, alias string, id int64, currencyName string) (money.Amount, error) {
+	query := "SELECT COALESCE(SUM(m.tx_amount),0) FROM movements m JOIN journal_entries j ON j.id = m.entry_id " +
+		"WHERE j.refund_of = ? AND m.alias = ? AND m.mov_type = ?;"
+	var refunded string
+	if err := q.QueryRowContext(ctx, query, id, alias, SendMov).Scan(&refunded); err != nil {
+		return money.Amount{}, err
+	}
+
+	return r.bind(refunded, currencyName)
+}
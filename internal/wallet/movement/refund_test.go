@@ -0,0 +1,108 @@
+package movement
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/spolia/wallet-api/internal/wallet/money"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	receivedQuery = "SELECT m.interaction_alias,m.currency_name,m.tx_amount FROM movements m JOIN journal_entries j ON j.id = m.entry_id " +
+		"WHERE m.entry_id = ? AND m.alias = ? AND m.mov_type = ? AND j.entry_type = ? AND j.refund_of IS NULL;"
+	refundedQuery = "SELECT COALESCE(SUM(m.tx_amount),0) FROM movements m JOIN journal_entries j ON j.id = m.entry_id " +
+		"WHERE j.refund_of = ? AND m.alias = ? AND m.mov_type = ?;"
+)
+
+func TestRefund(t *testing.T) {
+	tt := []struct {
+		TestName       string
+		Amount         money.Amount
+		Refunded       string
+		ExpectedAmount string
+		ExpectedError  error
+	}{
+		{"Rest", money.Amount{}, "30.000000000000000000", "70.00", nil},
+		{"Partial", money.MustParse("25.5"), "30.000000000000000000", "25.50", nil},
+		{"MoreThanTheRest", money.MustParse("70.01"), "30.000000000000000000", "", ErrorRefundExceedsMovement},
+		{"AlreadyRefunded", money.Amount{}, "100.000000000000000000", "", ErrorAlreadyRefunded},
+	}
+
+	for _, tc := range tt {
+		// Given
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		repository := New(db, testCurrencies)
+
+		// When
+		mock.ExpectQuery(receivedQuery).WithArgs(5, "merchant", ReceiveMov, SendMov).
+			WillReturnRows(sqlmock.NewRows([]string{"interaction_alias", "currency_name", "tx_amount"}).
+				AddRow("user", ARS, "100.000000000000000000"))
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockUsersQuery).WithArgs("merchant", "user").
+			WillReturnRows(sqlmock.NewRows([]string{"alias"}).AddRow("merchant").AddRow("user"))
+		mock.ExpectQuery(refundedQuery).WithArgs(5, "merchant", SendMov).
+			WillReturnRows(sqlmock.NewRows([]string{"refunded"}).AddRow(tc.Refunded))
+		if tc.ExpectedError == nil {
+			refund := amount(tc.ExpectedAmount, ARS)
+			balance, err := amount("200", ARS).Sub(refund)
+			require.NoError(t, err)
+			mock.ExpectQuery(lockFundsQuery).WithArgs("merchant", ARS).
+				WillReturnRows(sqlmock.NewRows([]string{"total_amount"}).AddRow("200.000000000000000000"))
+			expectHeld(mock, "merchant", ARS, "0")
+			mock.ExpectQuery(lockFundsQuery).WithArgs("user", ARS).
+				WillReturnRows(sqlmock.NewRows([]string{"total_amount"}).AddRow("0.000000000000000000"))
+			mock.ExpectExec(saveEntryQuery).WithArgs(SendMov, nil, nil, 5, nil, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(6, 1))
+			mock.ExpectExec(savePostingQuery).
+				WithArgs(6, SendMov, ARS, refund, balance, "merchant", "user", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(savePostingQuery).
+				WithArgs(6, ReceiveMov, ARS, refund, refund, "user", "merchant", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(2, 1))
+			mock.ExpectCommit()
+		} else {
+			mock.ExpectRollback()
+		}
+
+		// Then
+		refund, err := repository.Refund(context.Background(), "merchant", 5, tc.Amount)
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		require.NoError(t, mock.ExpectationsWereMet(), tc.TestName)
+		if tc.ExpectedError == nil {
+			require.Equal(t, int64(6), refund.ID, tc.TestName)
+			require.Equal(t, int64(5), refund.RefundOf, tc.TestName)
+			require.Equal(t, tc.ExpectedAmount, refund.Amount.String(), tc.TestName)
+		}
+		db.Close()
+	}
+}
+
+func TestRefund_When_MovementIsNotAReceivedSend_Then_ReturnsNotFound(t *testing.T) {
+	tt := []struct {
+		TestName string
+		Rows     *sqlmock.Rows
+	}{
+		// a send of the user, a refund or a movement of other user
+		{"NotReceived", sqlmock.NewRows([]string{"interaction_alias", "currency_name", "tx_amount"})},
+		{"FromSystemAccount", sqlmock.NewRows([]string{"interaction_alias", "currency_name", "tx_amount"}).
+			AddRow(WithdrawalsAccount, ARS, "100.000000000000000000")},
+	}
+
+	for _, tc := range tt {
+		// Given
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		repository := New(db, testCurrencies)
+
+		// When
+		mock.ExpectQuery(receivedQuery).WithArgs(5, "user", ReceiveMov, SendMov).WillReturnRows(tc.Rows)
+
+		// Then
+		_, err = repository.Refund(context.Background(), "user", 5, money.Amount{})
+		require.Equal(t, ErrorMovementNotFound, err, tc.TestName)
+		require.NoError(t, mock.ExpectationsWereMet(), tc.TestName)
+		db.Close()
+	}
+}
@@ -4,69 +4,363 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/spolia/wallet-api/internal/wallet/money"
+)
+
+const (
+	// mysqlOutOfRange is returned when a value does not fit in the column, like a negative unsigned total amount
+	mysqlOutOfRange = 1264
+	// mysqlCheckViolated is returned when a row breaks a CHECK constraint
+	mysqlCheckViolated = 3819
+	// mysqlDuplicateEntry is returned when a row breaks a unique index
+	mysqlDuplicateEntry = 1062
 )
 
 type repository struct {
-	db *sql.DB
+	db         *sql.DB
+	currencies *Registry
 }
 
-func New(db *sql.DB) *repository {
-	return &repository{db: db}
+func New(db *sql.DB, currencies *Registry) *repository {
+	return &repository{db: db, currencies: currencies}
 }
 
-// Save inserts a new movement in the user account
-func (r repository) Save(ctx context.Context, movement Movement) error {
-	var table string
-	if table = getCurrencyTable(movement.CurrencyName); table == "" {
-		return ErrorWrongCurrency
+// LoadRegistry reads the currencies table
+func LoadRegistry(ctx context.Context, db *sql.DB) (*Registry, error) {
+	rows, err := db.QueryContext(ctx, "SELECT code,name,digits,enabled FROM currencies ORDER BY code;")
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	query := fmt.Sprintf("INSERT INTO %s(mov_type,currency_name,tx_amount,alias,interaction_alias)VALUES (?,?,?,?,?);", table)
+	var currencies []Currency
+	for rows.Next() {
+		var c Currency
+		if err = rows.Scan(&c.Code, &c.Name, &c.Digits, &c.Enabled); err != nil {
+			return nil, err
+		}
 
-	tx, err := r.db.Begin()
-	// sender
-	_, err = tx.ExecContext(ctx, query, movement.Type, movement.CurrencyName, movement.Amount, movement.Alias, movement.InteractionAlias)
+		currencies = append(currencies, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewRegistry(currencies)
+}
+
+// Save records the movement as a journal entry in the user account and returns it with the entry id and
+// the new balance of the user in the movement currency.
+// Every account of the entry is locked until the transaction ends, so the funds checked for a debit can not be
+// spent by a concurrent movement before the new rows are inserted
+func (r repository) Save(ctx context.Context, movement Movement) (Movement, error) {
+	currency, ok := r.currencies.Get(movement.CurrencyName)
+	if !ok || !currency.Enabled {
+		return Movement{}, ErrorWrongCurrency
+	}
+
+	if movement.Type == ExchangeMov && movement.Conversion == nil {
+		return Movement{}, ErrorUnbalancedEntry
+	}
+
+	entry, err := r.saveEntry(ctx, newEntry(movement))
+	if err != nil {
+		return Movement{}, err
+	}
+
+	return saved(movement, entry), nil
+}
+
+// saved returns the movement with the id of its entry and the balance after the last posting of the user,
+// the fee is posted after the amount
+func saved(movement Movement, entry Entry) Movement {
+	movement.ID = entry.ID
+	movement.Status = StatusCompleted
+	movement.DateCreated = entry.DateCreated
+	for _, p := range entry.Postings {
+		if p.Alias == movement.Alias && p.Amount.Currency() == movement.CurrencyName {
+			movement.TotalAmount = p.TotalAmount
+		}
+	}
+
+	return movement
+}
+
+// saveEntry inserts the entry and its postings, the entry is rejected if it is unbalanced
+// or if it debits more than the funds of a user account
+func (r repository) saveEntry(ctx context.Context, entry Entry) (Entry, error) {
+	if err := r.validate(entry); err != nil {
+		return Entry{}, err
+	}
+
+	// read committed makes every read see the rows committed by the transactions that held the locks before
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
 	if err != nil {
+		return Entry{}, err
+	}
+
+	if err = lockAccounts(ctx, tx, entry.aliases()); err != nil {
 		tx.Rollback()
+		return Entry{}, err
+	}
+
+	if entry, err = r.insertEntry(ctx, tx, entry); err != nil {
+		tx.Rollback()
+		return Entry{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Entry{}, err
+	}
+
+	return entry, nil
+}
+
+// validate returns an error if the entry is unbalanced or uses a disabled currency
+func (r repository) validate(entry Entry) error {
+	if err := entry.Validate(); err != nil {
 		return err
 	}
 
-	if movement.Alias != movement.InteractionAlias {
-		// destiny
-		_, err = tx.ExecContext(ctx, query, ReceiveMov, movement.CurrencyName, movement.Amount, movement.InteractionAlias, movement.Alias)
+	for _, p := range entry.Postings {
+		if currency, ok := r.currencies.Get(p.Amount.Currency()); !ok || !currency.Enabled {
+			return ErrorWrongCurrency
+		}
+	}
+
+	return nil
+}
+
+// insertEntry inserts the entry and its postings with the running balances, the accounts of the entry
+// have to be locked by the transaction
+func (r repository) insertEntry(ctx context.Context, tx *sql.Tx, entry Entry) (Entry, error) {
+	totals, err := r.runningTotals(ctx, tx, entry)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	var rate, spread, refundOf, reference interface{}
+	if entry.Conversion != nil {
+		rate, spread = entry.Conversion.Rate, entry.Conversion.Spread
+	}
+	if entry.RefundOf != 0 {
+		refundOf = entry.RefundOf
+	}
+	if entry.Reference != "" {
+		reference = entry.Reference
+	}
+
+	// the dates are saved without fractional seconds, so the saved movement has the same date as its rows
+	entry.DateCreated = time.Now().Truncate(time.Second)
+	result, err := tx.ExecContext(ctx, "INSERT INTO journal_entries(entry_type,rate,spread,refund_of,reference,date_created)"+
+		"VALUES (?,?,?,?,?,?);", entry.Type, rate, spread, refundOf, reference, entry.DateCreated)
+	if err != nil {
+		// the reference is the only unique column of the entries
+		if v, ok := err.(*mysql.MySQLError); ok && v.Number == mysqlDuplicateEntry {
+			return Entry{}, ErrorAlreadySaved
+		}
+
+		return Entry{}, err
+	}
+
+	if entry.ID, err = result.LastInsertId(); err != nil {
+		return Entry{}, err
+	}
+
+	query := "INSERT INTO movements(entry_id,mov_type,currency_name,tx_amount,total_amount,alias,interaction_alias,date_created)" +
+		"VALUES (?,?,?,?,?,?,?,?);"
+	for i, p := range entry.Postings {
+		amount := p.Amount
+		if amount.Sign() < 0 {
+			amount = amount.Neg()
+		}
+
+		_, err = tx.ExecContext(ctx, query, entry.ID, p.Type, amount.Currency(), amount, totals[i], p.Alias, p.InteractionAlias,
+			entry.DateCreated)
 		if err != nil {
-			tx.Rollback()
-			return err
+			return Entry{}, saveError(err)
 		}
+
+		entry.Postings[i].TotalAmount = totals[i]
 	}
 
-	if err = tx.Commit(); err != nil {
+	return entry, nil
+}
+
+// runningTotals returns the balance of the account after every posting of the entry, the balances are read
+// while the accounts are locked. Returns ErrorInsufficientFunds if a debit takes a user account below
+// the money held by its active holds.
+// The system accounts take part in the movements of every user, so they are not locked and have no running
+// balance: their total is zero and their balance is the sum of their postings
+func (r repository) runningTotals(ctx context.Context, tx *sql.Tx, entry Entry) ([]money.Amount, error) {
+	balances := make(map[string]money.Amount)
+	held := make(map[string]money.Amount)
+	totals := make([]money.Amount, 0, len(entry.Postings))
+	for _, p := range entry.Postings {
+		key := p.Alias + " " + p.Amount.Currency()
+		currency, _ := r.currencies.Get(p.Amount.Currency())
+		if IsSystemAccount(p.Alias) {
+			totals = append(totals, money.Zero(currency.Code, currency.Digits))
+			continue
+		}
+
+		balance, ok := balances[key]
+		if !ok {
+			var err error
+			if balance, err = lockFunds(ctx, tx, currency, p.Alias); err != nil {
+				return nil, err
+			}
+		}
+
+		balance, err := balance.Add(p.Amount)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.Amount.Sign() < 0 {
+			floor, ok := held[key]
+			if !ok {
+				var err error
+				if floor, err = r.heldFunds(ctx, tx, currency, p.Alias); err != nil {
+					return nil, err
+				}
+				held[key] = floor
+			}
+
+			if balance.Cmp(floor) < 0 {
+				return nil, ErrorInsufficientFunds
+			}
+		}
+
+		balances[key] = balance
+		totals = append(totals, balance)
+	}
+
+	return totals, nil
+}
+
+// saveError translates the database guard against negative balances into ErrorInsufficientFunds
+func saveError(err error) error {
+	if v, ok := err.(*mysql.MySQLError); ok {
+		if v.Number == mysqlCheckViolated || v.Number == mysqlOutOfRange {
+			return ErrorInsufficientFunds
+		}
+	}
+
+	return err
+}
+
+// lockAccounts locks the users rows until the transaction ends, the rows are locked in alias order
+// so two transfers between the same users in opposite directions can not deadlock.
+// The closed accounts are not found, so no money moves from or to them once they are closed.
+// The system accounts are not locked, otherwise every deposit, fee or exchange of every user would wait for them
+func lockAccounts(ctx context.Context, tx *sql.Tx, aliases []string) error {
+	args := make([]interface{}, 0, len(aliases))
+	for _, alias := range aliases {
+		if !IsSystemAccount(alias) {
+			args = append(args, alias)
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	query := "SELECT alias FROM users WHERE alias IN (?" + strings.Repeat(",?", len(args)-1) + ") AND closed_at IS NULL " +
+		"ORDER BY alias FOR UPDATE;"
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
 		return err
 	}
+	defer rows.Close()
+
+	var locked int
+	for rows.Next() {
+		locked++
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if locked != len(args) {
+		return ErrorAccountClosed
+	}
 
 	return nil
 }
 
-// GetFunds returns the user funds for a currency
-func (r repository) GetFunds(ctx context.Context, currencyName, alias string) (float64, error) {
-	var table string
-	if table = getCurrencyTable(currencyName); table == "" {
-		return 0, ErrorWrongCurrency
+// lockFunds returns the balance of the account for a currency locking the last movement of the account
+func lockFunds(ctx context.Context, tx *sql.Tx, currency Currency, alias string) (money.Amount, error) {
+	query := "SELECT total_amount FROM movements WHERE alias = ? AND currency_name = ? ORDER BY id DESC LIMIT 1 FOR UPDATE;"
+	totalAmount := money.Zero(currency.Code, currency.Digits)
+	if err := tx.QueryRowContext(ctx, query, alias, currency.Code).Scan(&totalAmount); err != nil {
+		if err == sql.ErrNoRows {
+			// the account was never initialized
+			return money.Zero(currency.Code, currency.Digits), nil
+		}
+		return money.Amount{}, err
+	}
+
+	return totalAmount, nil
+}
+
+// GetFunds returns the available user funds for a currency, the balance minus the money held by the active holds
+func (r repository) GetFunds(ctx context.Context, currencyName, alias string) (money.Amount, error) {
+	currency, ok := r.currencies.Get(currencyName)
+	if !ok {
+		return money.Amount{}, ErrorWrongCurrency
+	}
+
+	balance, err := r.getTotalAmount(ctx, currency, alias)
+	if err != nil {
+		return money.Amount{}, err
+	}
+
+	held, err := r.heldFunds(ctx, r.db, currency, alias)
+	if err != nil {
+		return money.Amount{}, err
 	}
 
-	query := fmt.Sprintf("SELECT total_amount FROM %s WHERE id = (SELECT MAX(id) FROM %s WHERE alias = ?);", table, table)
-	row := r.db.QueryRowContext(ctx, query, alias)
+	return balance.Sub(held)
+}
+
+// getTotalAmount returns the total amount of the last movement of the account,
+// accounts without movements are the ones of a currency added after the user was created
+func (r repository) getTotalAmount(ctx context.Context, currency Currency, alias string) (money.Amount, error) {
+	query := "SELECT total_amount FROM movements WHERE id = (SELECT MAX(id) FROM movements WHERE alias = ? AND currency_name = ?);"
+	row := r.db.QueryRowContext(ctx, query, alias, currency.Code)
 	var queryResult struct {
-		TotalAmount float64
+		TotalAmount money.Amount
 	}
 
+	queryResult.TotalAmount = money.Zero(currency.Code, currency.Digits)
 	if err := row.Scan(&queryResult.TotalAmount); err != nil {
-		return 0, err
+		if err == sql.ErrNoRows {
+			return money.Zero(currency.Code, currency.Digits), nil
+		}
+		return money.Amount{}, err
 	}
 
 	return queryResult.TotalAmount, nil
 }
 
+// CountMovements returns the number of movements of the type of the user in the currency since the given time,
+// the refunds are sends without fee and are not counted
+func (r repository) CountMovements(ctx context.Context, alias, movType, currencyName string, since time.Time) (int, error) {
+	query := "SELECT COUNT(*) FROM movements m LEFT JOIN journal_entries j ON j.id = m.entry_id WHERE m.alias = ? " +
+		"AND m.mov_type = ? AND m.currency_name = ? AND m.date_created >= ? AND j.refund_of IS NULL;"
+	var count int
+	if err := r.db.QueryRowContext(ctx, query, alias, movType, currencyName, since).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
 // InitSave saves initials account movements for a new user
 func (r repository) InitSave(ctx context.Context, movement Movement) error {
 	tx, err := r.db.Begin()
@@ -74,9 +368,9 @@ func (r repository) InitSave(ctx context.Context, movement Movement) error {
 		return err
 	}
 
-	for _, v := range movementTables {
-		query := fmt.Sprintf("INSERT INTO %s(mov_type,tx_amount,total_amount,alias,interaction_alias)VALUES (?,?,?,?,?);", v)
-		if _, err = tx.ExecContext(ctx, query, movement.Type, movement.Amount, movement.TotalAmount, movement.Alias, movement.InteractionAlias); err != nil {
+	query := "INSERT INTO movements(mov_type,currency_name,tx_amount,total_amount,alias,interaction_alias)VALUES (?,?,?,?,?,?);"
+	for _, c := range r.currencies.All() {
+		if _, err = tx.ExecContext(ctx, query, movement.Type, c.Code, movement.Amount, movement.TotalAmount, movement.Alias, movement.InteractionAlias); err != nil {
 			tx.Rollback()
 			return err
 		}
@@ -89,49 +383,204 @@ func (r repository) InitSave(ctx context.Context, movement Movement) error {
 	return nil
 }
 
-// GetAccountExtract given an alias returns the funds for all user currencies
+// GetAccountExtract given an alias returns the ledger and the available funds for all user currencies
 func (r repository) GetAccountExtract(ctx context.Context, alias string) (AccountBalance, error) {
+	// accounts without movements are the ones of a currency added after the user was created
 	var accountBalance = make(AccountBalance, 0)
-	for k, v := range movementTables {
-		var queryResult struct {
-			TotalAmount float64
+	for _, c := range r.currencies.All() {
+		zero := money.Zero(c.Code, c.Digits)
+		accountBalance[c.Code] = Balance{Ledger: zero, Available: zero}
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT m.currency_name,m.total_amount FROM movements m "+
+		"JOIN (SELECT MAX(id) AS id FROM movements WHERE alias = ? GROUP BY currency_name) l ON m.id = l.id;", alias)
+	if err != nil {
+		return AccountBalance{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var currencyName, totalAmount string
+		if err = rows.Scan(&currencyName, &totalAmount); err != nil {
+			return AccountBalance{}, err
 		}
 
-		row := r.db.QueryRowContext(ctx, fmt.Sprintf("SELECT total_amount FROM %s WHERE id = (SELECT MAX(id) "+
-			"FROM %s WHERE alias = ?)", v, v), alias)
-		if err := row.Scan(&queryResult.TotalAmount); err != nil {
+		ledger, err := r.bind(totalAmount, currencyName)
+		if err != nil {
 			return AccountBalance{}, err
 		}
+		accountBalance[currencyName] = Balance{Ledger: ledger, Available: ledger}
+	}
+
+	if err = rows.Err(); err != nil {
+		return AccountBalance{}, err
+	}
 
-		accountBalance[k] = queryResult.TotalAmount
+	if err = r.subtractHolds(ctx, alias, accountBalance); err != nil {
+		return AccountBalance{}, err
 	}
 
 	return accountBalance, nil
 }
 
-// GetHistory returns the account history for all the user currencies
-func (r repository) GetHistory(ctx context.Context, alias string) (AccountHistory, error) {
-	var history = make(AccountHistory, 0)
-	for k, v := range movementTables {
-		rows, err := r.db.QueryContext(ctx, fmt.Sprintf("SELECT mov_type,date_created,tx_amount,total_amount,interaction_alias "+
-			"FROM %s WHERE alias = ?", v), alias)
+// GetAccountExtractAt returns the ledger balance of every user currency as of the given time, with the
+// boundary of GetBalanceAt
+func (r repository) GetAccountExtractAt(ctx context.Context, alias string, at time.Time) (AccountBalanceAt, error) {
+	var accountBalance = make(AccountBalanceAt, 0)
+	for _, c := range r.currencies.All() {
+		ledger, err := r.GetBalanceAt(ctx, alias, c.Code, at)
 		if err != nil {
-			return AccountHistory{}, err
+			return AccountBalanceAt{}, err
+		}
+		accountBalance[c.Code] = LedgerBalance{Ledger: ledger}
+	}
+
+	return accountBalance, nil
+}
+
+// subtractHolds subtracts the money held by the active holds, their amounts and fees, from the available balances
+func (r repository) subtractHolds(ctx context.Context, alias string, accountBalance AccountBalance) error {
+	rows, err := r.db.QueryContext(ctx, "SELECT currency_name,SUM(amount+fee) FROM holds "+
+		"WHERE alias = ? AND status = ? AND expires_at > ? GROUP BY currency_name;", alias, HoldActive, time.Now())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var currencyName, amount string
+		if err = rows.Scan(&currencyName, &amount); err != nil {
+			return err
 		}
 
-		var mov []Row
-		for rows.Next() {
-			var r Row
-			err = rows.Scan(&r.Type, &r.DateCreated, &r.Amount, &r.TotalAmount, &r.InteractionAlias)
-			if err != nil {
-				return AccountHistory{}, err
+		held, err := r.bind(amount, currencyName)
+		if err != nil {
+			return err
+		}
+
+		balance := accountBalance[currencyName]
+		if balance.Available, err = balance.Available.Sub(held); err != nil {
+			return err
+		}
+
+		accountBalance[currencyName] = balance
+	}
+
+	return rows.Err()
+}
+
+// GetMovement returns the movement as seen by one of its users, the movements of other users are not found
+func (r repository) GetMovement(ctx context.Context, alias string, id int64) (Movement, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT j.entry_type,j.rate,j.spread,j.refund_of,j.date_created,m.alias,m.mov_type,"+
+		"m.currency_name,m.tx_amount,m.total_amount,m.interaction_alias FROM journal_entries j JOIN movements m ON m.entry_id = j.id "+
+		"WHERE j.id = ? ORDER BY m.id;", id)
+	if err != nil {
+		return Movement{}, err
+	}
+	defer rows.Close()
+
+	var entry Entry
+	var rate, spread sql.NullString
+	var refundOf sql.NullInt64
+	for rows.Next() {
+		var p Posting
+		var currencyName, amount, totalAmount string
+		err = rows.Scan(&entry.Type, &rate, &spread, &refundOf, &entry.DateCreated, &p.Alias, &p.Type, &currencyName, &amount,
+			&totalAmount, &p.InteractionAlias)
+		if err != nil {
+			return Movement{}, err
+		}
+
+		if p.Amount, err = r.bind(amount, currencyName); err != nil {
+			return Movement{}, err
+		}
+		if p.TotalAmount, err = r.bind(totalAmount, currencyName); err != nil {
+			return Movement{}, err
+		}
+
+		entry.Postings = append(entry.Postings, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return Movement{}, err
+	}
+
+	m, ok := entry.movement(alias)
+	if !ok {
+		return Movement{}, ErrorMovementNotFound
+	}
+	m.ID = id
+	m.RefundOf = refundOf.Int64
+
+	if rate.Valid {
+		// the exchange account pays the destination currency
+		for _, p := range entry.Postings {
+			if p.Alias == ExchangeAccount && p.Type == ExchangeOutMov {
+				m.Conversion = &Conversion{DestinationAmount: p.Amount, DestinationCurrency: p.Amount.Currency()}
 			}
+		}
+		if m.Conversion == nil {
+			return Movement{}, fmt.Errorf("movement: entry %d has a rate but no exchange", id)
+		}
 
-			mov = append(mov, r)
+		// the stored rate has trailing zeros that do not fit in an amount
+		if err = m.Conversion.Rate.Scan(rate.String); err != nil {
+			return Movement{}, err
 		}
+		if m.Conversion.Spread, err = r.bind(spread.String, m.Conversion.DestinationCurrency); err != nil {
+			return Movement{}, err
+		}
+	}
+
+	if m.Status, err = r.refundStatus(ctx, entry, id); err != nil {
+		return Movement{}, err
+	}
+
+	return m, nil
+}
+
+// refundStatus returns if a send was refunded by its receiver, the first posting of a send is the one of the sender
+func (r repository) refundStatus(ctx context.Context, entry Entry, id int64) (string, error) {
+	if entry.Type != SendMov || len(entry.Postings) == 0 {
+		return StatusCompleted, nil
+	}
+
+	sender, receiver := entry.Postings[0].Alias, entry.Postings[0].InteractionAlias
+	for _, p := range entry.Postings {
+		if p.Alias != receiver || p.Type != ReceiveMov || p.InteractionAlias != sender {
+			continue
+		}
+
+		refunded, err := r.refunded(ctx, r.db, receiver, id, p.Amount.Currency())
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case refunded.IsZero():
+			return StatusCompleted, nil
+		case refunded.Cmp(p.Amount) < 0:
+			return StatusPartiallyRefunded, nil
+		default:
+			return StatusRefunded, nil
+		}
+	}
+
+	return StatusCompleted, nil
+}
+
+// bind reads a stored amount with the decimals of its currency, the movements table keeps the maximum
+// number of decimals of any currency
+func (r repository) bind(value, currencyName string) (money.Amount, error) {
+	currency, ok := r.currencies.Get(currencyName)
+	if !ok {
+		return money.Amount{}, fmt.Errorf("movement: unknown currency %q in the movements table", currencyName)
+	}
 
-		history[k] = mov
+	amount := money.Zero(currency.Code, currency.Digits)
+	if err := amount.Scan(value); err != nil {
+		return money.Amount{}, err
 	}
 
-	return history, nil
+	return amount, nil
 }
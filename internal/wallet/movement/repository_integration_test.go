@@ -0,0 +1,135 @@
+package movement
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// openTestDB connects to the database in WALLET_TEST_DSN, the database must have the migrations applied.
+// The test is skipped when the variable is not set, e.g.:
+//
+//	WALLET_TEST_DSN="tester:secret@tcp(localhost:13306)/test?parseTime=True" go test ./...
+func openTestDB(t *testing.T) *sql.DB {
+	dsn := os.Getenv("WALLET_TEST_DSN")
+	if dsn == "" {
+		t.Skip("WALLET_TEST_DSN is not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func createTestUsers(t *testing.T, db *sql.DB, repository *repository, aliases ...string) {
+	ctx := context.Background()
+	for _, alias := range aliases {
+		_, err := db.ExecContext(ctx, "INSERT INTO users(alias,first_name,last_name,email,password) VALUES(?,?,?,?,?);",
+			alias, "name", "lastname", alias+"@test.com", "-")
+		require.NoError(t, err)
+		require.NoError(t, repository.InitSave(ctx, Movement{Type: "init", Alias: alias, InteractionAlias: alias}))
+
+		alias := alias
+		t.Cleanup(func() { deleteTestUser(t, db, alias) })
+	}
+}
+
+// deleteTestUser deletes the user with its entries, the foreign keys do not delete the rows of a user
+func deleteTestUser(t *testing.T, db *sql.DB, alias string) {
+	ctx := context.Background()
+	// the refunds are deleted before the entries they refund
+	rows, err := db.QueryContext(ctx, "SELECT DISTINCT entry_id FROM movements WHERE alias = ? AND entry_id IS NOT NULL "+
+		"ORDER BY entry_id DESC;", alias)
+	require.NoError(t, err)
+	var entries []int64
+	for rows.Next() {
+		var id int64
+		require.NoError(t, rows.Scan(&id))
+		entries = append(entries, id)
+	}
+	require.NoError(t, rows.Err())
+	require.NoError(t, rows.Close())
+
+	_, err = db.ExecContext(ctx, "DELETE FROM withdrawals WHERE alias = ?;", alias)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, "DELETE FROM holds WHERE alias = ? OR interaction_alias = ?;", alias, alias)
+	require.NoError(t, err)
+	for _, id := range entries {
+		_, err = db.ExecContext(ctx, "DELETE FROM movements WHERE entry_id = ?;", id)
+		require.NoError(t, err)
+		_, err = db.ExecContext(ctx, "DELETE FROM journal_entries WHERE id = ?;", id)
+		require.NoError(t, err)
+	}
+
+	_, err = db.ExecContext(ctx, "DELETE FROM movements WHERE alias = ?;", alias)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, "DELETE FROM users WHERE alias = ?;", alias)
+	require.NoError(t, err)
+}
+
+func TestSaveMovement_ConcurrentSends(t *testing.T) {
+	// Given
+	db := openTestDB(t)
+	ctx := context.Background()
+	currencies, err := LoadRegistry(ctx, db)
+	require.NoError(t, err)
+	repository := New(db, currencies)
+	suffix := time.Now().UnixNano()
+	sender, receiver := fmt.Sprintf("sender%d", suffix), fmt.Sprintf("receiver%d", suffix)
+	createTestUsers(t, db, repository, sender, receiver)
+	_, err = repository.Save(ctx, Movement{Type: DepositMov, Amount: amount("100", ARS), CurrencyName: ARS, Alias: sender, InteractionAlias: sender})
+	require.NoError(t, err)
+
+	// When
+	const sends = 300
+	var wg sync.WaitGroup
+	errs := make(chan error, sends*2)
+	for i := 0; i < sends; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := repository.Save(ctx, Movement{Type: SendMov, Amount: amount("1", ARS), CurrencyName: ARS, Alias: sender, InteractionAlias: receiver})
+			errs <- err
+		}()
+		// sends in the opposite direction lock the same accounts in reverse order
+		go func() {
+			defer wg.Done()
+			_, err := repository.Save(ctx, Movement{Type: SendMov, Amount: amount("1", ARS), CurrencyName: ARS, Alias: receiver, InteractionAlias: sender})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	// Then
+	for err := range errs {
+		if err != nil {
+			require.Equal(t, ErrorInsufficientFunds, err)
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT total_amount FROM movements WHERE alias IN (?,?) AND currency_name = ?;", sender, receiver, ARS)
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		totalAmount := testCurrencies.Zero(ARS)
+		require.NoError(t, rows.Scan(&totalAmount))
+		require.GreaterOrEqual(t, totalAmount.Sign(), 0)
+	}
+
+	balanceSender, err := repository.GetFunds(ctx, ARS, sender)
+	require.NoError(t, err)
+	balanceReceiver, err := repository.GetFunds(ctx, ARS, receiver)
+	require.NoError(t, err)
+	total, err := balanceSender.Add(balanceReceiver)
+	require.NoError(t, err)
+	require.Equal(t, amount("100", ARS), total)
+}
@@ -2,24 +2,65 @@ package movement
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/go-sql-driver/mysql"
+	"github.com/spolia/wallet-api/internal/wallet/money"
 	"github.com/stretchr/testify/require"
 )
 
+var testCurrencies = func() *Registry {
+	registry, err := NewRegistry([]Currency{
+		{Code: ARS, Name: "Peso", Digits: 2, Enabled: true},
+		{Code: BTC, Name: "Bitcoin", Digits: 8, Enabled: true},
+		{Code: USDT, Name: "Tether", Digits: 2, Enabled: true},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return registry
+}()
+
+func amount(value, currency string) money.Amount {
+	a, err := testCurrencies.Amount(money.MustParse(value), currency)
+	if err != nil {
+		panic(err)
+	}
+
+	return a
+}
+
+const (
+	lockUsersQuery   = "SELECT alias FROM users WHERE alias IN (?,?) AND closed_at IS NULL ORDER BY alias FOR UPDATE;"
+	lockUserQuery    = "SELECT alias FROM users WHERE alias IN (?) AND closed_at IS NULL ORDER BY alias FOR UPDATE;"
+	lockFundsQuery   = "SELECT total_amount FROM movements WHERE alias = ? AND currency_name = ? ORDER BY id DESC LIMIT 1 FOR UPDATE;"
+	saveEntryQuery   = "INSERT INTO journal_entries(entry_type,rate,spread,refund_of,reference,date_created)VALUES (?,?,?,?,?,?);"
+	savePostingQuery = "INSERT INTO movements(entry_id,mov_type,currency_name,tx_amount,total_amount,alias,interaction_alias,date_created)" +
+		"VALUES (?,?,?,?,?,?,?,?);"
+	heldFundsQuery = "SELECT COALESCE(SUM(amount+fee),0) FROM holds WHERE alias = ? AND currency_name = ? AND status = ? AND expires_at > ?;"
+)
+
+// expectHeld expects the query of the money held by the active holds of the account
+func expectHeld(mock sqlmock.Sqlmock, alias, currency, held string) {
+	mock.ExpectQuery(heldFundsQuery).WithArgs(alias, currency, HoldActive, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"held"}).AddRow(held))
+}
+
 func TestSaveMovement_ok(t *testing.T) {
 	// Given
 	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if err != nil {
 		require.NoError(t, err)
 	}
-	repository := New(db)
+	repository := New(db, testCurrencies)
 	defer db.Close()
 	movement := Movement{
 		Type:             "send",
-		Amount:           100.2,
+		Amount:           amount("100.2", USDT),
 		CurrencyName:     USDT,
 		Alias:            "user",
 		InteractionAlias: "otheruser",
@@ -27,14 +68,177 @@ func TestSaveMovement_ok(t *testing.T) {
 
 	// When
 	mock.ExpectBegin()
-	query1 := "INSERT INTO movements_usdt(mov_type,currency_name,tx_amount,alias,interaction_alias)VALUES (?,?,?,?,?);"
-	mock.ExpectExec(query1).WithArgs(movement.Type, movement.CurrencyName, movement.Amount, movement.Alias, movement.InteractionAlias).WillReturnResult(sqlmock.NewResult(1, 1))
-	query2 := "INSERT INTO movements_usdt(mov_type,currency_name,tx_amount,alias,interaction_alias)VALUES (?,?,?,?,?);"
-	mock.ExpectExec(query2).WithArgs("receive", movement.CurrencyName, movement.Amount, movement.InteractionAlias, movement.Alias).WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectQuery(lockUsersQuery).WithArgs(movement.InteractionAlias, movement.Alias).
+		WillReturnRows(sqlmock.NewRows([]string{"alias"}).AddRow("otheruser").AddRow("user"))
+	mock.ExpectQuery(lockFundsQuery).WithArgs(movement.Alias, movement.CurrencyName).
+		WillReturnRows(sqlmock.NewRows([]string{"total_amount"}).AddRow("150.200000000000000000"))
+	expectHeld(mock, movement.Alias, movement.CurrencyName, "0.000000000000000000")
+	mock.ExpectQuery(lockFundsQuery).WithArgs(movement.InteractionAlias, movement.CurrencyName).
+		WillReturnRows(sqlmock.NewRows([]string{"total_amount"}).AddRow("5.000000000000000000"))
+	mock.ExpectExec(saveEntryQuery).WithArgs(movement.Type, nil, nil, nil, nil, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectExec(savePostingQuery).
+		WithArgs(7, movement.Type, movement.CurrencyName, movement.Amount, amount("50", USDT), movement.Alias, movement.InteractionAlias, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(savePostingQuery).
+		WithArgs(7, "receive", movement.CurrencyName, movement.Amount, amount("105.2", USDT), movement.InteractionAlias, movement.Alias, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
 	mock.ExpectCommit()
 	// then
-	err = repository.Save(context.Background(), movement)
+	saved, err := repository.Save(context.Background(), movement)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), saved.ID)
+	require.Equal(t, amount("50", USDT), saved.TotalAmount)
+	require.Equal(t, StatusCompleted, saved.Status)
+	require.False(t, saved.DateCreated.IsZero())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveMovement_When_AccountIsClosed_Then_ReturnsError(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, testCurrencies)
+	defer db.Close()
+	movement := Movement{
+		Type:             "send",
+		Amount:           amount("100.2", USDT),
+		CurrencyName:     USDT,
+		Alias:            "user",
+		InteractionAlias: "otheruser",
+	}
+
+	// When
+	mock.ExpectBegin()
+	// the receiver closed the account after the send was checked
+	mock.ExpectQuery(lockUsersQuery).WithArgs(movement.InteractionAlias, movement.Alias).
+		WillReturnRows(sqlmock.NewRows([]string{"alias"}).AddRow("user"))
+	mock.ExpectRollback()
+
+	// Then
+	_, err = repository.Save(context.Background(), movement)
+	require.Equal(t, ErrorAccountClosed, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveMovement_Conversion(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, testCurrencies)
+	defer db.Close()
+	conversion := &Conversion{
+		DestinationAmount:   amount("3467.47", ARS),
+		DestinationCurrency: ARS,
+		Rate:                money.MustParse("346.7475"),
+		Spread:              amount("35.03", ARS),
+	}
+	movement := Movement{
+		Type:             SendMov,
+		Amount:           amount("10", USDT),
+		CurrencyName:     USDT,
+		Alias:            "user",
+		InteractionAlias: "otheruser",
+		Conversion:       conversion,
+	}
+
+	// When
+	mock.ExpectBegin()
+	// the exchange account is not locked
+	mock.ExpectQuery(lockUsersQuery).WithArgs(movement.InteractionAlias, movement.Alias).
+		WillReturnRows(sqlmock.NewRows([]string{"alias"}).AddRow("otheruser").AddRow("user"))
+	mock.ExpectQuery(lockFundsQuery).WithArgs(movement.Alias, USDT).
+		WillReturnRows(sqlmock.NewRows([]string{"total_amount"}).AddRow("10.000000000000000000"))
+	expectHeld(mock, movement.Alias, USDT, "0.000000000000000000")
+	mock.ExpectQuery(lockFundsQuery).WithArgs(movement.InteractionAlias, ARS).
+		WillReturnRows(sqlmock.NewRows([]string{"total_amount"}).AddRow("0.000000000000000000"))
+	mock.ExpectExec(saveEntryQuery).WithArgs(SendMov, conversion.Rate, conversion.Spread, nil, nil, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(4, 1))
+	mock.ExpectExec(savePostingQuery).
+		WithArgs(4, SendMov, USDT, movement.Amount, amount("0", USDT), movement.Alias, movement.InteractionAlias, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(savePostingQuery).
+		WithArgs(4, ExchangeInMov, USDT, movement.Amount, amount("0", USDT), ExchangeAccount, movement.Alias, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec(savePostingQuery).
+		WithArgs(4, ExchangeOutMov, ARS, conversion.DestinationAmount, amount("0", ARS), ExchangeAccount, movement.InteractionAlias, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectExec(savePostingQuery).
+		WithArgs(4, ReceiveMov, ARS, conversion.DestinationAmount, amount("3467.47", ARS), movement.InteractionAlias, movement.Alias, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	mock.ExpectCommit()
+
+	// Then
+	saved, err := repository.Save(context.Background(), movement)
 	require.NoError(t, err)
+	require.Equal(t, int64(4), saved.ID)
+	require.Equal(t, amount("0", USDT), saved.TotalAmount)
+	require.Equal(t, conversion, saved.Conversion)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveMovement_Fee(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, testCurrencies)
+	defer db.Close()
+	movement := Movement{
+		Type:             SendMov,
+		Amount:           amount("100", ARS),
+		CurrencyName:     ARS,
+		Alias:            "user",
+		InteractionAlias: "otheruser",
+		Fee:              amount("1.5", ARS),
+	}
+
+	// When
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockUsersQuery).WithArgs(movement.InteractionAlias, movement.Alias).
+		WillReturnRows(sqlmock.NewRows([]string{"alias"}).AddRow("otheruser").AddRow("user"))
+	mock.ExpectQuery(lockFundsQuery).WithArgs(movement.Alias, ARS).
+		WillReturnRows(sqlmock.NewRows([]string{"total_amount"}).AddRow("101.500000000000000000"))
+	expectHeld(mock, movement.Alias, ARS, "0.000000000000000000")
+	mock.ExpectQuery(lockFundsQuery).WithArgs(movement.InteractionAlias, ARS).
+		WillReturnRows(sqlmock.NewRows([]string{"total_amount"}).AddRow("0.000000000000000000"))
+	mock.ExpectExec(saveEntryQuery).WithArgs(SendMov, nil, nil, nil, nil, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectExec(savePostingQuery).
+		WithArgs(5, SendMov, ARS, movement.Amount, amount("1.5", ARS), movement.Alias, movement.InteractionAlias, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(savePostingQuery).
+		WithArgs(5, ReceiveMov, ARS, movement.Amount, amount("100", ARS), movement.InteractionAlias, movement.Alias, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec(savePostingQuery).
+		WithArgs(5, FeeMov, ARS, movement.Fee, amount("0", ARS), movement.Alias, FeesAccount, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectExec(savePostingQuery).
+		WithArgs(5, ReceiveMov, ARS, movement.Fee, amount("0", ARS), FeesAccount, movement.Alias, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	mock.ExpectCommit()
+
+	// Then
+	saved, err := repository.Save(context.Background(), movement)
+	require.NoError(t, err)
+	require.Equal(t, amount("0", ARS), saved.TotalAmount)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCountMovements_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, testCurrencies)
+	defer db.Close()
+	since := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// When
+	mock.ExpectQuery("SELECT COUNT(*) FROM movements m LEFT JOIN journal_entries j ON j.id = m.entry_id WHERE m.alias = ? "+
+		"AND m.mov_type = ? AND m.currency_name = ? AND m.date_created >= ? AND j.refund_of IS NULL;").
+		WithArgs("user", SendMov, ARS, since).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	// Then
+	count, err := repository.CountMovements(context.Background(), "user", SendMov, ARS, since)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	require.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestSaveMovement_Error(t *testing.T) {
@@ -43,36 +247,225 @@ func TestSaveMovement_Error(t *testing.T) {
 	if err != nil {
 		require.NoError(t, err)
 	}
-	repository := New(db)
+	repository := New(db, testCurrencies)
 	defer db.Close()
 
 	movement := Movement{
-		Type:         "send",
-		Amount:       100.2,
-		CurrencyName: USDT,
-		Alias:        "user",
+		Type:             "send",
+		Amount:           amount("100.2", USDT),
+		CurrencyName:     USDT,
+		Alias:            "user",
+		InteractionAlias: "otheruser",
 	}
 
 	// When
 	mock.ExpectBegin()
-	query1 := "INSERT INTO movements_usdt(mov_type,currency_name,tx_amount,alias,interaction_alias)VALUES (?,?,?,?,?);"
-	mock.ExpectExec(query1).WithArgs(movement.Type, movement.CurrencyName, movement.Amount, movement.Alias, movement.InteractionAlias).WillReturnError(&mysql.MySQLError{
-		Number: 1264,
-	})
+	mock.ExpectQuery(lockUsersQuery).WithArgs(movement.InteractionAlias, movement.Alias).
+		WillReturnRows(sqlmock.NewRows([]string{"alias"}).AddRow("otheruser").AddRow("user"))
+	mock.ExpectQuery(lockFundsQuery).WithArgs(movement.Alias, movement.CurrencyName).
+		WillReturnRows(sqlmock.NewRows([]string{"total_amount"}).AddRow("200.00"))
+	expectHeld(mock, movement.Alias, movement.CurrencyName, "0.000000000000000000")
+	mock.ExpectQuery(lockFundsQuery).WithArgs(movement.InteractionAlias, movement.CurrencyName).
+		WillReturnRows(sqlmock.NewRows([]string{"total_amount"}))
+	mock.ExpectExec(saveEntryQuery).WithArgs(movement.Type, nil, nil, nil, nil, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(savePostingQuery).
+		WithArgs(1, movement.Type, movement.CurrencyName, movement.Amount, amount("99.8", USDT), movement.Alias, movement.InteractionAlias, sqlmock.AnyArg()).
+		WillReturnError(errors.New("connection lost"))
 	mock.ExpectRollback()
 	// then
-	err = repository.Save(context.Background(), movement)
-	require.Error(t, err)
+	_, err = repository.Save(context.Background(), movement)
+	require.EqualError(t, err, "connection lost")
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveMovement_ErrorInsufficientFunds(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		require.NoError(t, err)
+	}
+	repository := New(db, testCurrencies)
+	defer db.Close()
+
+	movement := Movement{
+		Type:             "send",
+		Amount:           amount("100.2", USDT),
+		CurrencyName:     USDT,
+		Alias:            "user",
+		InteractionAlias: "otheruser",
+	}
+
+	// When
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockUsersQuery).WithArgs(movement.InteractionAlias, movement.Alias).
+		WillReturnRows(sqlmock.NewRows([]string{"alias"}).AddRow("otheruser").AddRow("user"))
+	mock.ExpectQuery(lockFundsQuery).WithArgs(movement.Alias, movement.CurrencyName).
+		WillReturnRows(sqlmock.NewRows([]string{"total_amount"}).AddRow("100.00"))
+	expectHeld(mock, movement.Alias, movement.CurrencyName, "0.000000000000000000")
+	mock.ExpectRollback()
+
+	// then
+	_, err = repository.Save(context.Background(), movement)
+	require.EqualError(t, err, ErrorInsufficientFunds.Error())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveMovement_NegativeBalanceRejectedByDatabase(t *testing.T) {
+	tt := []struct {
+		TestName string
+		Number   uint16
+	}{
+		{"CheckConstraint", 3819},
+		{"OutOfRange", 1264},
+	}
+
+	for _, tc := range tt {
+		// Given
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		repository := New(db, testCurrencies)
+
+		movement := Movement{
+			Type:             "send",
+			Amount:           amount("100", USDT),
+			CurrencyName:     USDT,
+			Alias:            "user",
+			InteractionAlias: "otheruser",
+		}
+
+		// When
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockUsersQuery).WithArgs(movement.InteractionAlias, movement.Alias).
+			WillReturnRows(sqlmock.NewRows([]string{"alias"}).AddRow("otheruser").AddRow("user"))
+		mock.ExpectQuery(lockFundsQuery).WithArgs(movement.Alias, movement.CurrencyName).
+			WillReturnRows(sqlmock.NewRows([]string{"total_amount"}).AddRow("100.00"))
+		expectHeld(mock, movement.Alias, movement.CurrencyName, "0.000000000000000000")
+		mock.ExpectQuery(lockFundsQuery).WithArgs(movement.InteractionAlias, movement.CurrencyName).
+			WillReturnRows(sqlmock.NewRows([]string{"total_amount"}).AddRow("0.00"))
+		mock.ExpectExec(saveEntryQuery).WithArgs(movement.Type, nil, nil, nil, nil, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(savePostingQuery).
+			WithArgs(1, movement.Type, movement.CurrencyName, movement.Amount, amount("0", USDT), movement.Alias, movement.InteractionAlias, sqlmock.AnyArg()).
+			WillReturnError(&mysql.MySQLError{Number: tc.Number})
+		mock.ExpectRollback()
+
+		// Then
+		_, err = repository.Save(context.Background(), movement)
+		require.Equal(t, ErrorInsufficientFunds, err, tc.TestName)
+		db.Close()
+	}
+}
+
+func TestSaveMovement_DepositDoesNotCheckFunds(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		require.NoError(t, err)
+	}
+	repository := New(db, testCurrencies)
+	defer db.Close()
+
+	movement := Movement{
+		Type:             DepositMov,
+		Amount:           amount("100.2", ARS),
+		CurrencyName:     ARS,
+		Alias:            "user",
+		InteractionAlias: "user",
+	}
+
+	// When
+	mock.ExpectBegin()
+	// the money comes from the external account, it is not locked and has no running balance
+	mock.ExpectQuery(lockUserQuery).WithArgs(movement.Alias).WillReturnRows(sqlmock.NewRows([]string{"alias"}).AddRow("user"))
+	mock.ExpectQuery(lockFundsQuery).WithArgs(movement.Alias, movement.CurrencyName).
+		WillReturnRows(sqlmock.NewRows([]string{"total_amount"}))
+	mock.ExpectExec(saveEntryQuery).WithArgs(DepositMov, nil, nil, nil, nil, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectExec(savePostingQuery).
+		WithArgs(3, SendMov, movement.CurrencyName, movement.Amount, amount("0", ARS), ExternalAccount, movement.Alias, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(savePostingQuery).
+		WithArgs(3, DepositMov, movement.CurrencyName, movement.Amount, amount("100.2", ARS), movement.Alias, ExternalAccount, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	// then
+	_, err = repository.Save(context.Background(), movement)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveMovement_When_OnlySystemAccounts_Then_NothingIsLocked(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, testCurrencies)
+	defer db.Close()
+
+	// a paid withdrawal leaves the wallet
+	movement := Movement{
+		Type:             SendMov,
+		Amount:           amount("100", ARS),
+		CurrencyName:     ARS,
+		Alias:            WithdrawalsAccount,
+		InteractionAlias: ExternalAccount,
+	}
+
+	// When
+	mock.ExpectBegin()
+	mock.ExpectExec(saveEntryQuery).WithArgs(SendMov, nil, nil, nil, nil, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(8, 1))
+	mock.ExpectExec(savePostingQuery).
+		WithArgs(8, SendMov, ARS, movement.Amount, amount("0", ARS), WithdrawalsAccount, ExternalAccount, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(savePostingQuery).
+		WithArgs(8, ReceiveMov, ARS, movement.Amount, amount("0", ARS), ExternalAccount, WithdrawalsAccount, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	// Then
+	saved, err := repository.Save(context.Background(), movement)
+	require.NoError(t, err)
+	require.Equal(t, int64(8), saved.ID)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveMovement_When_ReferenceWasSaved_Then_ReturnsError(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, testCurrencies)
+	defer db.Close()
+
+	movement := Movement{
+		Type:             DepositMov,
+		Amount:           amount("100.2", ARS),
+		CurrencyName:     ARS,
+		Alias:            "user",
+		InteractionAlias: "user",
+		Reference:        "deposit:1",
+	}
+
+	// When
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockUserQuery).WithArgs(movement.Alias).WillReturnRows(sqlmock.NewRows([]string{"alias"}).AddRow("user"))
+	mock.ExpectQuery(lockFundsQuery).WithArgs(movement.Alias, movement.CurrencyName).
+		WillReturnRows(sqlmock.NewRows([]string{"total_amount"}))
+	mock.ExpectExec(saveEntryQuery).WithArgs(DepositMov, nil, nil, nil, movement.Reference, sqlmock.AnyArg()).
+		WillReturnError(&mysql.MySQLError{Number: 1062})
+	mock.ExpectRollback()
+
+	// Then
+	_, err = repository.Save(context.Background(), movement)
+	require.Equal(t, ErrorAlreadySaved, err)
+	require.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestSaveMovement_ErrorWrongCurrency(t *testing.T) {
 	// Given
-	repository := New(nil)
+	repository := New(nil, testCurrencies)
 
 	// When
-	err := repository.Save(context.Background(), Movement{
+	_, err := repository.Save(context.Background(), Movement{
 		Type:         DepositMov,
-		Amount:       100.2,
+		Amount:       money.MustParse("100.2"),
 		CurrencyName: "wrong",
 		Alias:        "alias",
 	})
@@ -87,22 +480,197 @@ func TestGetFunds_ok(t *testing.T) {
 	if err != nil {
 		require.NoError(t, err)
 	}
-	repository := New(db)
+	repository := New(db, testCurrencies)
 	defer db.Close()
 	movement := Movement{
 		Type:             "send",
-		Amount:           100.2,
+		Amount:           amount("100.2", USDT),
 		CurrencyName:     USDT,
 		Alias:            "user",
 		InteractionAlias: "user",
 	}
 
 	// When
-	query := "SELECT total_amount FROM movements_usdt WHERE id = (SELECT MAX(id) FROM movements_usdt WHERE alias = ?);"
-	mock.ExpectQuery(query).WithArgs(movement.Alias).WillReturnRows(sqlmock.NewRows([]string{"total_amount"}).
-		AddRow(float64(100)))
+	query := "SELECT total_amount FROM movements WHERE id = (SELECT MAX(id) FROM movements WHERE alias = ? AND currency_name = ?);"
+	mock.ExpectQuery(query).WithArgs(movement.Alias, movement.CurrencyName).WillReturnRows(sqlmock.NewRows([]string{"total_amount"}).
+		AddRow("100.00"))
+	expectHeld(mock, movement.Alias, movement.CurrencyName, "30.000000000000000000")
 	// then
 	result, err := repository.GetFunds(context.Background(), movement.CurrencyName, movement.Alias)
 	require.NoError(t, err)
-	require.Equal(t, float64(100), result)
+	require.Equal(t, amount("70", USDT), result)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoadRegistry_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		require.NoError(t, err)
+	}
+	defer db.Close()
+
+	// When
+	mock.ExpectQuery("SELECT code,name,digits,enabled FROM currencies ORDER BY code;").
+		WillReturnRows(sqlmock.NewRows([]string{"code", "name", "digits", "enabled"}).
+			AddRow("ARS", "Argentine peso", 2, true).
+			AddRow("DOGE", "Dogecoin", 8, false))
+
+	// Then
+	registry, err := LoadRegistry(context.Background(), db)
+	require.NoError(t, err)
+	require.Len(t, registry.All(), 2)
+	require.Equal(t, []Currency{{Code: "ARS", Name: "Argentine peso", Digits: 2, Enabled: true}}, registry.Enabled())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetFunds_When_AccountHasNoMovements_Then_ReturnsZero(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		require.NoError(t, err)
+	}
+	repository := New(db, testCurrencies)
+	defer db.Close()
+
+	// When
+	// the currency was added after the user was created
+	query := "SELECT total_amount FROM movements WHERE id = (SELECT MAX(id) FROM movements WHERE alias = ? AND currency_name = ?);"
+	mock.ExpectQuery(query).WithArgs("user", BTC).WillReturnRows(sqlmock.NewRows([]string{"total_amount"}))
+	expectHeld(mock, "user", BTC, "0")
+
+	// Then
+	result, err := repository.GetFunds(context.Background(), BTC, "user")
+	require.NoError(t, err)
+	require.Equal(t, "0.00000000", result.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAccountExtract_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		require.NoError(t, err)
+	}
+	repository := New(db, testCurrencies)
+	defer db.Close()
+
+	// When
+	query := "SELECT m.currency_name,m.total_amount FROM movements m " +
+		"JOIN (SELECT MAX(id) AS id FROM movements WHERE alias = ? GROUP BY currency_name) l ON m.id = l.id;"
+	mock.ExpectQuery(query).WithArgs("user").WillReturnRows(sqlmock.NewRows([]string{"currency_name", "total_amount"}).
+		AddRow(ARS, "1500.250000000000000000").
+		AddRow(BTC, "0.000000010000000000"))
+	mock.ExpectQuery("SELECT currency_name,SUM(amount+fee) FROM holds "+
+		"WHERE alias = ? AND status = ? AND expires_at > ? GROUP BY currency_name;").
+		WithArgs("user", HoldActive, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"currency_name", "amount"}).AddRow(ARS, "500.000000000000000000"))
+
+	// Then
+	result, err := repository.GetAccountExtract(context.Background(), "user")
+	require.NoError(t, err)
+	require.Equal(t, AccountBalance{
+		ARS:  {Ledger: amount("1500.25", ARS), Available: amount("1000.25", ARS)},
+		BTC:  {Ledger: amount("0.00000001", BTC), Available: amount("0.00000001", BTC)},
+		USDT: {Ledger: amount("0", USDT), Available: amount("0", USDT)},
+	}, result)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAccountExtractAt_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, testCurrencies)
+	defer db.Close()
+	at := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	// When
+	// the holds of the past are not known
+	query := "SELECT total_amount FROM movements WHERE alias = ? AND currency_name = ? AND date_created < ? ORDER BY id DESC LIMIT 1;"
+	mock.ExpectQuery(query).WithArgs("user", ARS, at).WillReturnRows(sqlmock.NewRows([]string{"total_amount"}).
+		AddRow("1500.250000000000000000"))
+	mock.ExpectQuery(query).WithArgs("user", BTC, at).WillReturnRows(sqlmock.NewRows([]string{"total_amount"}))
+	mock.ExpectQuery(query).WithArgs("user", USDT, at).WillReturnRows(sqlmock.NewRows([]string{"total_amount"}))
+
+	// Then
+	result, err := repository.GetAccountExtractAt(context.Background(), "user", at)
+	require.NoError(t, err)
+	require.Equal(t, AccountBalanceAt{
+		ARS:  {Ledger: amount("1500.25", ARS)},
+		BTC:  {Ledger: amount("0", BTC)},
+		USDT: {Ledger: amount("0", USDT)},
+	}, result)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+const getMovementQuery = "SELECT j.entry_type,j.rate,j.spread,j.refund_of,j.date_created,m.alias,m.mov_type," +
+	"m.currency_name,m.tx_amount,m.total_amount,m.interaction_alias FROM journal_entries j JOIN movements m ON m.entry_id = j.id " +
+	"WHERE j.id = ? ORDER BY m.id;"
+
+func TestGetMovement(t *testing.T) {
+	tt := []struct {
+		TestName, Alias, Type, Amount, Total, Fee string
+	}{
+		{"Sender", "user", SendMov, "10.00", "0.00", "0.50"},
+		{"Receiver", "otheruser", ReceiveMov, "3467.47", "3467.47", "0.00"},
+	}
+
+	for _, tc := range tt {
+		// Given
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		repository := New(db, testCurrencies)
+		created := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+		// When
+		columns := []string{"entry_type", "rate", "spread", "refund_of", "date_created", "alias", "mov_type", "currency_name",
+			"tx_amount", "total_amount", "interaction_alias"}
+		rate, spread := "346.747500000000000000", "35.030000000000000000"
+		mock.ExpectQuery(getMovementQuery).WithArgs(4).WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(SendMov, rate, spread, nil, created, "user", SendMov, USDT, "10.000000000000000000", "0.500000000000000000", "otheruser").
+			AddRow(SendMov, rate, spread, nil, created, ExchangeAccount, ExchangeInMov, USDT, "10.000000000000000000", "10.000000000000000000", "user").
+			AddRow(SendMov, rate, spread, nil, created, ExchangeAccount, ExchangeOutMov, ARS, "3467.470000000000000000", "-3467.470000000000000000", "otheruser").
+			AddRow(SendMov, rate, spread, nil, created, "otheruser", ReceiveMov, ARS, "3467.470000000000000000", "3467.470000000000000000", "user").
+			AddRow(SendMov, rate, spread, nil, created, "user", FeeMov, USDT, "0.500000000000000000", "0.000000000000000000", FeesAccount).
+			AddRow(SendMov, rate, spread, nil, created, FeesAccount, ReceiveMov, USDT, "0.500000000000000000", "0.500000000000000000", "user"))
+		mock.ExpectQuery("SELECT COALESCE(SUM(m.tx_amount),0) FROM movements m JOIN journal_entries j ON j.id = m.entry_id "+
+			"WHERE j.refund_of = ? AND m.alias = ? AND m.mov_type = ?;").WithArgs(4, "otheruser", SendMov).
+			WillReturnRows(sqlmock.NewRows([]string{"refunded"}).AddRow("1000.000000000000000000"))
+
+		// Then
+		found, err := repository.GetMovement(context.Background(), tc.Alias, 4)
+		require.NoError(t, err, tc.TestName)
+		require.Equal(t, int64(4), found.ID, tc.TestName)
+		require.Equal(t, tc.Type, found.Type, tc.TestName)
+		require.Equal(t, tc.Amount, found.Amount.String(), tc.TestName)
+		require.Equal(t, tc.Total, found.TotalAmount.String(), tc.TestName)
+		require.Equal(t, tc.Fee, found.Fee.String(), tc.TestName)
+		require.Equal(t, "3467.47", found.Conversion.DestinationAmount.String(), tc.TestName)
+		require.Equal(t, "35.03", found.Conversion.Spread.String(), tc.TestName)
+		require.Equal(t, StatusPartiallyRefunded, found.Status, tc.TestName)
+		require.Equal(t, created, found.DateCreated, tc.TestName)
+		require.NoError(t, mock.ExpectationsWereMet(), tc.TestName)
+		db.Close()
+	}
+}
+
+func TestGetMovement_When_UserDoesNotTakePart_Then_ReturnsNotFound(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, testCurrencies)
+	defer db.Close()
+	created := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	// When
+	mock.ExpectQuery(getMovementQuery).WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"entry_type", "rate", "spread",
+		"refund_of", "date_created", "alias", "mov_type", "currency_name", "tx_amount", "total_amount", "interaction_alias"}).
+		AddRow(SendMov, nil, nil, nil, created, "user", SendMov, ARS, "10.000000000000000000", "0.000000000000000000", "otheruser").
+		AddRow(SendMov, nil, nil, nil, created, "otheruser", ReceiveMov, ARS, "10.000000000000000000", "10.000000000000000000", "user"))
+
+	// Then
+	_, err = repository.GetMovement(context.Background(), "stranger", 4)
+	require.Equal(t, ErrorMovementNotFound, err)
+	require.NoError(t, mock.ExpectationsWereMet())
 }
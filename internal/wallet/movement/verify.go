@@ -0,0 +1,315 @@
+package movement
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+)
+
+const (
+	// DiscrepancyBalance is a row whose balance is not the balance of the previous row of the account plus
+	// or minus its amount
+	DiscrepancyBalance = "balance"
+	// DiscrepancyUnpairedPosting is a posting of an entry without the posting of its counterparty, like a send
+	// without its receive
+	DiscrepancyUnpairedPosting = "unpaired_posting"
+	// DiscrepancyUnpairedLegacy are the sends saved before the journal entries without their receive, or the other way around
+	DiscrepancyUnpairedLegacy = "unpaired_legacy"
+	// DiscrepancyCurrencySum is a currency whose journal postings do not sum to zero
+	DiscrepancyCurrencySum = "currency_sum"
+
+	// MaxDiscrepancies is the number of discrepancies of a report, the rest are only counted
+	MaxDiscrepancies = 1000
+)
+
+// Report is the result of a verification of the ledger
+type Report struct {
+	StartedAt  time.Time `json:"startedat"`
+	FinishedAt time.Time `json:"finishedat"`
+	Accounts   int64     `json:"accounts"`
+	Movements  int64     `json:"movements"`
+	Entries    int64     `json:"entries"`
+	// Currencies has the sum of the balances of every account and the sum of the journal postings of each currency
+	Currencies map[string]CurrencyTotals `json:"currencies"`
+	// DiscrepancyCount counts every discrepancy found, Discrepancies has the first MaxDiscrepancies of them
+	DiscrepancyCount int64         `json:"discrepancycount"`
+	Discrepancies    []Discrepancy `json:"discrepancies"`
+}
+
+// CurrencyTotals are the totals of a currency in the ledger
+type CurrencyTotals struct {
+	Balances money.Amount `json:"balances"`
+	Postings money.Amount `json:"postings"`
+}
+
+// Discrepancy is an error found in the ledger
+type Discrepancy struct {
+	Kind         string `json:"kind"`
+	Alias        string `json:"alias,omitempty"`
+	CurrencyName string `json:"currencyname,omitempty"`
+	// MovementID is the id of the row of the movements table
+	MovementID int64  `json:"movementid,omitempty"`
+	EntryID    int64  `json:"entryid,omitempty"`
+	Expected   string `json:"expected,omitempty"`
+	Found      string `json:"found,omitempty"`
+	Detail     string `json:"detail"`
+}
+
+// OK returns true if no discrepancy was found
+func (r Report) OK() bool {
+	return r.DiscrepancyCount == 0
+}
+
+func (r *Report) add(d Discrepancy) {
+	r.DiscrepancyCount++
+	if len(r.Discrepancies) < MaxDiscrepancies {
+		r.Discrepancies = append(r.Discrepancies, d)
+	}
+}
+
+// Verify checks the whole ledger: the balance of every row of a user account is the balance of the previous one plus
+// or minus its amount, every posting of an entry has the posting of its counterparty, the sends saved before
+// the journal entries have their receive and the postings of every currency sum to zero.
+// The rows are read one by one from a snapshot of the database, so the movements saved meanwhile are not checked
+func (r repository) Verify(ctx context.Context) (Report, error) {
+	report := Report{StartedAt: time.Now(), Currencies: make(map[string]CurrencyTotals), Discrepancies: []Discrepancy{}}
+	for _, c := range r.currencies.All() {
+		zero := money.Zero(c.Code, c.Digits)
+		report.Currencies[c.Code] = CurrencyTotals{Balances: zero, Postings: zero}
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return Report{}, err
+	}
+	defer tx.Rollback()
+
+	if err = r.verifyBalances(ctx, tx, &report); err != nil {
+		return Report{}, err
+	}
+
+	if err = r.verifyEntries(ctx, tx, &report); err != nil {
+		return Report{}, err
+	}
+
+	if err = r.verifyLegacy(ctx, tx, &report); err != nil {
+		return Report{}, err
+	}
+
+	for _, c := range r.currencies.All() {
+		if totals := report.Currencies[c.Code]; !totals.Postings.IsZero() {
+			report.add(Discrepancy{Kind: DiscrepancyCurrencySum, CurrencyName: c.Code, Expected: "0", Found: totals.Postings.String(),
+				Detail: "the journal postings of the currency do not sum to zero"})
+		}
+	}
+
+	report.FinishedAt = time.Now()
+
+	return report, nil
+}
+
+// verifyBalances walks the rows of every account in order and checks their running balance, the system accounts
+// have no running balance and their balance is the sum of their rows
+func (r repository) verifyBalances(ctx context.Context, tx *sql.Tx, report *Report) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id,entry_id,alias,currency_name,mov_type,tx_amount,total_amount FROM movements "+
+		"ORDER BY alias,currency_name,id;")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var alias, currencyName string
+	var balance money.Amount
+	// the balance of the last row of every account is added to the totals of its currency
+	closeAccount := func() error {
+		if alias == "" {
+			return nil
+		}
+
+		totals := report.Currencies[currencyName]
+		var err error
+		if totals.Balances, err = totals.Balances.Add(balance); err != nil {
+			return err
+		}
+		report.Currencies[currencyName] = totals
+		return nil
+	}
+
+	for rows.Next() {
+		var id int64
+		var entryID sql.NullInt64
+		var rowAlias, rowCurrency, movType, txAmount, totalAmount string
+		if err = rows.Scan(&id, &entryID, &rowAlias, &rowCurrency, &movType, &txAmount, &totalAmount); err != nil {
+			return err
+		}
+
+		amount, err := r.bind(txAmount, rowCurrency)
+		if err != nil {
+			return err
+		}
+		total, err := r.bind(totalAmount, rowCurrency)
+		if err != nil {
+			return err
+		}
+
+		if rowAlias != alias || rowCurrency != currencyName {
+			if err = closeAccount(); err != nil {
+				return err
+			}
+			alias, currencyName = rowAlias, rowCurrency
+			balance = r.currencies.Zero(rowCurrency)
+			report.Accounts++
+		}
+
+		if isDebit(movType) {
+			amount = amount.Neg()
+		}
+		expected, err := balance.Add(amount)
+		if err != nil {
+			return err
+		}
+
+		if IsSystemAccount(alias) {
+			total = expected
+		} else if expected.Cmp(total) != 0 {
+			report.add(Discrepancy{Kind: DiscrepancyBalance, Alias: alias, CurrencyName: currencyName, MovementID: id,
+				EntryID: entryID.Int64, Expected: expected.String(), Found: total.String(),
+				Detail: fmt.Sprintf("the balance after the %s is not the previous balance %s %s", movType, balance, amount)})
+		}
+
+		if entryID.Valid {
+			totals := report.Currencies[currencyName]
+			if totals.Postings, err = totals.Postings.Add(amount); err != nil {
+				return err
+			}
+			report.Currencies[currencyName] = totals
+		}
+
+		// the next row is checked against the saved balance, so an error is reported once
+		balance = total
+		report.Movements++
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	return closeAccount()
+}
+
+// verifyEntries checks that every debit of an entry has the credit of its counterparty and the other way around:
+// a posting pays the account it interacts with, or is paid by the account that interacts with it, like the
+// exchange account in the sends to other currency
+func (r repository) verifyEntries(ctx context.Context, tx *sql.Tx, report *Report) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id,entry_id,alias,interaction_alias,currency_name,mov_type,tx_amount FROM movements "+
+		"WHERE entry_id IS NOT NULL ORDER BY entry_id,id;")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type posting struct {
+		id                                int64
+		alias, interactionAlias, currency string
+		movType                           string
+		amount                            money.Amount
+	}
+
+	var entryID int64
+	var postings []posting
+	checkEntry := func() {
+		paired := make([]bool, len(postings))
+		for i, debit := range postings {
+			if !isDebit(debit.movType) {
+				continue
+			}
+
+			for j, credit := range postings {
+				if paired[j] || isDebit(credit.movType) || credit.currency != debit.currency || credit.amount.Cmp(debit.amount) != 0 {
+					continue
+				}
+
+				if credit.interactionAlias == debit.alias || credit.alias == debit.interactionAlias {
+					paired[i], paired[j] = true, true
+					break
+				}
+			}
+		}
+
+		for i, p := range postings {
+			if !paired[i] {
+				report.add(Discrepancy{Kind: DiscrepancyUnpairedPosting, Alias: p.alias, CurrencyName: p.currency, MovementID: p.id,
+					EntryID: entryID, Found: p.amount.String(),
+					Detail: fmt.Sprintf("the %s with %s has no posting of its counterparty", p.movType, p.interactionAlias)})
+			}
+		}
+	}
+
+	for rows.Next() {
+		var rowEntryID int64
+		var p posting
+		var txAmount string
+		if err = rows.Scan(&p.id, &rowEntryID, &p.alias, &p.interactionAlias, &p.currency, &p.movType, &txAmount); err != nil {
+			return err
+		}
+
+		if p.amount, err = r.bind(txAmount, p.currency); err != nil {
+			return err
+		}
+
+		if rowEntryID != entryID {
+			checkEntry()
+			entryID, postings = rowEntryID, postings[:0]
+			report.Entries++
+		}
+
+		postings = append(postings, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	checkEntry()
+
+	return nil
+}
+
+// verifyLegacy checks that the sends saved before the journal entries have the receive of the other user
+// with the same amount
+func (r repository) verifyLegacy(ctx context.Context, tx *sql.Tx, report *Report) error {
+	rows, err := tx.QueryContext(ctx, "SELECT sender,receiver,currency_name,tx_amount,SUM(sends) FROM ("+
+		"SELECT alias AS sender,interaction_alias AS receiver,currency_name,tx_amount,1 AS sends FROM movements "+
+		"WHERE entry_id IS NULL AND mov_type = ? UNION ALL "+
+		"SELECT interaction_alias,alias,currency_name,tx_amount,-1 FROM movements WHERE entry_id IS NULL AND mov_type = ?"+
+		") p GROUP BY sender,receiver,currency_name,tx_amount HAVING SUM(sends) <> 0;", SendMov, ReceiveMov)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sender, receiver, currencyName, txAmount string
+		var unpaired int64
+		if err = rows.Scan(&sender, &receiver, &currencyName, &txAmount, &unpaired); err != nil {
+			return err
+		}
+
+		amount, err := r.bind(txAmount, currencyName)
+		if err != nil {
+			return err
+		}
+
+		detail := fmt.Sprintf("%d sends of %s to %s have no receive", unpaired, amount, receiver)
+		if unpaired < 0 {
+			detail = fmt.Sprintf("%d receives of %s from %s have no send", -unpaired, amount, sender)
+		}
+		report.add(Discrepancy{Kind: DiscrepancyUnpairedLegacy, Alias: sender, CurrencyName: currencyName, Found: amount.String(),
+			Detail: detail})
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,117 @@
+package movement
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	verifyBalancesQuery = "SELECT id,entry_id,alias,currency_name,mov_type,tx_amount,total_amount FROM movements " +
+		"ORDER BY alias,currency_name,id;"
+	verifyEntriesQuery = "SELECT id,entry_id,alias,interaction_alias,currency_name,mov_type,tx_amount FROM movements " +
+		"WHERE entry_id IS NOT NULL ORDER BY entry_id,id;"
+	verifyLegacyQuery = "SELECT sender,receiver,currency_name,tx_amount,SUM(sends) FROM (" +
+		"SELECT alias AS sender,interaction_alias AS receiver,currency_name,tx_amount,1 AS sends FROM movements " +
+		"WHERE entry_id IS NULL AND mov_type = ? UNION ALL " +
+		"SELECT interaction_alias,alias,currency_name,tx_amount,-1 FROM movements WHERE entry_id IS NULL AND mov_type = ?" +
+		") p GROUP BY sender,receiver,currency_name,tx_amount HAVING SUM(sends) <> 0;"
+)
+
+var (
+	verifyBalancesColumns = []string{"id", "entry_id", "alias", "currency_name", "mov_type", "tx_amount", "total_amount"}
+	verifyEntriesColumns  = []string{"id", "entry_id", "alias", "interaction_alias", "currency_name", "mov_type", "tx_amount"}
+	verifyLegacyColumns   = []string{"sender", "receiver", "currency_name", "tx_amount", "sends"}
+)
+
+func TestVerify_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	repository := New(db, testCurrencies)
+
+	// When
+	mock.ExpectBegin()
+	// a deposit of the user, a legacy send to other user and a send to other currency in entry 2,
+	// the system accounts have no running balance
+	mock.ExpectQuery(verifyBalancesQuery).WillReturnRows(sqlmock.NewRows(verifyBalancesColumns).
+		AddRow(1, 2, "@exchange", ARS, ExchangeInMov, "10.000000000000000000", "0.000000000000000000").
+		AddRow(2, 2, "@exchange", BTC, ExchangeOutMov, "0.001000000000000000", "0.000000000000000000").
+		AddRow(3, 1, "@external", ARS, SendMov, "100.000000000000000000", "0.000000000000000000").
+		AddRow(4, nil, "otheruser", ARS, "init", "0.000000000000000000", "0.000000000000000000").
+		AddRow(5, nil, "otheruser", ARS, ReceiveMov, "30.000000000000000000", "30.000000000000000000").
+		AddRow(6, 2, "otheruser", BTC, ReceiveMov, "0.001000000000000000", "0.001000000000000000").
+		AddRow(7, 1, "user", ARS, DepositMov, "100.000000000000000000", "100.000000000000000000").
+		AddRow(8, nil, "user", ARS, SendMov, "30.000000000000000000", "70.000000000000000000").
+		AddRow(9, 2, "user", ARS, SendMov, "10.000000000000000000", "60.000000000000000000"))
+	mock.ExpectQuery(verifyEntriesQuery).WillReturnRows(sqlmock.NewRows(verifyEntriesColumns).
+		AddRow(3, 1, "@external", "user", ARS, SendMov, "100.000000000000000000").
+		AddRow(7, 1, "user", "@external", ARS, DepositMov, "100.000000000000000000").
+		AddRow(9, 2, "user", "otheruser", ARS, SendMov, "10.000000000000000000").
+		AddRow(1, 2, "@exchange", "user", ARS, ExchangeInMov, "10.000000000000000000").
+		AddRow(2, 2, "@exchange", "otheruser", BTC, ExchangeOutMov, "0.001000000000000000").
+		AddRow(6, 2, "otheruser", "user", BTC, ReceiveMov, "0.001000000000000000"))
+	mock.ExpectQuery(verifyLegacyQuery).WithArgs(SendMov, ReceiveMov).WillReturnRows(sqlmock.NewRows(verifyLegacyColumns))
+	mock.ExpectRollback()
+
+	// Then
+	report, err := repository.Verify(context.Background())
+	require.NoError(t, err)
+	require.True(t, report.OK())
+	require.Empty(t, report.Discrepancies)
+	require.Equal(t, int64(6), report.Accounts)
+	require.Equal(t, int64(9), report.Movements)
+	require.Equal(t, int64(2), report.Entries)
+	require.Equal(t, "0.00", report.Currencies[ARS].Balances.String())
+	require.Equal(t, "0.00", report.Currencies[ARS].Postings.String())
+	require.Equal(t, "0.00000000", report.Currencies[BTC].Balances.String())
+	require.Equal(t, "0.00", report.Currencies[USDT].Balances.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVerify_When_LedgerIsWrong_Then_ReportsTheDiscrepancies(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	repository := New(db, testCurrencies)
+
+	// When
+	mock.ExpectBegin()
+	// the receive of the send of entry 2 is missing and the balance of the send is wrong
+	mock.ExpectQuery(verifyBalancesQuery).WillReturnRows(sqlmock.NewRows(verifyBalancesColumns).
+		AddRow(1, 1, "@external", ARS, SendMov, "100.000000000000000000", "-100.000000000000000000").
+		AddRow(5, 3, "otheruser", ARS, ReceiveMov, "5.000000000000000000", "5.000000000000000000").
+		AddRow(2, 1, "user", ARS, DepositMov, "100.000000000000000000", "100.000000000000000000").
+		AddRow(3, 2, "user", ARS, SendMov, "10.000000000000000000", "95.000000000000000000").
+		AddRow(4, 3, "user", ARS, SendMov, "5.000000000000000000", "90.000000000000000000"))
+	mock.ExpectQuery(verifyEntriesQuery).WillReturnRows(sqlmock.NewRows(verifyEntriesColumns).
+		AddRow(1, 1, "@external", "user", ARS, SendMov, "100.000000000000000000").
+		AddRow(2, 1, "user", "@external", ARS, DepositMov, "100.000000000000000000").
+		AddRow(3, 2, "user", "otheruser", ARS, SendMov, "10.000000000000000000").
+		AddRow(4, 3, "user", "otheruser", ARS, SendMov, "5.000000000000000000").
+		AddRow(5, 3, "otheruser", "user", ARS, ReceiveMov, "5.000000000000000000"))
+	mock.ExpectQuery(verifyLegacyQuery).WithArgs(SendMov, ReceiveMov).WillReturnRows(sqlmock.NewRows(verifyLegacyColumns).
+		AddRow("user", "otheruser", ARS, "30.000000000000000000", 1))
+	mock.ExpectRollback()
+
+	// Then
+	report, err := repository.Verify(context.Background())
+	require.NoError(t, err)
+	require.False(t, report.OK())
+	require.Equal(t, int64(4), report.DiscrepancyCount)
+	require.Equal(t, []Discrepancy{
+		{Kind: DiscrepancyBalance, Alias: "user", CurrencyName: ARS, MovementID: 3, EntryID: 2, Expected: "90.00", Found: "95.00",
+			Detail: "the balance after the send is not the previous balance 100.00 -10.00"},
+		{Kind: DiscrepancyUnpairedPosting, Alias: "user", CurrencyName: ARS, MovementID: 3, EntryID: 2, Found: "10.00",
+			Detail: "the send with otheruser has no posting of its counterparty"},
+		{Kind: DiscrepancyUnpairedLegacy, Alias: "user", CurrencyName: ARS, Found: "30.00",
+			Detail: "1 sends of 30.00 to otheruser have no receive"},
+		{Kind: DiscrepancyCurrencySum, CurrencyName: ARS, Expected: "0", Found: "-10.00",
+			Detail: "the journal postings of the currency do not sum to zero"},
+	}, report.Discrepancies)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
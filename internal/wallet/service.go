@@ -2,19 +2,32 @@ package wallet
 
 import (
 	"context"
+	"time"
 
+	"github.com/spolia/wallet-api/internal/wallet/exchange"
+	"github.com/spolia/wallet-api/internal/wallet/fee"
+	"github.com/spolia/wallet-api/internal/wallet/money"
 	"github.com/spolia/wallet-api/internal/wallet/movement"
+	"github.com/spolia/wallet-api/internal/wallet/statement"
 	"github.com/spolia/wallet-api/internal/wallet/user"
+	"github.com/spolia/wallet-api/internal/wallet/withdrawal"
 )
 
 type Service struct {
 	userRepo     user.Repository
 	movementRepo movement.Repository
+	currencies   *movement.Registry
+	quoter       *exchange.Quoter
+	fees         *fee.Engine
+	withdrawals  withdrawal.Store
+	statements   *statement.Generator
 }
 
-// New creates a Service implementation.
-func New(userRepo user.Repository, movRepo movement.Repository) *Service {
-	return &Service{userRepo: userRepo, movementRepo: movRepo}
+// New creates a Service implementation, without a fee engine the movements have no fees.
+func New(userRepo user.Repository, movRepo movement.Repository, currencies *movement.Registry, quoter *exchange.Quoter,
+	fees *fee.Engine, withdrawals withdrawal.Store, statements *statement.Generator) *Service {
+	return &Service{userRepo: userRepo, movementRepo: movRepo, currencies: currencies, quoter: quoter, fees: fees,
+		withdrawals: withdrawals, statements: statements}
 }
 
 // CreateUser saves a new user
@@ -40,6 +53,48 @@ func (s *Service) CreateUser(ctx context.Context, u user.User) error {
 	return nil
 }
 
+// GetUser returns the profile of the user
+func (s *Service) GetUser(ctx context.Context, alias string) (user.Profile, error) {
+	return s.userRepo.Get(ctx, alias)
+}
+
+// UpdateUser changes the given fields of the user profile
+func (s *Service) UpdateUser(ctx context.Context, alias string, update user.ProfileUpdate) (user.Profile, error) {
+	return s.userRepo.Update(ctx, alias, update)
+}
+
+// CloseUser closes the account of the user when the balance of every currency is zero, the movements of the
+// user are kept in the history of the users it interacted with. The withdrawals in progress must end first
+// because a failed or reversed one gives the money back to the account
+func (s *Service) CloseUser(ctx context.Context, alias string) error {
+	now := time.Now()
+	return s.userRepo.Close(ctx, alias, now, func(ctx context.Context) error {
+		balances, err := s.movementRepo.GetAccountExtract(ctx, alias)
+		if err != nil {
+			return err
+		}
+
+		for _, balance := range balances {
+			if !balance.Ledger.IsZero() {
+				return user.ErrorBalanceNotZero
+			}
+		}
+
+		withdrawals, err := s.withdrawals.List(ctx, alias)
+		if err != nil {
+			return err
+		}
+
+		for _, w := range withdrawals {
+			if w.InProgress(now) {
+				return user.ErrorPendingWithdrawals
+			}
+		}
+
+		return nil
+	})
+}
+
 // GetBalance returns a balance user
 func (s *Service) GetBalance(ctx context.Context, alias string) (movement.AccountBalance, error) {
 	accountExtract, err := s.movementRepo.GetAccountExtract(ctx, alias)
@@ -50,51 +105,319 @@ func (s *Service) GetBalance(ctx context.Context, alias string) (movement.Accoun
 	return accountExtract, nil
 }
 
+// GetAccountBalanceAt returns the ledger balance of every user currency as of the given time
+func (s *Service) GetAccountBalanceAt(ctx context.Context, alias string, at time.Time) (movement.AccountBalanceAt, error) {
+	return s.movementRepo.GetAccountExtractAt(ctx, alias, at)
+}
+
+// GetCurrencies returns the currencies that can be used in new movements
+func (s *Service) GetCurrencies(ctx context.Context) ([]movement.Currency, error) {
+	return s.currencies.Enabled(), nil
+}
+
 // Send the money to other user account if the user have is funds
-// otherwise returns error
-func (s *Service) Send(ctx context.Context, m movement.Movement) error {
+// otherwise returns error. When the movement has a conversion to other currency the receiver is paid
+// in that currency at the current rate. The fee is charged on top of the amount
+func (s *Service) Send(ctx context.Context, m movement.Movement) (movement.Movement, error) {
+	m, err := s.QuoteSend(ctx, m)
+	if err != nil {
+		return movement.Movement{}, err
+	}
+
+	// check the funds, the repository checks them again while the account is locked
+	funds, err := s.movementRepo.GetFunds(ctx, m.CurrencyName, m.Alias)
+	if err != nil {
+		return movement.Movement{}, err
+	}
+	total, err := m.Amount.Add(m.Fee)
+	if err != nil {
+		return movement.Movement{}, err
+	}
+
+	if funds.Cmp(total) < 0 {
+		return movement.Movement{}, movement.ErrorInsufficientFunds
+	}
+
+	return s.movementRepo.Save(ctx, m)
+}
+
+// QuoteSend returns the send with its conversion and fee without moving the money
+func (s *Service) QuoteSend(ctx context.Context, m movement.Movement) (movement.Movement, error) {
+	var err error
+	if m.Amount, err = s.currencies.Amount(m.Amount, m.CurrencyName); err != nil {
+		return movement.Movement{}, err
+	}
+
+	// the system accounts only take part in the movements created by the wallet
+	if movement.IsSystemAccount(m.InteractionAlias) {
+		return movement.Movement{}, user.ErrorDestinyUserNotFound
+	}
+
 	ok, err := s.userRepo.Exist(ctx, m.InteractionAlias)
 	if err != nil {
-		return err
+		return movement.Movement{}, err
 	}
 	if !ok {
-		return user.ErrorDestinyUserNotFound
+		return movement.Movement{}, user.ErrorDestinyUserNotFound
 	}
 
-	// check the funds
-	funds, err := s.movementRepo.GetFunds(ctx, m.CurrencyName, m.Alias)
-	if funds == 0 || funds-m.TotalAmount < 0 {
-		return movement.ErrorInsufficientFunds
+	if m.Conversion != nil {
+		if m.Conversion.DestinationCurrency == m.CurrencyName {
+			m.Conversion = nil
+		} else if m.Conversion, err = s.convert(ctx, m.Amount, m.CurrencyName, m.Conversion.DestinationCurrency); err != nil {
+			return movement.Movement{}, err
+		}
+	}
+
+	if m.Fee, err = s.fees.Fee(ctx, m.Alias, m.Type, m.Amount); err != nil {
+		return movement.Movement{}, err
 	}
 
-	err = s.movementRepo.Save(ctx, m)
+	return m, nil
+}
+
+func (s *Service) convert(ctx context.Context, amount money.Amount, from, to string) (*movement.Conversion, error) {
+	conversion, err := s.quoter.Convert(ctx, amount, from, to)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &conversion, nil
 }
 
-// AutoDeposit deposit money into the user account
-func (s *Service) AutoDeposit(ctx context.Context, m movement.Movement) error {
-	err := s.movementRepo.Save(ctx, m)
+// AutoDeposit deposit money into the user account, the fee is taken from the deposited money
+func (s *Service) AutoDeposit(ctx context.Context, m movement.Movement) (movement.Movement, error) {
+	var err error
+	if m.Amount, err = s.currencies.Amount(m.Amount, m.CurrencyName); err != nil {
+		return movement.Movement{}, err
+	}
+
+	if m.Fee, err = s.fees.Fee(ctx, m.Alias, m.Type, m.Amount); err != nil {
+		return movement.Movement{}, err
+	}
+
+	// the fee is taken from the deposited money, not from the balance of the user
+	if m.Fee.Cmp(m.Amount) > 0 {
+		return movement.Movement{}, movement.ErrorFeeExceedsAmount
+	}
+
+	return s.movementRepo.Save(ctx, m)
+}
+
+// QuoteExchange prices the exchange of an amount between two currencies of the user,
+// the quote has to be confirmed with Exchange before it expires
+func (s *Service) QuoteExchange(ctx context.Context, alias string, amount money.Amount, from, to string) (exchange.Quote, error) {
+	return s.quoter.Quote(ctx, alias, amount, from, to)
+}
+
+// Exchange confirms the quote and converts the money at the quoted rate, when the exchange fails the quote
+// is released so it can be confirmed again until it expires
+func (s *Service) Exchange(ctx context.Context, alias, quoteID string) (movement.Movement, error) {
+	quote, err := s.quoter.Confirm(ctx, alias, quoteID)
 	if err != nil {
-		return err
+		return movement.Movement{}, err
 	}
 
-	return nil
+	m, err := s.movementRepo.Save(ctx, movement.Movement{
+		Type:             movement.ExchangeMov,
+		Alias:            alias,
+		InteractionAlias: movement.ExchangeAccount,
+		Amount:           quote.Amount,
+		CurrencyName:     quote.CurrencyName,
+		Conversion:       quote.Conversion(),
+		// the reference keeps a released quote from being exchanged twice
+		Reference: "quote:" + quote.ID,
+	})
+	if err == movement.ErrorAlreadySaved {
+		return movement.Movement{}, exchange.ErrorQuoteNotFound
+	}
+
+	if err != nil {
+		s.quoter.Release(ctx, alias, quoteID)
+		return movement.Movement{}, err
+	}
+
+	return m, nil
+}
+
+// Withdraw holds the money of the user and creates a pending withdrawal, the money is paid to the destination
+// by the withdrawal processor
+func (s *Service) Withdraw(ctx context.Context, w withdrawal.Withdrawal) (withdrawal.Withdrawal, error) {
+	var err error
+	if w.Amount, err = s.currencies.Amount(w.Amount, w.CurrencyName); err != nil {
+		return withdrawal.Withdrawal{}, err
+	}
+
+	// check the funds, the repository checks them again while the account is locked
+	funds, err := s.movementRepo.GetFunds(ctx, w.CurrencyName, w.Alias)
+	if err != nil {
+		return withdrawal.Withdrawal{}, err
+	}
+	if funds.Cmp(w.Amount) < 0 {
+		return withdrawal.Withdrawal{}, movement.ErrorInsufficientFunds
+	}
+
+	held, err := s.movementRepo.Save(ctx, withdrawal.Hold(w))
+	if err != nil {
+		return withdrawal.Withdrawal{}, err
+	}
+
+	now := time.Now()
+	w.Status = withdrawal.StatusPending
+	w.EntryID = held.ID
+	w.CreatedAt, w.UpdatedAt = now, now
+	if w.ID, err = s.withdrawals.Create(ctx, w); err != nil {
+		// the withdrawal was not saved, give the money back
+		s.movementRepo.Save(ctx, withdrawal.Refund(w, movement.WithdrawalsAccount))
+		return withdrawal.Withdrawal{}, err
+	}
+
+	return w, nil
+}
+
+// GetWithdrawal returns a withdrawal of the user
+func (s *Service) GetWithdrawal(ctx context.Context, alias string, id int64) (withdrawal.Withdrawal, error) {
+	w, err := s.withdrawals.Get(ctx, alias, id)
+	if err != nil {
+		return withdrawal.Withdrawal{}, err
+	}
+
+	// the store does not know the decimals of the currencies
+	if w.Amount, err = s.currencies.Amount(w.Amount, w.CurrencyName); err != nil {
+		return withdrawal.Withdrawal{}, err
+	}
+
+	return w, nil
 }
 
-// GetHistory returns the movements history for all user accounts
-func (s *Service) GetHistory(ctx context.Context, alias string) (movement.AccountHistory, error) {
-	history, err := s.movementRepo.GetHistory(ctx, alias)
+// GetWithdrawals returns the withdrawals of the user, the newest first
+func (s *Service) GetWithdrawals(ctx context.Context, alias string) ([]withdrawal.Withdrawal, error) {
+	withdrawals, err := s.withdrawals.List(ctx, alias)
 	if err != nil {
-		return movement.AccountHistory{}, err
+		return nil, err
+	}
+
+	for i, w := range withdrawals {
+		if withdrawals[i].Amount, err = s.currencies.Amount(w.Amount, w.CurrencyName); err != nil {
+			return nil, err
+		}
+	}
+
+	return withdrawals, nil
+}
+
+// GetMovement returns a movement of the user
+func (s *Service) GetMovement(ctx context.Context, alias string, id int64) (movement.Movement, error) {
+	return s.movementRepo.GetMovement(ctx, alias, id)
+}
+
+// Refund gives back to the sender money received by the user in a send, a zero amount refunds all the money
+// that was not refunded yet
+func (s *Service) Refund(ctx context.Context, alias string, id int64, amount money.Amount) (movement.Movement, error) {
+	return s.movementRepo.Refund(ctx, alias, id, amount)
+}
+
+// CreateHold reserves money of the user to be sent later to the interaction alias, the money stays in the
+// account but it can not be used until the hold is captured, released or expires. The fee of the send is
+// computed now and held with the amount
+func (s *Service) CreateHold(ctx context.Context, h movement.Hold) (movement.Hold, error) {
+	var err error
+	if h.Amount, err = s.currencies.Amount(h.Amount, h.CurrencyName); err != nil {
+		return movement.Hold{}, err
+	}
+
+	if movement.IsSystemAccount(h.InteractionAlias) {
+		return movement.Hold{}, user.ErrorDestinyUserNotFound
+	}
+
+	ok, err := s.userRepo.Exist(ctx, h.InteractionAlias)
+	if err != nil {
+		return movement.Hold{}, err
+	}
+	if !ok {
+		return movement.Hold{}, user.ErrorDestinyUserNotFound
+	}
+
+	if h.Fee, err = s.fees.Fee(ctx, h.Alias, movement.SendMov, h.Amount); err != nil {
+		return movement.Hold{}, err
+	}
+
+	h.CreatedAt = time.Now()
+	return s.movementRepo.CreateHold(ctx, h)
+}
+
+// GetHold returns a hold of the user
+func (s *Service) GetHold(ctx context.Context, alias string, id int64) (movement.Hold, error) {
+	return s.movementRepo.GetHold(ctx, alias, id)
+}
+
+// CaptureHold sends the held money to the interaction alias of the hold, a zero amount captures all the held money.
+// The rest of the held money is available again. The fee is charged on top of the captured amount, it is the fee
+// of the captured amount and never more than the fee held by the hold
+func (s *Service) CaptureHold(ctx context.Context, alias string, id int64, amount money.Amount) (movement.Movement, error) {
+	h, err := s.movementRepo.GetHold(ctx, alias, id)
+	if err != nil {
+		return movement.Movement{}, err
+	}
+
+	if h.Status != movement.HoldActive {
+		return movement.Movement{}, movement.ErrorHoldNotActive
+	}
+
+	m := movement.Movement{
+		Type:             movement.SendMov,
+		Alias:            alias,
+		InteractionAlias: h.InteractionAlias,
+		Amount:           h.Amount,
+		CurrencyName:     h.CurrencyName,
+	}
+	if !amount.IsZero() {
+		if m.Amount, err = s.currencies.Amount(amount, h.CurrencyName); err != nil {
+			return movement.Movement{}, err
+		}
+	}
+
+	if m.Fee, err = s.fees.Fee(ctx, m.Alias, m.Type, m.Amount); err != nil {
+		return movement.Movement{}, err
+	}
+	if m.Fee.Cmp(h.Fee) > 0 {
+		m.Fee = h.Fee
+	}
+
+	return s.movementRepo.CaptureHold(ctx, id, m)
+}
+
+// ReleaseHold ends a hold of the user without moving the money
+func (s *Service) ReleaseHold(ctx context.Context, alias string, id int64) (movement.Hold, error) {
+	return s.movementRepo.ReleaseHold(ctx, alias, id)
+}
+
+// GetHistory returns a page of the movements history of the user accounts
+func (s *Service) GetHistory(ctx context.Context, alias string, filter movement.HistoryFilter) (movement.History, error) {
+	history, err := s.movementRepo.GetHistory(ctx, alias, filter)
+	if err != nil {
+		return movement.History{}, err
 	}
 
 	return history, nil
 }
 
+// ExportHistory calls fn with every row of the history of the user that matches the filter, the oldest first
+func (s *Service) ExportHistory(ctx context.Context, alias string, filter movement.HistoryFilter, fn func(movement.Row) error) error {
+	filter.Order = movement.OrderAsc
+	return s.movementRepo.ExportHistory(ctx, alias, filter, fn)
+}
+
+// GetBalanceAt returns the balance of an account of the user before the given time
+func (s *Service) GetBalanceAt(ctx context.Context, alias, currencyName string, at time.Time) (money.Amount, error) {
+	return s.movementRepo.GetBalanceAt(ctx, alias, currencyName, at)
+}
+
+// GetStatements returns the statements of the user accounts for the month of the given time
+func (s *Service) GetStatements(ctx context.Context, alias string, month time.Time) ([]statement.Statement, error) {
+	return s.statements.Get(ctx, alias, month)
+}
+
 // ValidateCredential given a alias and passwords returns true if exist the user
 // otherwise returns error
 func (s *Service) ValidateCredential(ctx context.Context, alias, password string) (bool, error) {
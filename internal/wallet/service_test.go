@@ -4,9 +4,14 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
+	"github.com/spolia/wallet-api/internal/wallet/exchange"
+	"github.com/spolia/wallet-api/internal/wallet/fee"
+	"github.com/spolia/wallet-api/internal/wallet/money"
 	"github.com/spolia/wallet-api/internal/wallet/movement"
 	"github.com/spolia/wallet-api/internal/wallet/user"
+	"github.com/spolia/wallet-api/internal/wallet/withdrawal"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
@@ -25,7 +30,7 @@ func TestService_CreateUser_ok(t *testing.T) {
 	userMock.On("Save").Return(nil).Once()
 	var movementsMock movementRepositoryMock
 	movementsMock.On("InitSave").Return(nil).Once()
-	service := New(&userMock, &movementsMock)
+	service := New(&userMock, &movementsMock, currencies, nil, nil, nil, nil)
 
 	// Then
 	err := service.CreateUser(context.Background(), input)
@@ -45,7 +50,7 @@ func TestService_CreateUser_Fail(t *testing.T) {
 	var userMock userRepositoryMock
 	userMock.On("Save").Return(errors.New("user: fail")).Once()
 
-	service := New(&userMock, nil)
+	service := New(&userMock, nil, currencies, nil, nil, nil, nil)
 
 	// Then
 	err := service.CreateUser(context.Background(), input)
@@ -68,7 +73,7 @@ func TestService_CreateUser_When_InitSaveFails_DeletesUserSaved(t *testing.T) {
 
 	var movementsMock movementRepositoryMock
 	movementsMock.On("InitSave").Return(errors.New("movement: fail")).Once()
-	service := New(&userMock, &movementsMock)
+	service := New(&userMock, &movementsMock, currencies, nil, nil, nil, nil)
 
 	// Then
 	err := service.CreateUser(context.Background(), input)
@@ -88,7 +93,7 @@ func TestService_GetUser_When_GetAccountExtractFail_Then_ReturnsError(t *testing
 
 	var movementsMock movementRepositoryMock
 	movementsMock.On("GetAccountExtract").Return(movement.AccountBalance{}, errors.New("mov fail")).Once()
-	service := New(&userMock, &movementsMock)
+	service := New(&userMock, &movementsMock, currencies, nil, nil, nil, nil)
 
 	// Then
 	userResult, err := service.GetBalance(context.Background(), "user")
@@ -100,7 +105,7 @@ func TestService_Send_ok(t *testing.T) {
 	// Given
 	input := movement.Movement{
 		Type:             "deposit",
-		Amount:           100,
+		Amount:           ars("100"),
 		CurrencyName:     "ARS",
 		Alias:            "user",
 		InteractionAlias: "user",
@@ -110,11 +115,11 @@ func TestService_Send_ok(t *testing.T) {
 	var movementsMock movementRepositoryMock
 	userMock.On("Exist").Return(true, nil).Once()
 	movementsMock.On("Save").Return(nil).Once()
-	movementsMock.On("GetFunds").Return(float64(100), nil).Once()
-	service := New(&userMock, &movementsMock)
+	movementsMock.On("GetFunds").Return(ars("100"), nil).Once()
+	service := New(&userMock, &movementsMock, currencies, nil, nil, nil, nil)
 
 	// Then
-	err := service.Send(context.Background(), input)
+	_, err := service.Send(context.Background(), input)
 	require.NoError(t, err)
 }
 
@@ -122,7 +127,7 @@ func TestService_Send_Fail(t *testing.T) {
 	// Given
 	input := movement.Movement{
 		Type:         "deposit",
-		Amount:       100,
+		Amount:       ars("100"),
 		CurrencyName: "ARS",
 		Alias:        "user",
 	}
@@ -130,15 +135,591 @@ func TestService_Send_Fail(t *testing.T) {
 	var userMock userRepositoryMock
 	var movementsMock movementRepositoryMock
 	userMock.On("Exist").Return(true, nil).Once()
-	movementsMock.On("GetFunds").Return(float64(100), nil).Once()
+	movementsMock.On("GetFunds").Return(ars("100"), nil).Once()
 	movementsMock.On("Save").Return(errors.New("movement:fail")).Once()
-	service := New(&userMock, &movementsMock)
+	service := New(&userMock, &movementsMock, currencies, nil, nil, nil, nil)
 
 	// Then
-	err := service.Send(context.Background(), input)
+	_, err := service.Send(context.Background(), input)
 	require.Error(t, err)
 }
 
+func TestService_Send_Funds(t *testing.T) {
+	tt := []struct {
+		TestName      string
+		Funds         money.Amount
+		FundsError    error
+		ExpectedSave  bool
+		ExpectedError error
+	}{
+		{"ExactBalance", ars("100"), nil, true, nil},
+		{"UnderBalance", ars("150.50"), nil, true, nil},
+		{"OverBalance", ars("99.99"), nil, false, movement.ErrorInsufficientFunds},
+		{"ZeroBalance", ars("0"), nil, false, movement.ErrorInsufficientFunds},
+		{"DatabaseError", money.Amount{}, errors.New("database error"), false, errors.New("database error")},
+	}
+
+	for _, tc := range tt {
+		// Given
+		input := movement.Movement{
+			Type:             movement.SendMov,
+			Amount:           ars("100"),
+			CurrencyName:     "ARS",
+			Alias:            "user",
+			InteractionAlias: "otheruser",
+		}
+
+		// When
+		var userMock userRepositoryMock
+		var movementsMock movementRepositoryMock
+		userMock.On("Exist").Return(true, nil).Once()
+		movementsMock.On("GetFunds").Return(tc.Funds, tc.FundsError).Once()
+		if tc.ExpectedSave {
+			movementsMock.On("Save").Return(nil).Once()
+		}
+		service := New(&userMock, &movementsMock, currencies, nil, nil, nil, nil)
+
+		// Then
+		_, err := service.Send(context.Background(), input)
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		movementsMock.AssertExpectations(t)
+	}
+}
+
+func TestService_Send_When_RepositoryRejectsFunds_Then_ReturnsInsufficientFunds(t *testing.T) {
+	// Given
+	input := movement.Movement{
+		Type:             movement.SendMov,
+		Amount:           ars("100"),
+		CurrencyName:     "ARS",
+		Alias:            "user",
+		InteractionAlias: "otheruser",
+	}
+	// When
+	var userMock userRepositoryMock
+	var movementsMock movementRepositoryMock
+	userMock.On("Exist").Return(true, nil).Once()
+	// the funds were spent by a concurrent send after the first check
+	movementsMock.On("GetFunds").Return(ars("100"), nil).Once()
+	movementsMock.On("Save").Return(movement.ErrorInsufficientFunds).Once()
+	service := New(&userMock, &movementsMock, currencies, nil, nil, nil, nil)
+
+	// Then
+	_, err := service.Send(context.Background(), input)
+	require.Equal(t, movement.ErrorInsufficientFunds, err)
+}
+
+func TestService_Send_When_DestinyIsASystemAccount_Then_ReturnsNotFound(t *testing.T) {
+	// Given
+	input := movement.Movement{
+		Type:             movement.SendMov,
+		Amount:           ars("100"),
+		CurrencyName:     movement.ARS,
+		Alias:            "user",
+		InteractionAlias: movement.FeesAccount,
+	}
+	// When
+	var userMock userRepositoryMock
+	var movementsMock movementRepositoryMock
+	service := New(&userMock, &movementsMock, currencies, nil, nil, nil, nil)
+
+	// Then
+	_, err := service.Send(context.Background(), input)
+	require.Equal(t, user.ErrorDestinyUserNotFound, err)
+	userMock.AssertExpectations(t)
+	movementsMock.AssertExpectations(t)
+}
+
+func TestService_Send_Currency(t *testing.T) {
+	tt := []struct {
+		TestName      string
+		Amount        money.Amount
+		CurrencyName  string
+		ExpectedError error
+	}{
+		{"UnknownCurrency", money.MustParse("1"), "EUR", movement.ErrorWrongCurrency},
+		{"DisabledCurrency", money.MustParse("1"), "DOGE", movement.ErrorWrongCurrency},
+		{"TooManyDecimals", money.MustParse("1.001"), movement.ARS, money.ErrorTooManyDecimals},
+	}
+
+	for _, tc := range tt {
+		// Given
+		input := movement.Movement{
+			Type:             movement.SendMov,
+			Amount:           tc.Amount,
+			CurrencyName:     tc.CurrencyName,
+			Alias:            "user",
+			InteractionAlias: "otheruser",
+		}
+
+		// When
+		var userMock userRepositoryMock
+		var movementsMock movementRepositoryMock
+		service := New(&userMock, &movementsMock, currencies, nil, nil, nil, nil)
+
+		// Then
+		_, err := service.Send(context.Background(), input)
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		movementsMock.AssertExpectations(t)
+	}
+}
+
+func TestService_AutoDeposit_When_CurrencyIsDisabled_Then_ReturnsWrongCurrency(t *testing.T) {
+	// Given
+	input := movement.Movement{
+		Type:             movement.DepositMov,
+		Amount:           money.MustParse("10"),
+		CurrencyName:     "DOGE",
+		Alias:            "user",
+		InteractionAlias: "user",
+	}
+	// When
+	var movementsMock movementRepositoryMock
+	service := New(nil, &movementsMock, currencies, nil, nil, nil, nil)
+
+	// Then
+	_, err := service.AutoDeposit(context.Background(), input)
+	require.Equal(t, movement.ErrorWrongCurrency, err)
+	movementsMock.AssertExpectations(t)
+}
+
+func TestService_AutoDeposit_When_FeeExceedsAmount_Then_ReturnsError(t *testing.T) {
+	// Given
+	input := movement.Movement{
+		Type:             movement.DepositMov,
+		Amount:           money.MustParse("5"),
+		CurrencyName:     movement.ARS,
+		Alias:            "user",
+		InteractionAlias: "user",
+	}
+	fees, err := fee.NewEngine([]fee.Rule{{MovementType: movement.DepositMov, Currency: movement.ARS, Flat: money.MustParse("10")}}, nil, currencies)
+	require.NoError(t, err)
+	var movementsMock movementRepositoryMock
+	service := New(nil, &movementsMock, currencies, nil, fees, nil, nil)
+
+	// When
+	_, err = service.AutoDeposit(context.Background(), input)
+
+	// Then
+	require.Equal(t, movement.ErrorFeeExceedsAmount, err)
+	movementsMock.AssertExpectations(t)
+}
+
+func TestService_Exchange(t *testing.T) {
+	// Given
+	rates := exchange.StaticRates{"USDT/ARS": money.MustParse("350.25")}
+	quoter := exchange.NewQuoter(rates, exchange.NewMemory(), currencies, time.Minute, money.Amount{})
+	var movementsMock movementRepositoryMock
+	movementsMock.On("Save").Return(nil).Once()
+	service := New(nil, &movementsMock, currencies, quoter, nil, nil, nil)
+
+	// When
+	quote, err := service.QuoteExchange(context.Background(), "user", money.MustParse("10"), movement.USDT, movement.ARS)
+	require.NoError(t, err)
+	exchanged, err := service.Exchange(context.Background(), "user", quote.ID)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, movement.ExchangeMov, exchanged.Type)
+	require.Equal(t, "3502.50", exchanged.Conversion.DestinationAmount.String())
+	_, err = service.Exchange(context.Background(), "user", quote.ID)
+	require.Equal(t, exchange.ErrorQuoteNotFound, err)
+	movementsMock.AssertExpectations(t)
+}
+
+func TestService_Exchange_When_SaveFails_Then_QuoteCanBeConfirmedAgain(t *testing.T) {
+	// Given
+	rates := exchange.StaticRates{"USDT/ARS": money.MustParse("350.25")}
+	quoter := exchange.NewQuoter(rates, exchange.NewMemory(), currencies, time.Minute, money.Amount{})
+	var movementsMock movementRepositoryMock
+	movementsMock.On("Save").Return(movement.ErrorInsufficientFunds).Once()
+	movementsMock.On("Save").Return(nil).Once()
+	service := New(nil, &movementsMock, currencies, quoter, nil, nil, nil)
+
+	// When
+	quote, err := service.QuoteExchange(context.Background(), "user", money.MustParse("10"), movement.USDT, movement.ARS)
+	require.NoError(t, err)
+	_, failedErr := service.Exchange(context.Background(), "user", quote.ID)
+	exchanged, err := service.Exchange(context.Background(), "user", quote.ID)
+
+	// Then
+	require.Equal(t, movement.ErrorInsufficientFunds, failedErr)
+	require.NoError(t, err)
+	require.Equal(t, "quote:"+quote.ID, exchanged.Reference)
+	movementsMock.AssertExpectations(t)
+}
+
+func TestService_Exchange_When_QuoteWasExchanged_Then_ReturnsNotFound(t *testing.T) {
+	// Given
+	rates := exchange.StaticRates{"USDT/ARS": money.MustParse("350.25")}
+	quoter := exchange.NewQuoter(rates, exchange.NewMemory(), currencies, time.Minute, money.Amount{})
+	var movementsMock movementRepositoryMock
+	movementsMock.On("Save").Return(movement.ErrorAlreadySaved).Once()
+	service := New(nil, &movementsMock, currencies, quoter, nil, nil, nil)
+
+	// When
+	quote, err := service.QuoteExchange(context.Background(), "user", money.MustParse("10"), movement.USDT, movement.ARS)
+	require.NoError(t, err)
+	_, err = service.Exchange(context.Background(), "user", quote.ID)
+
+	// Then
+	require.Equal(t, exchange.ErrorQuoteNotFound, err)
+	_, err = service.Exchange(context.Background(), "user", quote.ID)
+	require.Equal(t, exchange.ErrorQuoteNotFound, err)
+	movementsMock.AssertExpectations(t)
+}
+
+func TestService_Send_Conversion(t *testing.T) {
+	tt := []struct {
+		TestName            string
+		DestinationCurrency string
+		ExpectedAmount      string
+		ExpectedSpread      string
+		ExpectedError       error
+	}{
+		{"Ok", movement.ARS, "3467.47", "35.03", nil},
+		{"SameCurrency", movement.USDT, "", "", nil},
+		{"RateNotFound", movement.BTC, "", "", exchange.ErrorRateNotFound},
+		{"DisabledCurrency", "DOGE", "", "", movement.ErrorWrongCurrency},
+	}
+
+	for _, tc := range tt {
+		// Given
+		input := movement.Movement{
+			Type:             movement.SendMov,
+			Amount:           money.MustParse("10"),
+			CurrencyName:     movement.USDT,
+			Alias:            "user",
+			InteractionAlias: "otheruser",
+			Conversion:       &movement.Conversion{DestinationCurrency: tc.DestinationCurrency},
+		}
+		rates := exchange.StaticRates{"USDT/ARS": money.MustParse("350.25")}
+		quoter := exchange.NewQuoter(rates, exchange.NewMemory(), currencies, time.Minute, money.MustParse("0.01"))
+
+		// When
+		var userMock userRepositoryMock
+		var movementsMock movementRepositoryMock
+		userMock.On("Exist").Return(true, nil).Once()
+		if tc.ExpectedError == nil {
+			movementsMock.On("GetFunds").Return(money.MustParse("10"), nil).Once()
+			movementsMock.On("Save").Return(nil).Once()
+		}
+		service := New(&userMock, &movementsMock, currencies, quoter, nil, nil, nil)
+
+		// Then
+		sent, err := service.Send(context.Background(), input)
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		movementsMock.AssertExpectations(t)
+		if tc.ExpectedError != nil {
+			continue
+		}
+
+		if tc.ExpectedAmount == "" {
+			require.Nil(t, sent.Conversion, tc.TestName)
+			continue
+		}
+
+		require.Equal(t, tc.ExpectedAmount, sent.Conversion.DestinationAmount.String(), tc.TestName)
+		require.Equal(t, tc.DestinationCurrency, sent.Conversion.DestinationAmount.Currency(), tc.TestName)
+		require.Equal(t, tc.ExpectedSpread, sent.Conversion.Spread.String(), tc.TestName)
+		require.Equal(t, "346.7475", sent.Conversion.Rate.String(), tc.TestName)
+	}
+}
+
+func TestService_Send_Fee(t *testing.T) {
+	tt := []struct {
+		TestName      string
+		Funds         money.Amount
+		ExpectedError error
+	}{
+		{"Ok", ars("110"), nil},
+		{"FundsDoNotCoverTheFee", ars("109.99"), movement.ErrorInsufficientFunds},
+	}
+
+	for _, tc := range tt {
+		// Given
+		input := movement.Movement{
+			Type:             movement.SendMov,
+			Amount:           ars("100"),
+			CurrencyName:     movement.ARS,
+			Alias:            "user",
+			InteractionAlias: "otheruser",
+		}
+		fees, err := fee.NewEngine([]fee.Rule{{MovementType: movement.SendMov, Currency: movement.ARS, Flat: money.MustParse("10")}}, nil, currencies)
+		require.NoError(t, err)
+
+		// When
+		var userMock userRepositoryMock
+		var movementsMock movementRepositoryMock
+		userMock.On("Exist").Return(true, nil).Once()
+		movementsMock.On("GetFunds").Return(tc.Funds, nil).Once()
+		if tc.ExpectedError == nil {
+			movementsMock.On("Save").Return(nil).Once()
+		}
+		service := New(&userMock, &movementsMock, currencies, nil, fees, nil, nil)
+
+		// Then
+		sent, err := service.Send(context.Background(), input)
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		movementsMock.AssertExpectations(t)
+		if tc.ExpectedError == nil {
+			require.Equal(t, "10.00", sent.Fee.String(), tc.TestName)
+		}
+	}
+}
+
+func TestService_QuoteSend(t *testing.T) {
+	// Given
+	input := movement.Movement{
+		Type:             movement.SendMov,
+		Amount:           money.MustParse("1000"),
+		CurrencyName:     movement.ARS,
+		Alias:            "user",
+		InteractionAlias: "otheruser",
+	}
+	fees, err := fee.NewEngine([]fee.Rule{{MovementType: movement.SendMov, Currency: movement.ARS, Percentage: money.MustParse("1")}}, nil, currencies)
+	require.NoError(t, err)
+
+	// When
+	var userMock userRepositoryMock
+	var movementsMock movementRepositoryMock
+	userMock.On("Exist").Return(true, nil).Once()
+	service := New(&userMock, &movementsMock, currencies, nil, fees, nil, nil)
+
+	// Then
+	quoted, err := service.QuoteSend(context.Background(), input)
+	require.NoError(t, err)
+	require.Equal(t, "10.00", quoted.Fee.String())
+	require.Equal(t, "1000.00", quoted.Amount.String())
+	// nothing is saved
+	movementsMock.AssertExpectations(t)
+}
+
+func TestService_Withdraw(t *testing.T) {
+	tt := []struct {
+		TestName       string
+		Funds          money.Amount
+		CreateError    error
+		ExpectedSaves  int
+		ExpectedError  error
+		ExpectedStatus withdrawal.Status
+	}{
+		{"Ok", ars("100"), nil, 1, nil, withdrawal.StatusPending},
+		{"InsufficientFunds", ars("99.99"), nil, 0, movement.ErrorInsufficientFunds, ""},
+		// the money is given back when the withdrawal can not be saved
+		{"CreateFails", ars("100"), errors.New("database error"), 2, errors.New("database error"), ""},
+	}
+
+	for _, tc := range tt {
+		// Given
+		input := withdrawal.Withdrawal{
+			Alias:        "user",
+			Amount:       money.MustParse("100"),
+			CurrencyName: movement.ARS,
+			Destination:  "bank account",
+		}
+
+		// When
+		var movementsMock movementRepositoryMock
+		var withdrawalsMock withdrawalStoreMock
+		movementsMock.On("GetFunds").Return(tc.Funds, nil).Once()
+		if tc.ExpectedSaves > 0 {
+			movementsMock.On("Save").Return(nil).Times(tc.ExpectedSaves)
+			withdrawalsMock.On("Create").Return(int64(3), tc.CreateError).Once()
+		}
+		service := New(nil, &movementsMock, currencies, nil, nil, &withdrawalsMock, nil)
+
+		// Then
+		created, err := service.Withdraw(context.Background(), input)
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		require.Equal(t, tc.ExpectedStatus, created.Status, tc.TestName)
+		movementsMock.AssertExpectations(t)
+		withdrawalsMock.AssertExpectations(t)
+	}
+}
+
+func TestService_CaptureHold(t *testing.T) {
+	tt := []struct {
+		TestName       string
+		Amount         money.Amount
+		Status         string
+		HeldFee        string
+		ExpectedAmount string
+		ExpectedFee    string
+		ExpectedError  error
+	}{
+		{"Full", money.Amount{}, movement.HoldActive, "1", "100.00", "1.00", nil},
+		{"Partial", money.MustParse("40"), movement.HoldActive, "1", "40.00", "1.00", nil},
+		// the hold was created while the user had free sends
+		{"FeeNotHeld", money.Amount{}, movement.HoldActive, "0", "100.00", "0.00", nil},
+		{"Released", money.Amount{}, movement.HoldReleased, "1", "", "", movement.ErrorHoldNotActive},
+	}
+
+	for _, tc := range tt {
+		// Given
+		hold := movement.Hold{ID: 3, Alias: "user", InteractionAlias: "merchant", Amount: ars("100"), Fee: ars(tc.HeldFee),
+			CurrencyName: movement.ARS, Status: tc.Status}
+		fees, err := fee.NewEngine([]fee.Rule{{MovementType: movement.SendMov, Currency: movement.ARS, Flat: money.MustParse("1")}}, nil, currencies)
+		require.NoError(t, err)
+
+		// When
+		var movementsMock movementRepositoryMock
+		movementsMock.On("GetHold").Return(hold, nil).Once()
+		if tc.ExpectedError == nil {
+			movementsMock.On("CaptureHold").Return(nil).Once()
+		}
+		service := New(nil, &movementsMock, currencies, nil, fees, nil, nil)
+
+		// Then
+		sent, err := service.CaptureHold(context.Background(), "user", 3, tc.Amount)
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		movementsMock.AssertExpectations(t)
+		if tc.ExpectedError == nil {
+			require.Equal(t, "merchant", sent.InteractionAlias, tc.TestName)
+			require.Equal(t, tc.ExpectedAmount, sent.Amount.String(), tc.TestName)
+			require.Equal(t, tc.ExpectedFee, sent.Fee.String(), tc.TestName)
+		}
+	}
+}
+
+func TestService_CreateHold_HoldsTheFee(t *testing.T) {
+	// Given
+	fees, err := fee.NewEngine([]fee.Rule{{MovementType: movement.SendMov, Currency: movement.ARS, Flat: money.MustParse("1")}}, nil, currencies)
+	require.NoError(t, err)
+	var userMock userRepositoryMock
+	userMock.On("Exist").Return(true, nil).Once()
+	var movementsMock movementRepositoryMock
+	movementsMock.On("CreateHold").Return(nil).Once()
+	service := New(&userMock, &movementsMock, currencies, nil, fees, nil, nil)
+
+	// When
+	created, err := service.CreateHold(context.Background(), movement.Hold{Alias: "user", InteractionAlias: "merchant",
+		Amount: money.MustParse("100"), CurrencyName: movement.ARS})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "100.00", created.Amount.String())
+	require.Equal(t, "1.00", created.Fee.String())
+	movementsMock.AssertExpectations(t)
+}
+
+func TestService_CreateHold_When_ReceiverIsASystemAccount_Then_ReturnsNotFound(t *testing.T) {
+	// Given
+	service := New(nil, nil, currencies, nil, nil, nil, nil)
+
+	// When
+	_, err := service.CreateHold(context.Background(), movement.Hold{Alias: "user", InteractionAlias: movement.FeesAccount,
+		Amount: money.MustParse("10"), CurrencyName: movement.ARS})
+
+	// Then
+	require.Equal(t, user.ErrorDestinyUserNotFound, err)
+}
+
+var currencies = func() *movement.Registry {
+	registry, err := movement.NewRegistry([]movement.Currency{
+		{Code: movement.ARS, Name: "Peso", Digits: 2, Enabled: true},
+		{Code: movement.BTC, Name: "Bitcoin", Digits: 8, Enabled: true},
+		{Code: movement.USDT, Name: "Tether", Digits: 2, Enabled: true},
+		{Code: "DOGE", Name: "Dogecoin", Digits: 8, Enabled: false},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return registry
+}()
+
+func ars(value string) money.Amount {
+	amount, err := money.MustParse(value).In(movement.ARS, 2)
+	if err != nil {
+		panic(err)
+	}
+
+	return amount
+}
+
+func TestService_CloseUser(t *testing.T) {
+	tt := []struct {
+		TestName      string
+		Balance       money.Amount
+		Withdrawals   []withdrawal.Withdrawal
+		ExpectedError error
+	}{
+		{"Ok", ars("0"), []withdrawal.Withdrawal{{Status: withdrawal.StatusFailed},
+			{Status: withdrawal.StatusSettled, UpdatedAt: time.Now().Add(-withdrawal.ReversalWindow - time.Hour)}}, nil},
+		{"BalanceNotZero", ars("0.01"), nil, user.ErrorBalanceNotZero},
+		{"PendingWithdrawal", ars("0"), []withdrawal.Withdrawal{{Status: withdrawal.StatusPending}}, user.ErrorPendingWithdrawals},
+		{"ReversibleWithdrawal", ars("0"), []withdrawal.Withdrawal{{Status: withdrawal.StatusSettled, UpdatedAt: time.Now()}},
+			user.ErrorPendingWithdrawals},
+	}
+
+	for _, tc := range tt {
+		// Given
+		var userMock userRepositoryMock
+		userMock.On("Close").Return(nil).Once()
+		var movementsMock movementRepositoryMock
+		movementsMock.On("GetAccountExtract").
+			Return(movement.AccountBalance{movement.ARS: {Ledger: tc.Balance, Available: tc.Balance}}, nil).Once()
+		var withdrawalsMock withdrawalStoreMock
+		if tc.Balance.IsZero() {
+			withdrawalsMock.On("List").Return(tc.Withdrawals, nil).Once()
+		}
+		service := New(&userMock, &movementsMock, currencies, nil, nil, &withdrawalsMock, nil)
+
+		// When
+		err := service.CloseUser(context.Background(), "user")
+
+		// Then
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		userMock.AssertExpectations(t)
+		movementsMock.AssertExpectations(t)
+		withdrawalsMock.AssertExpectations(t)
+	}
+}
+
+func TestService_CloseUser_When_UserIsNotFound_Then_ReturnsError(t *testing.T) {
+	// Given
+	var userMock userRepositoryMock
+	userMock.On("Close").Return(user.ErrorDestinyUserNotFound).Once()
+	service := New(&userMock, nil, currencies, nil, nil, nil, nil)
+
+	// When
+	err := service.CloseUser(context.Background(), "user")
+
+	// Then
+	require.Equal(t, user.ErrorDestinyUserNotFound, err)
+	userMock.AssertExpectations(t)
+}
+
+type withdrawalStoreMock struct {
+	mock.Mock
+}
+
+func (w *withdrawalStoreMock) Create(ctx context.Context, wd withdrawal.Withdrawal) (int64, error) {
+	args := w.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (w *withdrawalStoreMock) Get(ctx context.Context, alias string, id int64) (withdrawal.Withdrawal, error) {
+	args := w.Called()
+	return args.Get(0).(withdrawal.Withdrawal), args.Error(1)
+}
+
+func (w *withdrawalStoreMock) List(ctx context.Context, alias string) ([]withdrawal.Withdrawal, error) {
+	args := w.Called()
+	return args.Get(0).([]withdrawal.Withdrawal), args.Error(1)
+}
+
+func (w *withdrawalStoreMock) ListByStatus(ctx context.Context, status withdrawal.Status, since time.Time) ([]withdrawal.Withdrawal, error) {
+	args := w.Called()
+	return args.Get(0).([]withdrawal.Withdrawal), args.Error(1)
+}
+
+func (w *withdrawalStoreMock) UpdateStatus(ctx context.Context, wd withdrawal.Withdrawal, from withdrawal.Status) error {
+	args := w.Called()
+	return args.Error(0)
+}
+
 type userRepositoryMock struct {
 	mock.Mock
 }
@@ -167,9 +748,30 @@ func (u *userRepositoryMock) IsValidCredential(ctx context.Context, alias, passw
 	return args.Bool(0), args.Error(1)
 }
 
-func (m *movementRepositoryMock) Save(ctx context.Context, movement movement.Movement) error {
+func (u *userRepositoryMock) Get(ctx context.Context, alias string) (user.Profile, error) {
+	args := u.Called()
+	return args.Get(0).(user.Profile), args.Error(1)
+}
+
+func (u *userRepositoryMock) Update(ctx context.Context, alias string, update user.ProfileUpdate) (user.Profile, error) {
+	args := u.Called()
+	return args.Get(0).(user.Profile), args.Error(1)
+}
+
+// Close runs the check like the repository does once the user is locked
+func (u *userRepositoryMock) Close(ctx context.Context, alias string, at time.Time, check func(ctx context.Context) error) error {
+	args := u.Called()
+	if err := args.Error(0); err != nil {
+		return err
+	}
+
+	return check(ctx)
+}
+
+// Save returns the movement it receives, like the repository does without the id and the balance
+func (m *movementRepositoryMock) Save(ctx context.Context, movement movement.Movement) (movement.Movement, error) {
 	args := m.Called()
-	return args.Error(0)
+	return movement, args.Error(0)
 }
 
 func (m *movementRepositoryMock) InitSave(ctx context.Context, movement movement.Movement) error {
@@ -177,17 +779,68 @@ func (m *movementRepositoryMock) InitSave(ctx context.Context, movement movement
 	return args.Error(0)
 }
 
-func (m *movementRepositoryMock) GetHistory(ctx context.Context, alias string) (movement.AccountHistory, error) {
+func (m *movementRepositoryMock) GetAccountExtractAt(ctx context.Context, alias string, at time.Time) (movement.AccountBalanceAt, error) {
+	args := m.Called()
+	return args.Get(0).(movement.AccountBalanceAt), args.Error(1)
+}
+
+func (m *movementRepositoryMock) GetHistory(ctx context.Context, alias string, filter movement.HistoryFilter) (movement.History, error) {
 	args := m.Called()
-	return args.Get(0).(movement.AccountHistory), args.Error(1)
+	return args.Get(0).(movement.History), args.Error(1)
+}
+
+func (m *movementRepositoryMock) ExportHistory(ctx context.Context, alias string, filter movement.HistoryFilter, fn func(movement.Row) error) error {
+	args := m.Called()
+	for _, row := range args.Get(0).([]movement.Row) {
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
 }
 
-func (m *movementRepositoryMock) GetFunds(ctx context.Context, currencyName, alias string) (float64, error) {
+func (m *movementRepositoryMock) GetBalanceAt(ctx context.Context, alias, currencyName string, at time.Time) (money.Amount, error) {
 	args := m.Called()
-	return args.Get(0).(float64), args.Error(1)
+	return args.Get(0).(money.Amount), args.Error(1)
+}
+
+func (m *movementRepositoryMock) GetFunds(ctx context.Context, currencyName, alias string) (money.Amount, error) {
+	args := m.Called()
+	return args.Get(0).(money.Amount), args.Error(1)
 }
 
 func (m *movementRepositoryMock) GetAccountExtract(ctx context.Context, alias string) (movement.AccountBalance, error) {
 	args := m.Called()
 	return args.Get(0).(movement.AccountBalance), args.Error(1)
 }
+
+func (m *movementRepositoryMock) CreateHold(ctx context.Context, h movement.Hold) (movement.Hold, error) {
+	args := m.Called()
+	return h, args.Error(0)
+}
+
+func (m *movementRepositoryMock) GetHold(ctx context.Context, alias string, id int64) (movement.Hold, error) {
+	args := m.Called()
+	return args.Get(0).(movement.Hold), args.Error(1)
+}
+
+// CaptureHold returns the movement it receives, like Save
+func (m *movementRepositoryMock) CaptureHold(ctx context.Context, id int64, movement movement.Movement) (movement.Movement, error) {
+	args := m.Called()
+	return movement, args.Error(0)
+}
+
+func (m *movementRepositoryMock) ReleaseHold(ctx context.Context, alias string, id int64) (movement.Hold, error) {
+	args := m.Called()
+	return args.Get(0).(movement.Hold), args.Error(1)
+}
+
+func (m *movementRepositoryMock) Refund(ctx context.Context, alias string, id int64, amount money.Amount) (movement.Movement, error) {
+	args := m.Called()
+	return args.Get(0).(movement.Movement), args.Error(1)
+}
+
+func (m *movementRepositoryMock) GetMovement(ctx context.Context, alias string, id int64) (movement.Movement, error) {
+	args := m.Called()
+	return args.Get(0).(movement.Movement), args.Error(1)
+}
@@ -0,0 +1,106 @@
+package session
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memory struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+// NewMemory creates a Store that keeps the sessions in memory, useful for tests
+func NewMemory() *memory {
+	return &memory{sessions: make(map[string]Session)}
+}
+
+func (m *memory) Create(ctx context.Context, s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[s.ID] = s
+	return nil
+}
+
+func (m *memory) Get(ctx context.Context, id string) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return Session{}, ErrorNotFound
+	}
+
+	return s, nil
+}
+
+func (m *memory) Touch(ctx context.Context, id string, lastSeenAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[id]; ok {
+		s.LastSeenAt = lastSeenAt
+		m.sessions[id] = s
+	}
+
+	return nil
+}
+
+func (m *memory) List(ctx context.Context, alias string) ([]Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sessions []Session
+	for _, s := range m.sessions {
+		if s.Alias == alias {
+			sessions = append(sessions, s)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+func (m *memory) Revoke(ctx context.Context, alias, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[id]; !ok || s.Alias != alias {
+		return ErrorNotFound
+	}
+
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *memory) RevokeAll(ctx context.Context, alias string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, s := range m.sessions {
+		if s.Alias == alias {
+			delete(m.sessions, id)
+		}
+	}
+
+	return nil
+}
+
+func (m *memory) DeleteExpired(ctx context.Context, now time.Time, idleTimeout, absoluteTimeout time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, s := range m.sessions {
+		if s.Expired(now, idleTimeout, absoluteTimeout) {
+			delete(m.sessions, id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+type repository struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *repository {
+	return &repository{db: db}
+}
+
+// Create inserts a new session
+func (r repository) Create(ctx context.Context, s Session) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO sessions(id,alias,created_at,last_seen_at,user_agent,ip) VALUES(?,?,?,?,?,?);",
+		s.ID, s.Alias, s.CreatedAt, s.LastSeenAt, s.UserAgent, s.IP)
+
+	return err
+}
+
+// Get returns the session with the given id
+func (r repository) Get(ctx context.Context, id string) (Session, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT id,alias,created_at,last_seen_at,user_agent,ip FROM sessions WHERE id = ?;", id)
+
+	var s Session
+	if err := row.Scan(&s.ID, &s.Alias, &s.CreatedAt, &s.LastSeenAt, &s.UserAgent, &s.IP); err != nil {
+		if err == sql.ErrNoRows {
+			return Session{}, ErrorNotFound
+		}
+
+		return Session{}, err
+	}
+
+	return s, nil
+}
+
+// Touch updates the last time the session was used
+func (r repository) Touch(ctx context.Context, id string, lastSeenAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE sessions SET last_seen_at = ? WHERE id = ?;", lastSeenAt, id)
+
+	return err
+}
+
+// List returns the sessions of a user, the most recently used first
+func (r repository) List(ctx context.Context, alias string) ([]Session, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id,alias,created_at,last_seen_at,user_agent,ip FROM sessions "+
+		"WHERE alias = ? ORDER BY last_seen_at DESC;", alias)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		if err = rows.Scan(&s.ID, &s.Alias, &s.CreatedAt, &s.LastSeenAt, &s.UserAgent, &s.IP); err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// Revoke deletes a session of the user, returns ErrorNotFound if the user does not own it
+func (r repository) Revoke(ctx context.Context, alias, id string) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM sessions WHERE id = ? AND alias = ?;", id, alias)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrorNotFound
+	}
+
+	return nil
+}
+
+// RevokeAll deletes all the sessions of the user
+func (r repository) RevokeAll(ctx context.Context, alias string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM sessions WHERE alias = ?;", alias)
+
+	return err
+}
+
+// DeleteExpired deletes the sessions not used for longer than the idle timeout or created before the absolute
+// timeout, a zero timeout is not enforced
+func (r repository) DeleteExpired(ctx context.Context, now time.Time, idleTimeout, absoluteTimeout time.Duration) error {
+	var conditions []string
+	var args []interface{}
+	if idleTimeout > 0 {
+		conditions = append(conditions, "last_seen_at < ?")
+		args = append(args, now.Add(-idleTimeout))
+	}
+	if absoluteTimeout > 0 {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, now.Add(-absoluteTimeout))
+	}
+
+	if len(conditions) == 0 {
+		return nil
+	}
+
+	_, err := r.db.ExecContext(ctx, "DELETE FROM sessions WHERE "+strings.Join(conditions, " OR ")+";", args...)
+
+	return err
+}
@@ -0,0 +1,190 @@
+package session
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+var columns = []string{"id", "alias", "created_at", "last_seen_at", "user_agent", "ip"}
+
+func TestCreate_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		require.NoError(t, err)
+	}
+	repository := New(db)
+	defer db.Close()
+
+	now := time.Now()
+	input := Session{ID: "id", Alias: "user", CreatedAt: now, LastSeenAt: now, UserAgent: "agent", IP: "127.0.0.1"}
+
+	// When
+	mock.ExpectExec("INSERT INTO sessions(id,alias,created_at,last_seen_at,user_agent,ip) VALUES(?,?,?,?,?,?);").
+		WithArgs(input.ID, input.Alias, input.CreatedAt, input.LastSeenAt, input.UserAgent, input.IP).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// then
+	err = repository.Create(context.Background(), input)
+	require.NoError(t, err)
+}
+
+func TestGet_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		require.NoError(t, err)
+	}
+	repository := New(db)
+	defer db.Close()
+
+	now := time.Now()
+
+	// When
+	mock.ExpectQuery("SELECT id,alias,created_at,last_seen_at,user_agent,ip FROM sessions WHERE id = ?;").
+		WithArgs("id").WillReturnRows(sqlmock.NewRows(columns).AddRow("id", "user", now, now, "agent", "127.0.0.1"))
+
+	// then
+	result, err := repository.Get(context.Background(), "id")
+	require.NoError(t, err)
+	require.Equal(t, Session{ID: "id", Alias: "user", CreatedAt: now, LastSeenAt: now, UserAgent: "agent", IP: "127.0.0.1"}, result)
+}
+
+func TestGet_NotFound(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		require.NoError(t, err)
+	}
+	repository := New(db)
+	defer db.Close()
+
+	// When
+	mock.ExpectQuery("SELECT id,alias,created_at,last_seen_at,user_agent,ip FROM sessions WHERE id = ?;").
+		WithArgs("id").WillReturnRows(sqlmock.NewRows(columns))
+
+	// then
+	_, err = repository.Get(context.Background(), "id")
+	require.EqualError(t, err, ErrorNotFound.Error())
+}
+
+func TestList_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		require.NoError(t, err)
+	}
+	repository := New(db)
+	defer db.Close()
+
+	now := time.Now()
+
+	// When
+	mock.ExpectQuery("SELECT id,alias,created_at,last_seen_at,user_agent,ip FROM sessions WHERE alias = ? ORDER BY last_seen_at DESC;").
+		WithArgs("user").WillReturnRows(sqlmock.NewRows(columns).
+		AddRow("id1", "user", now, now, "agent", "127.0.0.1").
+		AddRow("id2", "user", now, now, "agent", "127.0.0.1"))
+
+	// then
+	result, err := repository.List(context.Background(), "user")
+	require.NoError(t, err)
+	require.Len(t, result, 2)
+}
+
+func TestRevoke(t *testing.T) {
+	tt := []struct {
+		TestName      string
+		Affected      int64
+		ExpectedError error
+	}{
+		{"Ok", 1, nil},
+		{"NotFound", 0, ErrorNotFound},
+	}
+
+	for _, tc := range tt {
+		// Given
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		repository := New(db)
+
+		// When
+		mock.ExpectExec("DELETE FROM sessions WHERE id = ? AND alias = ?;").
+			WithArgs("id", "user").WillReturnResult(sqlmock.NewResult(0, tc.Affected))
+
+		// Then
+		err = repository.Revoke(context.Background(), "user", "id")
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		db.Close()
+	}
+}
+
+func TestRevokeAll_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		require.NoError(t, err)
+	}
+	repository := New(db)
+	defer db.Close()
+
+	// When
+	mock.ExpectExec("DELETE FROM sessions WHERE alias = ?;").
+		WithArgs("user").WillReturnResult(sqlmock.NewResult(0, 2))
+
+	// then
+	err = repository.RevokeAll(context.Background(), "user")
+	require.NoError(t, err)
+}
+
+func TestDeleteExpired(t *testing.T) {
+	tt := []struct {
+		TestName                     string
+		IdleTimeout, AbsoluteTimeout time.Duration
+		ExpectedQuery                string
+		ExpectedArgs                 []driver.Value
+	}{
+		{"Both", 30 * time.Minute, 24 * time.Hour, "DELETE FROM sessions WHERE last_seen_at < ? OR created_at < ?;",
+			[]driver.Value{time.Date(2022, 3, 1, 9, 30, 0, 0, time.UTC), time.Date(2022, 2, 28, 10, 0, 0, 0, time.UTC)}},
+		{"Idle", 30 * time.Minute, 0, "DELETE FROM sessions WHERE last_seen_at < ?;",
+			[]driver.Value{time.Date(2022, 3, 1, 9, 30, 0, 0, time.UTC)}},
+		{"Absolute", 0, 24 * time.Hour, "DELETE FROM sessions WHERE created_at < ?;",
+			[]driver.Value{time.Date(2022, 2, 28, 10, 0, 0, 0, time.UTC)}},
+		{"None", 0, 0, "", nil},
+	}
+
+	for _, tc := range tt {
+		// Given
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		repository := New(db)
+		now := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+		// When
+		if tc.ExpectedQuery != "" {
+			mock.ExpectExec(tc.ExpectedQuery).WithArgs(tc.ExpectedArgs...).WillReturnResult(sqlmock.NewResult(0, 3))
+		}
+
+		// Then
+		err = repository.DeleteExpired(context.Background(), now, tc.IdleTimeout, tc.AbsoluteTimeout)
+		require.NoError(t, err, tc.TestName)
+		require.NoError(t, mock.ExpectationsWereMet(), tc.TestName)
+		db.Close()
+	}
+}
+
+func TestSession_Expired(t *testing.T) {
+	// Given
+	now := time.Now()
+	s := Session{CreatedAt: now.Add(-3 * time.Hour), LastSeenAt: now.Add(-10 * time.Minute)}
+
+	// Then
+	require.False(t, s.Expired(now, 30*time.Minute, 0))
+	require.True(t, s.Expired(now, 5*time.Minute, 0))
+	require.True(t, s.Expired(now, 30*time.Minute, 2*time.Hour))
+	require.False(t, s.Expired(now, 0, 0))
+}
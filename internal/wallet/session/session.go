@@ -0,0 +1,40 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrorNotFound = errors.New("session: not found")
+
+// Store keeps the user sessions on the server side so they can be listed and revoked
+type Store interface {
+	Create(ctx context.Context, s Session) error
+	Get(ctx context.Context, id string) (Session, error)
+	Touch(ctx context.Context, id string, lastSeenAt time.Time) error
+	List(ctx context.Context, alias string) ([]Session, error)
+	Revoke(ctx context.Context, alias, id string) error
+	RevokeAll(ctx context.Context, alias string) error
+	// DeleteExpired deletes the sessions expired at the given time, like Session.Expired
+	DeleteExpired(ctx context.Context, now time.Time, idleTimeout, absoluteTimeout time.Duration) error
+}
+
+type Session struct {
+	ID         string    `json:"id"`
+	Alias      string    `json:"-"`
+	CreatedAt  time.Time `json:"createdat"`
+	LastSeenAt time.Time `json:"lastseenat"`
+	UserAgent  string    `json:"useragent"`
+	IP         string    `json:"ip"`
+}
+
+// Expired returns true if the session was not used for longer than the idle timeout
+// or was created before the absolute timeout, a zero timeout is not enforced
+func (s Session) Expired(now time.Time, idleTimeout, absoluteTimeout time.Duration) bool {
+	if idleTimeout > 0 && now.Sub(s.LastSeenAt) > idleTimeout {
+		return true
+	}
+
+	return absoluteTimeout > 0 && now.Sub(s.CreatedAt) > absoluteTimeout
+}
@@ -0,0 +1,117 @@
+package statement
+
+import (
+	"context"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+)
+
+// closeDelay leaves time to the movements saved at the end of a month to be committed before the month is closed
+const closeDelay = time.Minute
+
+// Generator builds the statements of the users from their movements
+type Generator struct {
+	store      Store
+	movements  movement.Repository
+	currencies *movement.Registry
+	now        func() time.Time
+}
+
+func NewGenerator(store Store, movements movement.Repository, currencies *movement.Registry) *Generator {
+	return &Generator{store: store, movements: movements, currencies: currencies, now: time.Now}
+}
+
+// Get returns the statements of the user for the month of the given time, one per currency. The statements of a
+// closed month are saved the first time they are requested and the saved ones are returned from then on
+func (g *Generator) Get(ctx context.Context, alias string, month time.Time) ([]Statement, error) {
+	start := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	now := g.now()
+	if !start.Before(now) {
+		return nil, ErrorMonthNotStarted
+	}
+
+	closed := !now.Before(end.Add(closeDelay))
+	if closed {
+		saved, err := g.store.List(ctx, alias, start.Format(MonthLayout))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(saved) > 0 {
+			return saved, nil
+		}
+	}
+
+	statements := make([]Statement, 0, len(g.currencies.All()))
+	for _, c := range g.currencies.All() {
+		s, err := g.generate(ctx, alias, c, start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		s.Closed = closed
+		s.CreatedAt = now.Truncate(time.Second)
+		statements = append(statements, s)
+	}
+
+	if !closed {
+		return statements, nil
+	}
+
+	// a concurrent request saved the month first, its statements are the ones kept
+	if err := g.store.Save(ctx, statements); err != nil {
+		if err == ErrorAlreadySaved {
+			return g.store.List(ctx, alias, start.Format(MonthLayout))
+		}
+		return nil, err
+	}
+
+	return statements, nil
+}
+
+// generate builds the statement of the account from its movements between start and end
+func (g *Generator) generate(ctx context.Context, alias string, c movement.Currency, start, end time.Time) (Statement, error) {
+	opening, err := g.movements.GetBalanceAt(ctx, alias, c.Code, start)
+	if err != nil {
+		return Statement{}, err
+	}
+
+	s := Statement{
+		Alias:          alias,
+		Month:          start.Format(MonthLayout),
+		CurrencyName:   c.Code,
+		OpeningBalance: opening,
+		TotalIn:        money.Zero(c.Code, c.Digits),
+		TotalOut:       money.Zero(c.Code, c.Digits),
+		Movements:      []movement.Row{},
+	}
+
+	filter := movement.HistoryFilter{CurrencyName: c.Code, From: start, To: end, Order: movement.OrderAsc}
+	err = g.movements.ExportHistory(ctx, alias, filter, func(row movement.Row) error {
+		var err error
+		if row.SignedAmount().Sign() < 0 {
+			s.TotalOut, err = s.TotalOut.Add(row.Amount)
+		} else {
+			s.TotalIn, err = s.TotalIn.Add(row.Amount)
+		}
+
+		s.Movements = append(s.Movements, row)
+		return err
+	})
+	if err != nil {
+		return Statement{}, err
+	}
+
+	if s.ClosingBalance, err = s.OpeningBalance.Add(s.TotalIn); err != nil {
+		return Statement{}, err
+	}
+
+	if s.ClosingBalance, err = s.ClosingBalance.Sub(s.TotalOut); err != nil {
+		return Statement{}, err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,133 @@
+package statement
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+	"github.com/stretchr/testify/require"
+)
+
+var testCurrencies = func() *movement.Registry {
+	registry, err := movement.NewRegistry([]movement.Currency{
+		{Code: movement.ARS, Name: "Peso", Digits: 2, Enabled: true},
+		{Code: movement.BTC, Name: "Bitcoin", Digits: 8, Enabled: true},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return registry
+}()
+
+// ledger returns the same movements for every month and counts the reads
+type ledger struct {
+	movement.Repository
+	rows  []movement.Row
+	reads int
+}
+
+func (l *ledger) GetBalanceAt(ctx context.Context, alias, currencyName string, at time.Time) (money.Amount, error) {
+	if currencyName == movement.ARS {
+		return testCurrencies.Amount(money.MustParse("100"), movement.ARS)
+	}
+
+	return testCurrencies.Zero(currencyName), nil
+}
+
+func (l *ledger) ExportHistory(ctx context.Context, alias string, filter movement.HistoryFilter, fn func(movement.Row) error) error {
+	l.reads++
+	for _, row := range l.rows {
+		if row.CurrencyName == filter.CurrencyName {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func TestGenerator_Get(t *testing.T) {
+	march := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	tt := []struct {
+		TestName       string
+		Now            time.Time
+		ExpectedClosed bool
+		ExpectedError  error
+	}{
+		{"ClosedMonth", time.Date(2022, 4, 2, 0, 0, 0, 0, time.UTC), true, nil},
+		// the movements of the end of the month may still be saving
+		{"JustEnded", time.Date(2022, 4, 1, 0, 0, 30, 0, time.UTC), false, nil},
+		{"CurrentMonth", time.Date(2022, 3, 15, 0, 0, 0, 0, time.UTC), false, nil},
+		{"NotStarted", time.Date(2022, 2, 28, 0, 0, 0, 0, time.UTC), false, ErrorMonthNotStarted},
+	}
+
+	for _, tc := range tt {
+		// Given
+		movements := &ledger{rows: []movement.Row{
+			{ID: 2, CurrencyName: movement.ARS, Type: movement.ReceiveMov, Amount: money.MustParse("50.25")},
+			{ID: 3, CurrencyName: movement.ARS, Type: movement.SendMov, Amount: money.MustParse("20")},
+			{ID: 3, CurrencyName: movement.ARS, Type: movement.FeeMov, Amount: money.MustParse("0.25")},
+		}}
+		store := NewMemory()
+		generator := NewGenerator(store, movements, testCurrencies)
+		generator.now = func() time.Time { return tc.Now }
+
+		// When
+		statements, err := generator.Get(context.Background(), "user", march.Add(10*24*time.Hour))
+
+		// Then
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		if tc.ExpectedError != nil {
+			continue
+		}
+
+		require.Len(t, statements, 2, tc.TestName)
+		ars := statements[0]
+		require.Equal(t, "2022-03", ars.Month, tc.TestName)
+		require.Equal(t, movement.ARS, ars.CurrencyName, tc.TestName)
+		require.Equal(t, "100.00", ars.OpeningBalance.String(), tc.TestName)
+		require.Equal(t, "50.25", ars.TotalIn.String(), tc.TestName)
+		require.Equal(t, "20.25", ars.TotalOut.String(), tc.TestName)
+		require.Equal(t, "130.00", ars.ClosingBalance.String(), tc.TestName)
+		require.Len(t, ars.Movements, 3, tc.TestName)
+		require.Equal(t, "0.00000000", statements[1].ClosingBalance.String(), tc.TestName)
+		require.Equal(t, tc.ExpectedClosed, ars.Closed, tc.TestName)
+
+		saved, err := store.List(context.Background(), "user", "2022-03")
+		require.NoError(t, err, tc.TestName)
+		if tc.ExpectedClosed {
+			require.Len(t, saved, 2, tc.TestName)
+		} else {
+			require.Empty(t, saved, tc.TestName)
+		}
+	}
+}
+
+func TestGenerator_Get_When_MonthWasSaved_Then_ReturnsTheSavedStatements(t *testing.T) {
+	// Given
+	movements := &ledger{rows: []movement.Row{
+		{ID: 2, CurrencyName: movement.ARS, Type: movement.ReceiveMov, Amount: money.MustParse("50")},
+	}}
+	generator := NewGenerator(NewMemory(), movements, testCurrencies)
+	generator.now = func() time.Time { return time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC) }
+	month := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	first, err := generator.Get(context.Background(), "user", month)
+	require.NoError(t, err)
+
+	// When
+	// a movement saved late does not change the closed month
+	movements.rows = append(movements.rows, movement.Row{ID: 3, CurrencyName: movement.ARS, Type: movement.DepositMov,
+		Amount: money.MustParse("10")})
+	reads := movements.reads
+	second, err := generator.Get(context.Background(), "user", month)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, reads, movements.reads)
+	require.Equal(t, first[0].ClosingBalance, second[0].ClosingBalance)
+	require.Len(t, second[0].Movements, 1)
+}
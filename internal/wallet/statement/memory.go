@@ -0,0 +1,50 @@
+package statement
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+type memory struct {
+	mu         sync.Mutex
+	statements map[string][]Statement
+	lastID     int64
+}
+
+// NewMemory creates a Store that keeps the statements in memory, useful for tests
+func NewMemory() *memory {
+	return &memory{statements: make(map[string][]Statement)}
+}
+
+func (m *memory) List(ctx context.Context, alias, month string) ([]Statement, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Statement(nil), m.statements[alias+" "+month]...), nil
+}
+
+func (m *memory) Save(ctx context.Context, statements []Statement) error {
+	if len(statements) == 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := statements[0].Alias + " " + statements[0].Month
+	if len(m.statements[key]) > 0 {
+		return ErrorAlreadySaved
+	}
+
+	saved := make([]Statement, 0, len(statements))
+	for _, s := range statements {
+		m.lastID++
+		s.ID = m.lastID
+		saved = append(saved, s)
+	}
+
+	sort.Slice(saved, func(i, j int) bool { return saved[i].CurrencyName < saved[j].CurrencyName })
+	m.statements[key] = saved
+	return nil
+}
@@ -0,0 +1,169 @@
+package statement
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/spolia/wallet-api/internal/wallet/money"
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+)
+
+// mysqlDuplicateEntry is returned when a row breaks a unique index
+const mysqlDuplicateEntry = 1062
+
+type repository struct {
+	db         *sql.DB
+	currencies *movement.Registry
+}
+
+func New(db *sql.DB, currencies *movement.Registry) *repository {
+	return &repository{db: db, currencies: currencies}
+}
+
+// List returns the saved statements of the user for the month
+func (r repository) List(ctx context.Context, alias, month string) ([]Statement, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id,currency_name,opening_balance,total_in,total_out,closing_balance,created_at "+
+		"FROM statements WHERE alias = ? AND month = ? ORDER BY currency_name;", alias, month)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statements []Statement
+	positions := make(map[int64]int)
+	var ids []interface{}
+	for rows.Next() {
+		s := Statement{Alias: alias, Month: month, Closed: true, Movements: []movement.Row{}}
+		var opening, in, out, closing string
+		if err = rows.Scan(&s.ID, &s.CurrencyName, &opening, &in, &out, &closing, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		if s.OpeningBalance, err = r.bind(opening, s.CurrencyName); err != nil {
+			return nil, err
+		}
+		if s.TotalIn, err = r.bind(in, s.CurrencyName); err != nil {
+			return nil, err
+		}
+		if s.TotalOut, err = r.bind(out, s.CurrencyName); err != nil {
+			return nil, err
+		}
+		if s.ClosingBalance, err = r.bind(closing, s.CurrencyName); err != nil {
+			return nil, err
+		}
+
+		positions[s.ID] = len(statements)
+		ids = append(ids, s.ID)
+		statements = append(statements, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	lines, err := r.db.QueryContext(ctx, "SELECT statement_id,movement_id,entry_id,mov_type,interaction_alias,amount,total_amount,"+
+		"refund_of,date_created FROM statement_lines WHERE statement_id IN (?"+strings.Repeat(",?", len(ids)-1)+")"+
+		" ORDER BY statement_id,line;",
+		ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer lines.Close()
+
+	for lines.Next() {
+		var statementID int64
+		var movementID, entryID, refundOf sql.NullInt64
+		var amount, totalAmount string
+		var row movement.Row
+		err = lines.Scan(&statementID, &movementID, &entryID, &row.Type, &row.InteractionAlias, &amount, &totalAmount, &refundOf,
+			&row.DateCreated)
+		if err != nil {
+			return nil, err
+		}
+
+		s := &statements[positions[statementID]]
+		row.MovementID = movementID.Int64
+		row.ID = entryID.Int64
+		row.RefundOf = refundOf.Int64
+		row.CurrencyName = s.CurrencyName
+		if row.Amount, err = r.bind(amount, s.CurrencyName); err != nil {
+			return nil, err
+		}
+		if row.TotalAmount, err = r.bind(totalAmount, s.CurrencyName); err != nil {
+			return nil, err
+		}
+
+		s.Movements = append(s.Movements, row)
+	}
+
+	return statements, lines.Err()
+}
+
+// Save inserts the statements and their movements in one transaction
+func (r repository) Save(ctx context.Context, statements []Statement) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statements {
+		result, err := tx.ExecContext(ctx, "INSERT INTO statements(alias,month,currency_name,opening_balance,total_in,total_out,"+
+			"closing_balance,created_at) VALUES(?,?,?,?,?,?,?,?);",
+			s.Alias, s.Month, s.CurrencyName, s.OpeningBalance, s.TotalIn, s.TotalOut, s.ClosingBalance, s.CreatedAt)
+		if err != nil {
+			tx.Rollback()
+			if v, ok := err.(*mysql.MySQLError); ok && v.Number == mysqlDuplicateEntry {
+				return ErrorAlreadySaved
+			}
+			return err
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		for i, row := range s.Movements {
+			var entryID, refundOf sql.NullInt64
+			if row.ID != 0 {
+				entryID = sql.NullInt64{Int64: row.ID, Valid: true}
+			}
+			if row.RefundOf != 0 {
+				refundOf = sql.NullInt64{Int64: row.RefundOf, Valid: true}
+			}
+
+			_, err = tx.ExecContext(ctx, "INSERT INTO statement_lines(statement_id,line,movement_id,entry_id,mov_type,"+
+				"interaction_alias,amount,total_amount,refund_of,date_created) VALUES(?,?,?,?,?,?,?,?,?,?);",
+				id, i, row.MovementID, entryID, row.Type, row.InteractionAlias, row.Amount, row.TotalAmount, refundOf, row.DateCreated)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// bind reads a stored amount with the decimals of its currency
+func (r repository) bind(value, currencyName string) (money.Amount, error) {
+	currency, ok := r.currencies.Get(currencyName)
+	if !ok {
+		return money.Amount{}, fmt.Errorf("statement: unknown currency %q in the statements table", currencyName)
+	}
+
+	amount := money.Zero(currency.Code, currency.Digits)
+	if err := amount.Scan(value); err != nil {
+		return money.Amount{}, err
+	}
+
+	return amount, nil
+}
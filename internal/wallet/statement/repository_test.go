@@ -0,0 +1,114 @@
+package statement
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/spolia/wallet-api/internal/wallet/money"
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+	"github.com/stretchr/testify/require"
+)
+
+const saveStatementQuery = "INSERT INTO statements(alias,month,currency_name,opening_balance,total_in,total_out,closing_balance," +
+	"created_at) VALUES(?,?,?,?,?,?,?,?);"
+
+func TestList_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	repository := New(db, testCurrencies)
+	created := time.Date(2022, 4, 1, 10, 0, 0, 0, time.UTC)
+
+	// When
+	mock.ExpectQuery("SELECT id,currency_name,opening_balance,total_in,total_out,closing_balance,created_at FROM statements "+
+		"WHERE alias = ? AND month = ? ORDER BY currency_name;").WithArgs("user", "2022-03").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "currency_name", "opening_balance", "total_in", "total_out", "closing_balance",
+			"created_at"}).
+			AddRow(7, movement.ARS, "100.000000000000000000", "50.000000000000000000", "20.250000000000000000",
+				"129.750000000000000000", created).
+			AddRow(8, movement.BTC, "0.000000000000000000", "0.000000000000000000", "0.000000000000000000",
+				"0.000000000000000000", created))
+	mock.ExpectQuery("SELECT statement_id,movement_id,entry_id,mov_type,interaction_alias,amount,total_amount,refund_of,"+
+		"date_created FROM statement_lines WHERE statement_id IN (?,?) ORDER BY statement_id,line;").WithArgs(7, 8).
+		WillReturnRows(sqlmock.NewRows([]string{"statement_id", "movement_id", "entry_id", "mov_type", "interaction_alias",
+			"amount", "total_amount", "refund_of", "date_created"}).
+			AddRow(7, 11, 2, movement.ReceiveMov, "otheruser", "50.000000000000000000", "150.000000000000000000", nil, created).
+			AddRow(7, 14, 3, movement.SendMov, "otheruser", "20.000000000000000000", "130.000000000000000000", 2, created))
+
+	// Then
+	statements, err := repository.List(context.Background(), "user", "2022-03")
+	require.NoError(t, err)
+	require.Len(t, statements, 2)
+	require.True(t, statements[0].Closed)
+	require.Equal(t, "129.75", statements[0].ClosingBalance.String())
+	require.Len(t, statements[0].Movements, 2)
+	require.Equal(t, int64(11), statements[0].Movements[0].MovementID)
+	require.Equal(t, int64(14), statements[0].Movements[1].MovementID)
+	require.Equal(t, int64(3), statements[0].Movements[1].ID)
+	require.Equal(t, int64(2), statements[0].Movements[1].RefundOf)
+	require.Equal(t, "130.00", statements[0].Movements[1].TotalAmount.String())
+	require.Empty(t, statements[1].Movements)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSave_When_MonthWasSaved_Then_ReturnsError(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	repository := New(db, testCurrencies)
+	created := time.Date(2022, 4, 1, 10, 0, 0, 0, time.UTC)
+	zero := testCurrencies.Zero(movement.ARS)
+	s := Statement{Alias: "user", Month: "2022-03", CurrencyName: movement.ARS, OpeningBalance: zero, TotalIn: zero,
+		TotalOut: zero, ClosingBalance: zero, CreatedAt: created}
+
+	// When
+	mock.ExpectBegin()
+	mock.ExpectExec(saveStatementQuery).WithArgs("user", "2022-03", movement.ARS, zero, zero, zero, zero, created).
+		WillReturnError(&mysql.MySQLError{Number: 1062})
+	mock.ExpectRollback()
+
+	// Then
+	err = repository.Save(context.Background(), []Statement{s})
+	require.Equal(t, ErrorAlreadySaved, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSave_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	repository := New(db, testCurrencies)
+	created := time.Date(2022, 4, 1, 10, 0, 0, 0, time.UTC)
+	zero := testCurrencies.Zero(movement.ARS)
+	amount := money.MustParse("50")
+	s := Statement{Alias: "user", Month: "2022-03", CurrencyName: movement.ARS, OpeningBalance: zero, TotalIn: amount,
+		TotalOut: zero, ClosingBalance: amount, CreatedAt: created, Movements: []movement.Row{
+			{MovementID: 4, CurrencyName: movement.ARS, Type: "init", InteractionAlias: "user", Amount: zero, TotalAmount: zero,
+				DateCreated: created},
+			{MovementID: 9, ID: 2, CurrencyName: movement.ARS, Type: movement.DepositMov, InteractionAlias: "user", Amount: amount,
+				TotalAmount: amount, DateCreated: created},
+		}}
+	lineQuery := "INSERT INTO statement_lines(statement_id,line,movement_id,entry_id,mov_type,interaction_alias,amount," +
+		"total_amount,refund_of,date_created) VALUES(?,?,?,?,?,?,?,?,?,?);"
+
+	// When
+	mock.ExpectBegin()
+	mock.ExpectExec(saveStatementQuery).WithArgs("user", "2022-03", movement.ARS, zero, amount, zero, amount, created).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	// the rows of the initialized accounts have no movement
+	mock.ExpectExec(lineQuery).WithArgs(7, 0, 4, nil, "init", "user", zero, zero, nil, created).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(lineQuery).WithArgs(7, 1, 9, 2, movement.DepositMov, "user", amount, amount, nil, created).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Then
+	require.NoError(t, repository.Save(context.Background(), []Statement{s}))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
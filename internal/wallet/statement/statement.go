@@ -0,0 +1,44 @@
+package statement
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+)
+
+// MonthLayout is the format of the month of a statement, like 2022-03
+const MonthLayout = "2006-01"
+
+var (
+	ErrorMonthNotStarted = errors.New("statement: the month has not started yet")
+	ErrorAlreadySaved    = errors.New("statement: the statements of the month were already saved")
+)
+
+// Statement is the statement of an account of the user for a calendar month (UTC): the balance at the start of the
+// month, its movements, the money that entered and left the account and the balance at the end of the month
+type Statement struct {
+	ID             int64          `json:"-"`
+	Alias          string         `json:"-"`
+	Month          string         `json:"month"`
+	CurrencyName   string         `json:"currencyname"`
+	OpeningBalance money.Amount   `json:"openingbalance"`
+	TotalIn        money.Amount   `json:"totalin"`
+	TotalOut       money.Amount   `json:"totalout"`
+	ClosingBalance money.Amount   `json:"closingbalance"`
+	Movements      []movement.Row `json:"movements"`
+	// Closed statements are saved once the month ended and never change, the statements of the current month
+	// are generated on every request
+	Closed    bool      `json:"closed"`
+	CreatedAt time.Time `json:"createdat"`
+}
+
+// Store keeps the statements of the closed months
+type Store interface {
+	// List returns the saved statements of the user for the month with their movements, sorted by currency
+	List(ctx context.Context, alias, month string) ([]Statement, error)
+	// Save saves the statements of a month of the user at once, returns ErrorAlreadySaved if the month was already saved
+	Save(ctx context.Context, statements []Statement) error
+}
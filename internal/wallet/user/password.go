@@ -0,0 +1,45 @@
+package user
+
+import (
+	"crypto/subtle"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultHashCost is the bcrypt cost used to hash new passwords.
+// Raising it is safe: stored hashes with a lower cost are upgraded on the next successful login.
+const DefaultHashCost = 12
+
+// MaxPasswordBytes is the length of the longest password, bcrypt ignores the bytes after it
+const MaxPasswordBytes = 72
+
+// hashPassword returns the bcrypt hash of the password, bcrypt generates a random salt for every call
+func hashPassword(password string, cost int) (string, error) {
+	if len(password) > MaxPasswordBytes {
+		return "", ErrorPasswordTooLong
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// comparePassword checks the password against the stored value and reports whether it has to be rehashed.
+// Values that are not bcrypt hashes are passwords stored in plain text before hashing was introduced,
+// those are accepted once and always flagged to be rehashed.
+func comparePassword(stored, password string, cost int) (ok, rehash bool) {
+	storedCost, err := bcrypt.Cost([]byte(stored))
+	if err != nil {
+		ok = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+		return ok, ok
+	}
+
+	if err = bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)); err != nil {
+		return false, false
+	}
+
+	return true, storedCost < cost
+}
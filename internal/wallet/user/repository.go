@@ -3,22 +3,36 @@ package user
 import (
 	"context"
 	"database/sql"
+	"log"
+	"strings"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type repository struct {
-	db *sql.DB
+	db       *sql.DB
+	hashCost int
+	// dummyHash is compared with the passwords of the unknown users, so they take as long as a wrong password
+	dummyHash []byte
 }
 
-func New(db *sql.DB) *repository {
-	return &repository{db: db}
+// New creates a user repository, passwords are hashed with bcrypt using the given cost
+func New(db *sql.DB, hashCost int) *repository {
+	dummyHash, _ := bcrypt.GenerateFromPassword([]byte("not the password of any user"), hashCost)
+	return &repository{db: db, hashCost: hashCost, dummyHash: dummyHash}
 }
 
-// Save inserts a new user
+// Save inserts a new user, the password is stored hashed
 func (r repository) Save(ctx context.Context, u User) error {
-	_, err := r.db.ExecContext(ctx, "INSERT INTO users(alias,first_name,last_name,email,password) VALUES(?,?,?,?,?);",
-		u.Alias, u.FirstName, u.LastName, u.Email, u.Password)
+	hash, err := hashPassword(u.Password, r.hashCost)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, "INSERT INTO users(alias,first_name,last_name,email,password) VALUES(?,?,?,?,?);",
+		u.Alias, u.FirstName, u.LastName, u.Email, hash)
 	if err != nil {
 		if v, ok := err.(*mysql.MySQLError); ok {
 			if v.Number == 1062 {
@@ -46,9 +60,9 @@ func (r repository) Delete(ctx context.Context, alias string) error {
 	return nil
 }
 
-// Exist returns true if a user with the given alias exist in the database
+// Exist returns true if a user with the given alias exist in the database and the account is not closed
 func (r repository) Exist(ctx context.Context, alias string) (bool, error) {
-	row := r.db.QueryRowContext(ctx, "SELECT alias FROM users WHERE alias = ?;", alias)
+	row := r.db.QueryRowContext(ctx, "SELECT alias FROM users WHERE alias = ? AND closed_at IS NULL;", alias)
 	if row.Err() != nil {
 		return false, row.Err()
 	}
@@ -67,24 +81,126 @@ func (r repository) Exist(ctx context.Context, alias string) (bool, error) {
 	return queryResult.Alias != "", nil
 }
 
-// IsValidCredential returns true a user with the given alias and password exist in the database
+// IsValidCredential returns true if a user with the given alias exist and the password matches the stored hash,
+// the closed accounts can not log in. Hashes made with an outdated cost are replaced by a new one
 func (r repository) IsValidCredential(ctx context.Context, alias, password string) (bool, error) {
-	row := r.db.QueryRowContext(ctx, "SELECT alias FROM users WHERE alias = ? AND password = ?;", alias, password)
+	row := r.db.QueryRowContext(ctx, "SELECT password FROM users WHERE alias = ? AND closed_at IS NULL;", alias)
 	if row.Err() != nil {
 		return false, row.Err()
 	}
 
-	var queryResult struct {
-		Alias string
-	}
-
-	if err := row.Scan(&queryResult.Alias); err != nil {
+	var stored string
+	if err := row.Scan(&stored); err != nil {
 		if err == sql.ErrNoRows {
+			// the answer takes as long as for a wrong password, so it does not tell whether the alias exists
+			bcrypt.CompareHashAndPassword(r.dummyHash, []byte(password))
 			return false, ErrorInvalidCredential
 		}
 
 		return false, err
 	}
 
-	return queryResult.Alias != "", nil
+	ok, rehash := comparePassword(stored, password, r.hashCost)
+	if !ok {
+		return false, ErrorInvalidCredential
+	}
+
+	if rehash {
+		// the login must not fail because of the upgrade, it will be retried on the next one
+		if err := r.updatePassword(ctx, alias, password); err != nil {
+			log.Printf("user: rehashing password of %s: %v", alias, err)
+		}
+	}
+
+	return true, nil
+}
+
+// Get returns the profile of the user, the closed accounts are not found
+func (r repository) Get(ctx context.Context, alias string) (Profile, error) {
+	var p Profile
+	err := r.db.QueryRowContext(ctx, "SELECT alias,first_name,last_name,email FROM users WHERE alias = ? AND closed_at IS NULL;", alias).
+		Scan(&p.Alias, &p.FirstName, &p.LastName, &p.Email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Profile{}, ErrorDestinyUserNotFound
+		}
+		return Profile{}, err
+	}
+
+	return p, nil
+}
+
+// Update changes the given fields of the profile and returns the updated profile,
+// the email can not be the one of other user
+func (r repository) Update(ctx context.Context, alias string, update ProfileUpdate) (Profile, error) {
+	var columns []string
+	var args []interface{}
+	if update.FirstName != nil {
+		columns = append(columns, "first_name = ?")
+		args = append(args, *update.FirstName)
+	}
+	if update.LastName != nil {
+		columns = append(columns, "last_name = ?")
+		args = append(args, *update.LastName)
+	}
+	if update.Email != nil {
+		columns = append(columns, "email = ?")
+		args = append(args, *update.Email)
+	}
+
+	if len(columns) > 0 {
+		args = append(args, alias)
+		_, err := r.db.ExecContext(ctx, "UPDATE users SET "+strings.Join(columns, ",")+" WHERE alias = ? AND closed_at IS NULL;", args...)
+		if err != nil {
+			if v, ok := err.(*mysql.MySQLError); ok {
+				if v.Number == 1062 {
+					return Profile{}, ErrorAlreadyExist
+				}
+			}
+
+			return Profile{}, err
+		}
+	}
+
+	return r.Get(ctx, alias)
+}
+
+// Close marks the account as closed, the user and its movements are kept so the history of the other users does
+// not change. The user is locked like in the movements, so no money can arrive to or leave the account while
+// check runs
+func (r repository) Close(ctx context.Context, alias string, at time.Time, check func(ctx context.Context) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked string
+	err = tx.QueryRowContext(ctx, "SELECT alias FROM users WHERE alias = ? AND closed_at IS NULL FOR UPDATE;", alias).Scan(&locked)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorDestinyUserNotFound
+		}
+		return err
+	}
+
+	if err = check(ctx); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, "UPDATE users SET closed_at = ? WHERE alias = ?;", at, alias); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r repository) updatePassword(ctx context.Context, alias, password string) error {
+	hash, err := hashPassword(password, r.hashCost)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, "UPDATE users SET password = ? WHERE alias = ?;", hash, alias)
+	return err
 }
@@ -2,21 +2,37 @@ package user
 
 import (
 	"context"
+	"database/sql/driver"
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// passwordHash matches a bcrypt hash of the password
+type passwordHash string
+
+func (p passwordHash) Match(v driver.Value) bool {
+	hash, ok := v.(string)
+	if !ok {
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(p)) == nil
+}
+
 func TestSave_ok(t *testing.T) {
 	// Given
 	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if err != nil {
 		require.NoError(t, err)
 	}
-	repository := New(db)
+	repository := New(db, bcrypt.MinCost)
 	defer db.Close()
 
 	input := User{
@@ -28,7 +44,7 @@ func TestSave_ok(t *testing.T) {
 	}
 	// When
 	mock.ExpectExec("INSERT INTO users(alias,first_name,last_name,email,password) VALUES(?,?,?,?,?);").
-		WithArgs(input.Alias, input.FirstName, input.LastName, input.Email, input.Password).
+		WithArgs(input.Alias, input.FirstName, input.LastName, input.Email, passwordHash(input.Password)).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// then
@@ -42,7 +58,7 @@ func TestSave_Fail(t *testing.T) {
 	if err != nil {
 		require.NoError(t, err)
 	}
-	repository := New(db)
+	repository := New(db, bcrypt.MinCost)
 	defer db.Close()
 
 	input := User{
@@ -54,7 +70,7 @@ func TestSave_Fail(t *testing.T) {
 	}
 	// When
 	mock.ExpectExec("INSERT INTO users(alias,first_name,last_name,email,password) VALUES(?,?,?,?,?);").
-		WithArgs(input.Alias, input.FirstName, input.LastName, input.Email, input.Password).WillReturnError(&mysql.MySQLError{
+		WithArgs(input.Alias, input.FirstName, input.LastName, input.Email, passwordHash(input.Password)).WillReturnError(&mysql.MySQLError{
 		Number: 1062,
 	})
 
@@ -70,7 +86,7 @@ func TestDelete_Ok(t *testing.T) {
 	if err != nil {
 		require.NoError(t, err)
 	}
-	repository := New(db)
+	repository := New(db, bcrypt.MinCost)
 	defer db.Close()
 
 	// When
@@ -88,7 +104,7 @@ func TestDelete_Fail(t *testing.T) {
 	if err != nil {
 		require.NoError(t, err)
 	}
-	repository := New(db)
+	repository := New(db, bcrypt.MinCost)
 	defer db.Close()
 
 	// When
@@ -106,11 +122,11 @@ func TestExist_Ok(t *testing.T) {
 	if err != nil {
 		require.NoError(t, err)
 	}
-	repository := New(db)
+	repository := New(db, bcrypt.MinCost)
 	defer db.Close()
 
 	// When
-	mock.ExpectQuery("SELECT alias FROM users WHERE alias = ?;").
+	mock.ExpectQuery("SELECT alias FROM users WHERE alias = ? AND closed_at IS NULL;").
 		WithArgs("user").WillReturnRows(sqlmock.NewRows([]string{"alias"}).
 		AddRow("user"))
 
@@ -119,3 +135,211 @@ func TestExist_Ok(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, exist)
 }
+
+func TestIsValidCredential(t *testing.T) {
+	current, err := bcrypt.GenerateFromPassword([]byte("1234"), bcrypt.MinCost+1)
+	require.NoError(t, err)
+	outdated, err := bcrypt.GenerateFromPassword([]byte("1234"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	tt := []struct {
+		TestName, Stored, Password    string
+		ExpectedValid, ExpectedRehash bool
+		ExpectedError                 error
+	}{
+		{"Ok", string(current), "1234", true, false, nil},
+		{"WrongPassword", string(current), "4321", false, false, ErrorInvalidCredential},
+		{"PlainTextPassword", "1234", "1234", true, true, nil},
+		{"WrongPlainTextPassword", "1234", "4321", false, false, ErrorInvalidCredential},
+		{"OutdatedCost", string(outdated), "1234", true, true, nil},
+	}
+
+	for _, tc := range tt {
+		// Given
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		repository := New(db, bcrypt.MinCost+1)
+
+		// When
+		mock.ExpectQuery("SELECT password FROM users WHERE alias = ? AND closed_at IS NULL;").
+			WithArgs("user").WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow(tc.Stored))
+		if tc.ExpectedRehash {
+			mock.ExpectExec("UPDATE users SET password = ? WHERE alias = ?;").
+				WithArgs(passwordHash(tc.Password), "user").WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+
+		// Then
+		valid, err := repository.IsValidCredential(context.Background(), "user", tc.Password)
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		require.Equal(t, tc.ExpectedValid, valid, tc.TestName)
+		require.NoError(t, mock.ExpectationsWereMet(), tc.TestName)
+		db.Close()
+	}
+}
+
+func TestSave_PasswordTooLong(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, bcrypt.MinCost)
+	defer db.Close()
+
+	// When
+	err = repository.Save(context.Background(), User{Alias: "alias", Password: strings.Repeat("a", MaxPasswordBytes+1)})
+
+	// Then
+	require.Equal(t, ErrorPasswordTooLong, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIsValidCredential_UnknownAlias(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		require.NoError(t, err)
+	}
+	repository := New(db, bcrypt.MinCost)
+	defer db.Close()
+
+	// When
+	mock.ExpectQuery("SELECT password FROM users WHERE alias = ? AND closed_at IS NULL;").
+		WithArgs("user").WillReturnRows(sqlmock.NewRows([]string{"password"}))
+
+	// then
+	valid, err := repository.IsValidCredential(context.Background(), "user", "1234")
+	require.EqualError(t, err, ErrorInvalidCredential.Error())
+	require.False(t, valid)
+}
+
+const getProfileQuery = "SELECT alias,first_name,last_name,email FROM users WHERE alias = ? AND closed_at IS NULL;"
+
+func TestGet_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, bcrypt.MinCost)
+	defer db.Close()
+
+	// When
+	mock.ExpectQuery(getProfileQuery).WithArgs("user").
+		WillReturnRows(sqlmock.NewRows([]string{"alias", "first_name", "last_name", "email"}).AddRow("user", "name", "lastname", "email"))
+
+	// Then
+	profile, err := repository.Get(context.Background(), "user")
+	require.NoError(t, err)
+	require.Equal(t, Profile{Alias: "user", FirstName: "name", LastName: "lastname", Email: "email"}, profile)
+}
+
+func TestGet_When_AccountIsClosed_Then_ReturnsNotFound(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	repository := New(db, bcrypt.MinCost)
+	defer db.Close()
+
+	// When
+	mock.ExpectQuery(getProfileQuery).WithArgs("user").
+		WillReturnRows(sqlmock.NewRows([]string{"alias", "first_name", "last_name", "email"}))
+
+	// Then
+	_, err = repository.Get(context.Background(), "user")
+	require.Equal(t, ErrorDestinyUserNotFound, err)
+}
+
+func TestUpdate(t *testing.T) {
+	name, email := "newname", "new@email"
+	tt := []struct {
+		TestName      string
+		Update        ProfileUpdate
+		Query         string
+		Args          []driver.Value
+		UpdateError   error
+		ExpectedError error
+	}{
+		{"Name", ProfileUpdate{FirstName: &name}, "UPDATE users SET first_name = ? WHERE alias = ? AND closed_at IS NULL;",
+			[]driver.Value{name, "user"}, nil, nil},
+		{"NameAndEmail", ProfileUpdate{LastName: &name, Email: &email},
+			"UPDATE users SET last_name = ?,email = ? WHERE alias = ? AND closed_at IS NULL;", []driver.Value{name, email, "user"}, nil, nil},
+		{"EmailOfOtherUser", ProfileUpdate{Email: &email}, "UPDATE users SET email = ? WHERE alias = ? AND closed_at IS NULL;",
+			[]driver.Value{email, "user"}, &mysql.MySQLError{Number: 1062}, ErrorAlreadyExist},
+		{"Nothing", ProfileUpdate{}, "", nil, nil, nil},
+	}
+
+	for _, tc := range tt {
+		// Given
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		repository := New(db, bcrypt.MinCost)
+
+		// When
+		if tc.Query != "" {
+			exec := mock.ExpectExec(tc.Query).WithArgs(tc.Args...)
+			if tc.UpdateError != nil {
+				exec.WillReturnError(tc.UpdateError)
+			} else {
+				exec.WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+		}
+		if tc.ExpectedError == nil {
+			mock.ExpectQuery(getProfileQuery).WithArgs("user").
+				WillReturnRows(sqlmock.NewRows([]string{"alias", "first_name", "last_name", "email"}).AddRow("user", name, "lastname", email))
+		}
+
+		// Then
+		profile, err := repository.Update(context.Background(), "user", tc.Update)
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		if tc.ExpectedError == nil {
+			require.Equal(t, "user", profile.Alias, tc.TestName)
+		}
+		require.NoError(t, mock.ExpectationsWereMet(), tc.TestName)
+		db.Close()
+	}
+}
+
+func TestClose(t *testing.T) {
+	tt := []struct {
+		TestName      string
+		Locked        bool
+		CheckError    error
+		ExpectedError error
+	}{
+		{"Ok", true, nil, nil},
+		{"NotFound", false, nil, ErrorDestinyUserNotFound},
+		{"CheckFails", true, ErrorBalanceNotZero, ErrorBalanceNotZero},
+	}
+
+	for _, tc := range tt {
+		// Given
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		repository := New(db, bcrypt.MinCost)
+		at := time.Date(2022, 4, 1, 10, 0, 0, 0, time.UTC)
+		var checked bool
+		check := func(ctx context.Context) error {
+			checked = true
+			return tc.CheckError
+		}
+
+		// When
+		mock.ExpectBegin()
+		lock := sqlmock.NewRows([]string{"alias"})
+		if tc.Locked {
+			lock.AddRow("user")
+		}
+		mock.ExpectQuery("SELECT alias FROM users WHERE alias = ? AND closed_at IS NULL FOR UPDATE;").WithArgs("user").WillReturnRows(lock)
+		if tc.ExpectedError == nil {
+			mock.ExpectExec("UPDATE users SET closed_at = ? WHERE alias = ?;").WithArgs(at, "user").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		} else {
+			mock.ExpectRollback()
+		}
+
+		// Then
+		err = repository.Close(context.Background(), "user", at, check)
+		require.Equal(t, tc.ExpectedError, err, tc.TestName)
+		require.Equal(t, tc.Locked, checked, tc.TestName)
+		require.NoError(t, mock.ExpectationsWereMet(), tc.TestName)
+		db.Close()
+	}
+}
@@ -3,6 +3,7 @@ package user
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 var (
@@ -10,6 +11,9 @@ var (
 	ErrorDestinyUserNotFound = errors.New("not found")
 	ErrorCreatingUser        = errors.New("creating user")
 	ErrorAlreadyExist        = errors.New("already exist")
+	ErrorBalanceNotZero      = errors.New("the balance of every currency must be zero to close the account")
+	ErrorPendingWithdrawals  = errors.New("the account has withdrawals in progress")
+	ErrorPasswordTooLong     = errors.New("the password can not be longer than 72 bytes")
 )
 
 type Repository interface {
@@ -17,13 +21,33 @@ type Repository interface {
 	Exist(ctx context.Context, alias string) (bool, error)
 	Delete(ctx context.Context, alias string) error
 	IsValidCredential(ctx context.Context, alias, password string) (bool, error)
+	Get(ctx context.Context, alias string) (Profile, error)
+	Update(ctx context.Context, alias string, update ProfileUpdate) (Profile, error)
+	// Close marks the account as closed at the given time, check runs while the user is locked and its error
+	// cancels the close
+	Close(ctx context.Context, alias string, at time.Time, check func(ctx context.Context) error) error
 }
 
 type User struct {
-	Alias           string             `json:"alias" validate:"required"`
+	Alias           string             `json:"alias" validate:"required,startsnotwith=@"`
 	FirstName       string             `json:"firstname" validate:"required"`
 	LastName        string             `json:"lastname" validate:"required"`
 	Email           string             `json:"email" validate:"required"`
 	WalletStatement map[string]float64 `json:"walletstatement"`
 	Password        string             `json:"password" validate:"required"`
 }
+
+// Profile is the data of a user that the user can read
+type Profile struct {
+	Alias     string `json:"alias"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	Email     string `json:"email"`
+}
+
+// ProfileUpdate has the fields of the profile to change, the nil ones are kept
+type ProfileUpdate struct {
+	FirstName *string `json:"firstname"`
+	LastName  *string `json:"lastname"`
+	Email     *string `json:"email"`
+}
@@ -0,0 +1,24 @@
+package withdrawal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// FakePayout pays every withdrawal at once without moving real money, the destinations that start with "fail"
+// are rejected. It is meant to run the wallet locally without a payout provider, the API only uses it when it is
+// explicitly allowed
+type FakePayout struct{}
+
+func (FakePayout) Send(ctx context.Context, w Withdrawal) (string, error) {
+	if strings.HasPrefix(w.Destination, "fail") {
+		return "", ErrorPayoutRejected
+	}
+
+	return fmt.Sprintf("fake-%d", w.ID), nil
+}
+
+func (FakePayout) Status(ctx context.Context, w Withdrawal) (Status, error) {
+	return StatusSettled, nil
+}
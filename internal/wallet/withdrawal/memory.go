@@ -0,0 +1,81 @@
+package withdrawal
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memory struct {
+	mu          sync.Mutex
+	withdrawals map[int64]Withdrawal
+	lastID      int64
+}
+
+// NewMemory creates a Store that keeps the withdrawals in memory, useful for tests
+func NewMemory() *memory {
+	return &memory{withdrawals: make(map[int64]Withdrawal)}
+}
+
+func (m *memory) Create(ctx context.Context, w Withdrawal) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastID++
+	w.ID = m.lastID
+	m.withdrawals[w.ID] = w
+	return w.ID, nil
+}
+
+func (m *memory) Get(ctx context.Context, alias string, id int64) (Withdrawal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.withdrawals[id]
+	if !ok || w.Alias != alias {
+		return Withdrawal{}, ErrorNotFound
+	}
+
+	return w, nil
+}
+
+func (m *memory) List(ctx context.Context, alias string) ([]Withdrawal, error) {
+	withdrawals := m.filter(func(w Withdrawal) bool { return w.Alias == alias })
+	sort.Slice(withdrawals, func(i, j int) bool { return withdrawals[i].ID > withdrawals[j].ID })
+	return withdrawals, nil
+}
+
+func (m *memory) ListByStatus(ctx context.Context, status Status, since time.Time) ([]Withdrawal, error) {
+	withdrawals := m.filter(func(w Withdrawal) bool { return w.Status == status && !w.UpdatedAt.Before(since) })
+	sort.Slice(withdrawals, func(i, j int) bool { return withdrawals[i].ID < withdrawals[j].ID })
+	return withdrawals, nil
+}
+
+func (m *memory) UpdateStatus(ctx context.Context, w Withdrawal, from Status) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved, ok := m.withdrawals[w.ID]
+	if !ok || saved.Status != from {
+		return ErrorNotFound
+	}
+
+	saved.Status, saved.Reference, saved.Reason, saved.UpdatedAt = w.Status, w.Reference, w.Reason, w.UpdatedAt
+	m.withdrawals[w.ID] = saved
+	return nil
+}
+
+func (m *memory) filter(match func(w Withdrawal) bool) []Withdrawal {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var withdrawals []Withdrawal
+	for _, w := range m.withdrawals {
+		if match(w) {
+			withdrawals = append(withdrawals, w)
+		}
+	}
+
+	return withdrawals
+}
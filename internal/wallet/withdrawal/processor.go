@@ -0,0 +1,196 @@
+package withdrawal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+)
+
+// ReversalWindow is how long after the settlement a payout can still be reversed by the provider
+const ReversalWindow = 7 * 24 * time.Hour
+
+// Hold returns the movement that takes the money of the withdrawal from the user until the payout ends
+func Hold(w Withdrawal) movement.Movement {
+	return movement.Movement{
+		Type:             movement.WithdrawMov,
+		Alias:            w.Alias,
+		InteractionAlias: movement.WithdrawalsAccount,
+		Amount:           w.Amount,
+		CurrencyName:     w.CurrencyName,
+	}
+}
+
+// Refund returns the movement that gives the money of the withdrawal back to the user from the account that has it
+func Refund(w Withdrawal, from string) movement.Movement {
+	return movement.Movement{
+		Type:             movement.SendMov,
+		Alias:            from,
+		InteractionAlias: w.Alias,
+		Amount:           w.Amount,
+		CurrencyName:     w.CurrencyName,
+	}
+}
+
+// settle returns the movement that pays the money of the withdrawal out of the wallet
+func settle(w Withdrawal) movement.Movement {
+	return movement.Movement{
+		Type:             movement.SendMov,
+		Alias:            movement.WithdrawalsAccount,
+		InteractionAlias: movement.ExternalAccount,
+		Amount:           w.Amount,
+		CurrencyName:     w.CurrencyName,
+	}
+}
+
+// Processor moves the withdrawals through their lifecycle with the payout provider
+type Processor struct {
+	store      Store
+	payout     Payout
+	movements  movement.Repository
+	currencies *movement.Registry
+	now        func() time.Time
+}
+
+func NewProcessor(store Store, payout Payout, movements movement.Repository, currencies *movement.Registry) *Processor {
+	return &Processor{store: store, payout: payout, movements: movements, currencies: currencies, now: time.Now}
+}
+
+// Process sends the pending withdrawals to the payout provider and updates the ones already sent: the settled
+// payouts leave the wallet and the failed or reversed ones are refunded to the user.
+// The money is moved before the status is changed and every movement has a reference of the withdrawal and its
+// new status, so a run that fails between both is completed by the next one without moving the money twice.
+// A withdrawal that fails does not stop the others, the run returns the errors of all the failed withdrawals
+// and they are retried by the next one
+func (p *Processor) Process(ctx context.Context) error {
+	steps := []struct {
+		status Status
+		since  time.Time
+		step   func(ctx context.Context, w Withdrawal) error
+	}{
+		{StatusPending, time.Time{}, p.send},
+		{StatusProcessing, time.Time{}, p.check},
+		{StatusSettled, p.now().Add(-ReversalWindow), p.check},
+	}
+
+	var errs []string
+	// a withdrawal that failed in a step is not retried by the next steps of the same run
+	failed := make(map[int64]bool)
+	for _, s := range steps {
+		withdrawals, err := p.store.ListByStatus(ctx, s.status, s.since)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("listing %s withdrawals: %v", s.status, err))
+			continue
+		}
+
+		for _, w := range withdrawals {
+			if failed[w.ID] {
+				continue
+			}
+
+			// the store does not know the decimals of the currencies
+			if w.Amount, err = p.currencies.Amount(w.Amount, w.CurrencyName); err == nil {
+				err = s.step(ctx, w)
+			}
+
+			if err != nil && err != ErrorNotFound {
+				failed[w.ID] = true
+				errs = append(errs, fmt.Sprintf("withdrawal %d: %v", w.ID, err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// send sends a pending withdrawal to the payout provider
+func (p *Processor) send(ctx context.Context, w Withdrawal) error {
+	if err := p.update(ctx, &w, StatusProcessing, StatusPending); err != nil {
+		return err
+	}
+
+	return p.pay(ctx, w)
+}
+
+// pay asks the payout provider to pay a processing withdrawal. Only a rejected payout is refunded, after any other
+// error the provider may still pay, so the withdrawal stays processing and is sent again on the next run:
+// the provider does not pay the same withdrawal twice
+func (p *Processor) pay(ctx context.Context, w Withdrawal) error {
+	reference, err := p.payout.Send(ctx, w)
+	if errors.Is(err, ErrorPayoutRejected) {
+		w.Reason = err.Error()
+		return p.refund(ctx, w, StatusProcessing, StatusFailed, movement.WithdrawalsAccount)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.Reference = reference
+	return p.update(ctx, &w, StatusProcessing, StatusProcessing)
+}
+
+// check updates the withdrawal with the status of its payout, the withdrawals without a reference were not
+// accepted by the payout provider yet and are sent again
+func (p *Processor) check(ctx context.Context, w Withdrawal) error {
+	if w.Status == StatusProcessing && w.Reference == "" {
+		return p.pay(ctx, w)
+	}
+
+	status, err := p.payout.Status(ctx, w)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case status == w.Status:
+		return nil
+	case w.Status == StatusProcessing && status == StatusSettled:
+		if err = p.post(ctx, w, StatusSettled, settle(w)); err != nil {
+			return err
+		}
+
+		return p.update(ctx, &w, StatusSettled, StatusProcessing)
+	case w.Status == StatusProcessing && status == StatusFailed:
+		w.Reason = "rejected by the payout provider"
+		return p.refund(ctx, w, StatusProcessing, StatusFailed, movement.WithdrawalsAccount)
+	case w.Status == StatusSettled && status == StatusReversed:
+		w.Reason = "reversed by the payout provider"
+		return p.refund(ctx, w, StatusSettled, StatusReversed, movement.ExternalAccount)
+	default:
+		return fmt.Errorf("unexpected payout status %s for a %s withdrawal", status, w.Status)
+	}
+}
+
+// refund gives the money back to the user and ends the withdrawal with the status
+func (p *Processor) refund(ctx context.Context, w Withdrawal, from, to Status, account string) error {
+	if err := p.post(ctx, w, to, Refund(w, account)); err != nil {
+		return err
+	}
+
+	return p.update(ctx, &w, to, from)
+}
+
+// post saves the movement that takes the withdrawal to the status, a movement saved by a previous run
+// is not saved again
+func (p *Processor) post(ctx context.Context, w Withdrawal, to Status, m movement.Movement) error {
+	m.Reference = fmt.Sprintf("withdrawal:%d:%s", w.ID, to)
+	if _, err := p.movements.Save(ctx, m); err != nil && err != movement.ErrorAlreadySaved {
+		return err
+	}
+
+	return nil
+}
+
+func (p *Processor) update(ctx context.Context, w *Withdrawal, to, from Status) error {
+	w.Status = to
+	w.UpdatedAt = p.now()
+
+	return p.store.UpdateStatus(ctx, *w, from)
+}
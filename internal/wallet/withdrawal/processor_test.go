@@ -0,0 +1,270 @@
+package withdrawal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+	"github.com/stretchr/testify/require"
+)
+
+var testCurrencies = func() *movement.Registry {
+	registry, err := movement.NewRegistry([]movement.Currency{
+		{Code: movement.ARS, Name: "Peso", Digits: 2, Enabled: true},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return registry
+}()
+
+// ledger records the saved movements, like the repository it rejects a reference saved before
+type ledger struct {
+	movement.Repository
+	saved []movement.Movement
+}
+
+func (l *ledger) Save(ctx context.Context, m movement.Movement) (movement.Movement, error) {
+	for _, saved := range l.saved {
+		if m.Reference != "" && saved.Reference == m.Reference {
+			return movement.Movement{}, movement.ErrorAlreadySaved
+		}
+	}
+
+	l.saved = append(l.saved, m)
+	return m, nil
+}
+
+// brokenStore fails the first status updates
+type brokenStore struct {
+	Store
+	failures int
+}
+
+func (s *brokenStore) UpdateStatus(ctx context.Context, w Withdrawal, from Status) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("connection lost")
+	}
+
+	return s.Store.UpdateStatus(ctx, w, from)
+}
+
+// payout returns the same status for every withdrawal
+type payout struct {
+	sendError error
+	status    Status
+}
+
+func (p payout) Send(ctx context.Context, w Withdrawal) (string, error) {
+	return "ref", p.sendError
+}
+
+func (p payout) Status(ctx context.Context, w Withdrawal) (Status, error) {
+	return p.status, nil
+}
+
+// stuckPayout fails to send one withdrawal and settles the others
+type stuckPayout struct {
+	stuck int64
+}
+
+func (p stuckPayout) Send(ctx context.Context, w Withdrawal) (string, error) {
+	if w.ID == p.stuck {
+		return "", errors.New("timeout")
+	}
+
+	return "ref", nil
+}
+
+func (p stuckPayout) Status(ctx context.Context, w Withdrawal) (Status, error) {
+	return StatusSettled, nil
+}
+
+func TestProcessor_Process(t *testing.T) {
+	tt := []struct {
+		TestName        string
+		From            Status
+		Payout          payout
+		ExpectedStatus  Status
+		ExpectedAccount string
+		ExpectedTo      string
+	}{
+		{"Settled", StatusPending, payout{status: StatusSettled}, StatusSettled, movement.WithdrawalsAccount, movement.ExternalAccount},
+		{"StillProcessing", StatusPending, payout{status: StatusProcessing}, StatusProcessing, "", ""},
+		{"Rejected", StatusPending, payout{sendError: ErrorPayoutRejected}, StatusFailed, movement.WithdrawalsAccount, "user"},
+		{"Failed", StatusProcessing, payout{status: StatusFailed}, StatusFailed, movement.WithdrawalsAccount, "user"},
+		{"Reversed", StatusSettled, payout{status: StatusReversed}, StatusReversed, movement.ExternalAccount, "user"},
+	}
+
+	for _, tc := range tt {
+		// Given
+		now := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+		store := NewMemory()
+		// the withdrawals already sent have the reference of their payout
+		var reference string
+		if tc.From != StatusPending {
+			reference = "ref"
+		}
+		id, err := store.Create(context.Background(), Withdrawal{Alias: "user", Amount: money.MustParse("100"),
+			CurrencyName: movement.ARS, Destination: "bank", Status: tc.From, Reference: reference, UpdatedAt: now})
+		require.NoError(t, err)
+		var movements ledger
+		processor := NewProcessor(store, tc.Payout, &movements, testCurrencies)
+		processor.now = func() time.Time { return now }
+
+		// When
+		err = processor.Process(context.Background())
+
+		// Then
+		require.NoError(t, err, tc.TestName)
+		w, err := store.Get(context.Background(), "user", id)
+		require.NoError(t, err, tc.TestName)
+		require.Equal(t, tc.ExpectedStatus, w.Status, tc.TestName)
+		if tc.ExpectedAccount == "" {
+			require.Empty(t, movements.saved, tc.TestName)
+			continue
+		}
+
+		require.Len(t, movements.saved, 1, tc.TestName)
+		require.Equal(t, tc.ExpectedAccount, movements.saved[0].Alias, tc.TestName)
+		require.Equal(t, tc.ExpectedTo, movements.saved[0].InteractionAlias, tc.TestName)
+		require.Equal(t, "100.00", movements.saved[0].Amount.String(), tc.TestName)
+	}
+}
+
+func TestProcessor_Process_When_PayoutFails_Then_IsSentAgain(t *testing.T) {
+	// Given
+	now := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	store := NewMemory()
+	id, err := store.Create(context.Background(), Withdrawal{Alias: "user", Amount: money.MustParse("100"),
+		CurrencyName: movement.ARS, Destination: "bank", Status: StatusPending, UpdatedAt: now})
+	require.NoError(t, err)
+	var movements ledger
+	processor := NewProcessor(store, payout{sendError: errors.New("timeout")}, &movements, testCurrencies)
+	processor.now = func() time.Time { return now }
+
+	// When
+	err = processor.Process(context.Background())
+
+	// Then
+	require.EqualError(t, err, fmt.Sprintf("withdrawal %d: timeout", id))
+	w, err := store.Get(context.Background(), "user", id)
+	require.NoError(t, err)
+	require.Equal(t, StatusProcessing, w.Status)
+	require.Empty(t, w.Reference)
+	require.Empty(t, movements.saved)
+
+	// When
+	processor.payout = payout{status: StatusSettled}
+	err = processor.Process(context.Background())
+
+	// Then
+	require.NoError(t, err)
+	w, err = store.Get(context.Background(), "user", id)
+	require.NoError(t, err)
+	require.Equal(t, StatusProcessing, w.Status)
+	require.Equal(t, "ref", w.Reference)
+	require.Empty(t, movements.saved)
+}
+
+func TestProcessor_Process_When_OnePayoutFails_Then_TheOthersAreProcessed(t *testing.T) {
+	// Given
+	now := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	store := NewMemory()
+	stuck, err := store.Create(context.Background(), Withdrawal{Alias: "user", Amount: money.MustParse("100"),
+		CurrencyName: movement.ARS, Destination: "bank", Status: StatusPending, UpdatedAt: now})
+	require.NoError(t, err)
+	other, err := store.Create(context.Background(), Withdrawal{Alias: "user", Amount: money.MustParse("50"),
+		CurrencyName: movement.ARS, Destination: "bank", Status: StatusPending, UpdatedAt: now})
+	require.NoError(t, err)
+	var movements ledger
+	processor := NewProcessor(store, stuckPayout{stuck: stuck}, &movements, testCurrencies)
+	processor.now = func() time.Time { return now }
+
+	// When
+	err = processor.Process(context.Background())
+
+	// Then
+	require.EqualError(t, err, fmt.Sprintf("withdrawal %d: timeout", stuck))
+	w, err := store.Get(context.Background(), "user", stuck)
+	require.NoError(t, err)
+	require.Equal(t, StatusProcessing, w.Status)
+	w, err = store.Get(context.Background(), "user", other)
+	require.NoError(t, err)
+	require.Equal(t, StatusSettled, w.Status)
+	require.Len(t, movements.saved, 1)
+	require.Equal(t, "50.00", movements.saved[0].Amount.String())
+}
+
+func TestProcessor_Process_When_StatusIsNotSaved_Then_TheRefundIsNotSavedAgain(t *testing.T) {
+	// Given
+	now := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	memory := NewMemory()
+	id, err := memory.Create(context.Background(), Withdrawal{Alias: "user", Amount: money.MustParse("100"),
+		CurrencyName: movement.ARS, Destination: "bank", Status: StatusProcessing, Reference: "ref", UpdatedAt: now})
+	require.NoError(t, err)
+	store := &brokenStore{Store: memory, failures: 1}
+	var movements ledger
+	processor := NewProcessor(store, payout{status: StatusFailed}, &movements, testCurrencies)
+	processor.now = func() time.Time { return now }
+
+	// When
+	err = processor.Process(context.Background())
+
+	// Then
+	require.EqualError(t, err, fmt.Sprintf("withdrawal %d: connection lost", id))
+	w, err := store.Get(context.Background(), "user", id)
+	require.NoError(t, err)
+	require.Equal(t, StatusProcessing, w.Status)
+	require.Len(t, movements.saved, 1)
+	require.Equal(t, fmt.Sprintf("withdrawal:%d:failed", id), movements.saved[0].Reference)
+
+	// When
+	err = processor.Process(context.Background())
+
+	// Then
+	require.NoError(t, err)
+	w, err = store.Get(context.Background(), "user", id)
+	require.NoError(t, err)
+	require.Equal(t, StatusFailed, w.Status)
+	require.Len(t, movements.saved, 1)
+}
+
+func TestProcessor_Process_When_SettledBeforeTheReversalWindow_Then_IsNotChecked(t *testing.T) {
+	// Given
+	now := time.Date(2022, 3, 10, 10, 0, 0, 0, time.UTC)
+	store := NewMemory()
+	id, err := store.Create(context.Background(), Withdrawal{Alias: "user", Amount: money.MustParse("100"),
+		CurrencyName: movement.ARS, Status: StatusSettled, UpdatedAt: now.Add(-ReversalWindow - time.Second)})
+	require.NoError(t, err)
+	var movements ledger
+	processor := NewProcessor(store, payout{status: StatusReversed}, &movements, testCurrencies)
+	processor.now = func() time.Time { return now }
+
+	// When
+	err = processor.Process(context.Background())
+
+	// Then
+	require.NoError(t, err)
+	w, err := store.Get(context.Background(), "user", id)
+	require.NoError(t, err)
+	require.Equal(t, StatusSettled, w.Status)
+	require.Empty(t, movements.saved)
+}
+
+func TestFakePayout(t *testing.T) {
+	// When
+	reference, err := FakePayout{}.Send(context.Background(), Withdrawal{ID: 7, Destination: "bank"})
+	_, rejected := FakePayout{}.Send(context.Background(), Withdrawal{ID: 8, Destination: "fail-bank"})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "fake-7", reference)
+	require.Equal(t, ErrorPayoutRejected, rejected)
+}
@@ -0,0 +1,103 @@
+package withdrawal
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type repository struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *repository {
+	return &repository{db: db}
+}
+
+const selectWithdrawal = "SELECT id,alias,currency_name,amount,destination,status,reference,reason,entry_id,created_at,updated_at " +
+	"FROM withdrawals "
+
+// Create inserts a new withdrawal
+func (r repository) Create(ctx context.Context, w Withdrawal) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "INSERT INTO withdrawals(alias,currency_name,amount,destination,status,reference,"+
+		"reason,entry_id,created_at,updated_at) VALUES(?,?,?,?,?,?,?,?,?,?);",
+		w.Alias, w.CurrencyName, w.Amount, w.Destination, w.Status, w.Reference, w.Reason, w.EntryID, w.CreatedAt, w.UpdatedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// Get returns the withdrawal of the user
+func (r repository) Get(ctx context.Context, alias string, id int64) (Withdrawal, error) {
+	row := r.db.QueryRowContext(ctx, selectWithdrawal+"WHERE id = ? AND alias = ?;", id, alias)
+	w, err := scan(row)
+	if err == sql.ErrNoRows {
+		return Withdrawal{}, ErrorNotFound
+	}
+
+	return w, err
+}
+
+// List returns the withdrawals of the user
+func (r repository) List(ctx context.Context, alias string) ([]Withdrawal, error) {
+	return r.query(ctx, selectWithdrawal+"WHERE alias = ? ORDER BY id DESC;", alias)
+}
+
+// ListByStatus returns the withdrawals in the status updated after the given time
+func (r repository) ListByStatus(ctx context.Context, status Status, since time.Time) ([]Withdrawal, error) {
+	return r.query(ctx, selectWithdrawal+"WHERE status = ? AND updated_at >= ? ORDER BY id;", status, since)
+}
+
+// UpdateStatus saves the new status if the withdrawal is still in the from status
+func (r repository) UpdateStatus(ctx context.Context, w Withdrawal, from Status) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE withdrawals SET status = ?, reference = ?, reason = ?, updated_at = ? "+
+		"WHERE id = ? AND status = ?;", w.Status, w.Reference, w.Reason, w.UpdatedAt, w.ID, from)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrorNotFound
+	}
+
+	return nil
+}
+
+func (r repository) query(ctx context.Context, query string, args ...interface{}) ([]Withdrawal, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var withdrawals []Withdrawal
+	for rows.Next() {
+		w, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		withdrawals = append(withdrawals, w)
+	}
+
+	return withdrawals, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (Withdrawal, error) {
+	var w Withdrawal
+	err := row.Scan(&w.ID, &w.Alias, &w.CurrencyName, &w.Amount, &w.Destination, &w.Status, &w.Reference, &w.Reason,
+		&w.EntryID, &w.CreatedAt, &w.UpdatedAt)
+
+	return w, err
+}
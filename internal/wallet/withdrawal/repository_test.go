@@ -0,0 +1,71 @@
+package withdrawal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/spolia/wallet-api/internal/wallet/movement"
+	"github.com/stretchr/testify/require"
+)
+
+const updateStatusQuery = "UPDATE withdrawals SET status = ?, reference = ?, reason = ?, updated_at = ? WHERE id = ? AND status = ?;"
+
+func TestGet_ok(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	repository := New(db)
+	created := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	// When
+	mock.ExpectQuery(selectWithdrawal+"WHERE id = ? AND alias = ?;").WithArgs(3, "user").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "alias", "currency_name", "amount", "destination", "status",
+			"reference", "reason", "entry_id", "created_at", "updated_at"}).
+			AddRow(3, "user", movement.ARS, "100.500000000000000000", "bank", "processing", "ref", "", 9, created, created))
+
+	// Then
+	w, err := repository.Get(context.Background(), "user", 3)
+	require.NoError(t, err)
+	require.Equal(t, StatusProcessing, w.Status)
+	require.Equal(t, "100.5", w.Amount.String())
+	require.Equal(t, int64(9), w.EntryID)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGet_When_WithdrawalIsOfOtherUser_Then_ReturnsNotFound(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	repository := New(db)
+
+	// When
+	mock.ExpectQuery(selectWithdrawal+"WHERE id = ? AND alias = ?;").WithArgs(3, "other").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	// Then
+	_, err = repository.Get(context.Background(), "other", 3)
+	require.Equal(t, ErrorNotFound, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateStatus_When_StatusChanged_Then_ReturnsNotFound(t *testing.T) {
+	// Given
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	repository := New(db)
+	now := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	// When
+	mock.ExpectExec(updateStatusQuery).WithArgs(StatusProcessing, "", "", now, 3, StatusPending).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Then
+	err = repository.UpdateStatus(context.Background(), Withdrawal{ID: 3, Status: StatusProcessing, UpdatedAt: now}, StatusPending)
+	require.Equal(t, ErrorNotFound, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
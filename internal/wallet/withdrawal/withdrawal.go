@@ -0,0 +1,85 @@
+package withdrawal
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/spolia/wallet-api/internal/wallet/money"
+)
+
+// Status is the state of a withdrawal, a withdrawal starts pending and ends settled, failed or reversed
+type Status string
+
+const (
+	// StatusPending withdrawals hold the money in the withdrawals account until they are sent to the payout provider
+	StatusPending Status = "pending"
+	// StatusProcessing withdrawals were sent to the payout provider
+	StatusProcessing Status = "processing"
+	// StatusSettled withdrawals were paid, the money left the wallet
+	StatusSettled Status = "settled"
+	// StatusFailed withdrawals were rejected by the payout provider, the money was returned to the user
+	StatusFailed Status = "failed"
+	// StatusReversed withdrawals were paid and then returned by the payout provider, the money was returned to the user
+	StatusReversed Status = "reversed"
+)
+
+var (
+	ErrorNotFound       = errors.New("withdrawal: not found")
+	ErrorPayoutRejected = errors.New("withdrawal: payout rejected")
+)
+
+type Withdrawal struct {
+	ID           int64        `json:"id"`
+	Alias        string       `json:"-"`
+	Amount       money.Amount `json:"amount"`
+	CurrencyName string       `json:"currencyname"`
+	// Destination is where the money is paid, like a bank account or a wallet address
+	Destination string `json:"destination"`
+	Status      Status `json:"status"`
+	// Reference is the id of the payout in the payout provider
+	Reference string `json:"reference,omitempty"`
+	// Reason explains why the payout failed
+	Reason string `json:"reason,omitempty"`
+	// EntryID is the movement that holds the money
+	EntryID   int64     `json:"movementid"`
+	CreatedAt time.Time `json:"createdat"`
+	UpdatedAt time.Time `json:"updatedat"`
+}
+
+// InProgress returns true while the withdrawal can still give the money back to the user: until it ends and
+// for the reversal window after it is settled
+func (w Withdrawal) InProgress(at time.Time) bool {
+	switch w.Status {
+	case StatusPending, StatusProcessing:
+		return true
+	case StatusSettled:
+		return !w.UpdatedAt.Before(at.Add(-ReversalWindow))
+	default:
+		return false
+	}
+}
+
+// Store keeps the withdrawals and their status
+type Store interface {
+	// Create inserts a pending withdrawal and returns its id
+	Create(ctx context.Context, w Withdrawal) (int64, error)
+	// Get returns the withdrawal of the user, returns ErrorNotFound if it does not exist
+	Get(ctx context.Context, alias string, id int64) (Withdrawal, error)
+	// List returns the withdrawals of the user, the newest first
+	List(ctx context.Context, alias string) ([]Withdrawal, error)
+	// ListByStatus returns the withdrawals in the status updated after the given time, the oldest first
+	ListByStatus(ctx context.Context, status Status, since time.Time) ([]Withdrawal, error)
+	// UpdateStatus saves the status, the reference and the reason of the withdrawal if its status is still from,
+	// returns ErrorNotFound otherwise so two processors can not move the same withdrawal
+	UpdateStatus(ctx context.Context, w Withdrawal, from Status) error
+}
+
+// Payout pays the withdrawals to their destination
+type Payout interface {
+	// Send asks the provider to pay the withdrawal and returns the reference of the payout,
+	// the provider uses the withdrawal id to not pay a withdrawal twice
+	Send(ctx context.Context, w Withdrawal) (string, error)
+	// Status returns the status of the payout of the withdrawal: processing, settled, failed or reversed
+	Status(ctx context.Context, w Withdrawal) (Status, error)
+}
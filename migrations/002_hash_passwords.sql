@@ -0,0 +1,2 @@
+/*Passwords are stored as bcrypt hashes, the plain text ones are rehashed on the next login*/
+ALTER TABLE `users` MODIFY `password` VARCHAR(255) NOT NULL;
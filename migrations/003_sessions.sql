@@ -0,0 +1,14 @@
+CREATE TABLE `sessions` (
+  `id` VARCHAR(64) NOT NULL,
+  `alias` VARCHAR(45) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  `last_seen_at` DATETIME NOT NULL,
+  `user_agent` VARCHAR(255) NOT NULL,
+  `ip` VARCHAR(45) NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `alias_idx` (`alias` ASC),
+  CONSTRAINT `fk_sessions_alias`
+      FOREIGN KEY (`alias`)
+          REFERENCES `users` (`alias`)
+          ON DELETE CASCADE
+          ON UPDATE CASCADE);
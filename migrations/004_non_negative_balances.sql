@@ -0,0 +1,4 @@
+/*The balance of an account can never be negative, even if a movement skips the funds check*/
+ALTER TABLE `movements_btc` ADD CONSTRAINT `chk_btc_total_amount` CHECK (`total_amount` >= 0);
+ALTER TABLE `movements_usdt` ADD CONSTRAINT `chk_usdt_total_amount` CHECK (`total_amount` >= 0);
+ALTER TABLE `movements_ars` ADD CONSTRAINT `chk_ars_total_amount` CHECK (`total_amount` >= 0);
@@ -0,0 +1,14 @@
+CREATE TABLE `idempotency_keys` (
+  `alias` VARCHAR(45) NOT NULL,
+  `idempotency_key` VARCHAR(255) NOT NULL,
+  `request_hash` CHAR(64) NOT NULL,
+  `status_code` INT NULL,
+  `response_body` BLOB NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`alias`, `idempotency_key`),
+  INDEX `created_at_idx` (`created_at` ASC),
+  CONSTRAINT `fk_idempotency_alias`
+      FOREIGN KEY (`alias`)
+          REFERENCES `users` (`alias`)
+          ON DELETE CASCADE
+          ON UPDATE CASCADE);
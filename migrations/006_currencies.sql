@@ -0,0 +1,14 @@
+/*The currencies the wallet operates with, they are loaded when the api starts.
+Their movements are saved in the `movements` table with their `currency_name`*/
+CREATE TABLE `currencies` (
+  `code` VARCHAR(10) NOT NULL,
+  `name` VARCHAR(45) NOT NULL,
+  `digits` TINYINT NOT NULL,
+  `enabled` BOOLEAN NOT NULL DEFAULT TRUE,
+  PRIMARY KEY (`code`),
+  CONSTRAINT `chk_currencies_digits` CHECK (`digits` BETWEEN 0 AND 18));
+
+INSERT INTO `currencies`(`code`,`name`,`digits`) VALUES
+  ('ARS', 'Argentine peso', 2),
+  ('BTC', 'Bitcoin', 8),
+  ('USDT', 'Tether', 2);
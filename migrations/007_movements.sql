@@ -0,0 +1,51 @@
+/*Every currency shares the movements table, the amounts have the maximum number of decimals of a currency
+and the api keeps the decimals of each currency*/
+CREATE TABLE `movements` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT,
+  `mov_type` ENUM("deposit", "send","receive","init") NOT NULL,
+  `currency_name` VARCHAR(10) NOT NULL,
+  `date_created` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `tx_amount` DECIMAL(36,18) NOT NULL,
+  `total_amount` DECIMAL(36,18) NOT NULL,
+  `interaction_alias` VARCHAR(45) NOT NULL,
+  `alias` VARCHAR(45) NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `alias_currency_idx` (`alias` ASC, `currency_name` ASC, `id` ASC),
+  INDEX `interaction_alias_idx` (`interaction_alias` ASC),
+  CONSTRAINT `chk_movements_total_amount` CHECK (`total_amount` >= 0),
+  CONSTRAINT `fk_movements_currency`
+    FOREIGN KEY (`currency_name`)
+    REFERENCES `currencies` (`code`),
+  CONSTRAINT `fk_movements_alias`
+    FOREIGN KEY (`alias`)
+    REFERENCES `users` (`alias`)
+    ON DELETE CASCADE
+    ON UPDATE CASCADE);
+
+/*The rows are copied before the trigger exists so their totals are kept as they are*/
+INSERT INTO `movements`(`mov_type`,`currency_name`,`date_created`,`tx_amount`,`total_amount`,`interaction_alias`,`alias`)
+SELECT `mov_type`,'ARS',`date_created`,`tx_amount`,`total_amount`,`interaction_alias`,`alias` FROM `movements_ars` ORDER BY `id`;
+INSERT INTO `movements`(`mov_type`,`currency_name`,`date_created`,`tx_amount`,`total_amount`,`interaction_alias`,`alias`)
+SELECT `mov_type`,'BTC',`date_created`,`tx_amount`,`total_amount`,`interaction_alias`,`alias` FROM `movements_btc` ORDER BY `id`;
+INSERT INTO `movements`(`mov_type`,`currency_name`,`date_created`,`tx_amount`,`total_amount`,`interaction_alias`,`alias`)
+SELECT `mov_type`,'USDT',`date_created`,`tx_amount`,`total_amount`,`interaction_alias`,`alias` FROM `movements_usdt` ORDER BY `id`;
+
+DROP TABLE `movements_ars`;
+DROP TABLE `movements_btc`;
+DROP TABLE `movements_usdt`;
+
+DROP TRIGGER IF EXISTS `movements_BEFORE_INSERT`;
+DELIMITER $$
+CREATE TRIGGER movements_BEFORE_INSERT BEFORE INSERT ON movements FOR EACH ROW
+BEGIN
+IF NEW.mov_type='deposit' || NEW.mov_type='receive' THEN
+		SET NEW.total_amount = NEW.tx_amount + COALESCE(
+		(SELECT total_amount FROM movements WHERE alias = NEW.alias AND currency_name = NEW.currency_name ORDER BY id DESC LIMIT 1), 0);
+END IF;
+IF NEW.mov_type='send' THEN
+		SET NEW.total_amount = COALESCE(
+		(SELECT total_amount FROM movements WHERE alias = NEW.alias AND currency_name = NEW.currency_name ORDER BY id DESC LIMIT 1), 0)
+        - NEW.tx_amount;
+END IF;
+END$$
+DELIMITER ;
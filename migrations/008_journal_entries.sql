@@ -0,0 +1,21 @@
+/*Every transfer is a journal entry, its postings are the movements rows with the entry id.
+The movements copied from the old tables have no entry*/
+CREATE TABLE `journal_entries` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT,
+  `entry_type` VARCHAR(20) NOT NULL,
+  `date_created` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`));
+
+ALTER TABLE `movements`
+  ADD COLUMN `entry_id` BIGINT NULL AFTER `id`,
+  ADD INDEX `entry_idx` (`entry_id` ASC),
+  ADD CONSTRAINT `fk_movements_entry` FOREIGN KEY (`entry_id`) REFERENCES `journal_entries` (`id`);
+
+/*The system accounts are the counterparty of the money that enters or leaves the wallet, their balance can be negative*/
+ALTER TABLE `movements` DROP CHECK `chk_movements_total_amount`;
+ALTER TABLE `movements` ADD CONSTRAINT `chk_movements_total_amount` CHECK (`total_amount` >= 0 OR `alias` LIKE '@%');
+
+/*The password is not a valid bcrypt hash, so nobody can log in with a system account*/
+INSERT INTO `users`(`alias`,`first_name`,`last_name`,`email`,`password`) VALUES
+  ('@external', 'External', 'System account', 'external@system.invalid', '$2a$10$*****************************************************'),
+  ('@fees', 'Fees', 'System account', 'fees@system.invalid', '$2a$10$*****************************************************');
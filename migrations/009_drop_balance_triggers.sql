@@ -0,0 +1,5 @@
+/*The running balance of every movement is computed by the api while the accounts are locked*/
+DROP TRIGGER IF EXISTS `movements_BEFORE_INSERT`;
+DROP TRIGGER IF EXISTS `movements_usdt_BEFORE_INSERT`;
+DROP TRIGGER IF EXISTS `movements_btc_BEFORE_INSERT`;
+DROP TRIGGER IF EXISTS `movements_ars_BEFORE_INSERT`;
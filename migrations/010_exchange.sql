@@ -0,0 +1,25 @@
+/*New movement types are added without changing the table*/
+ALTER TABLE `movements` MODIFY `mov_type` VARCHAR(20) NOT NULL;
+
+INSERT INTO `users`(`alias`,`first_name`,`last_name`,`email`,`password`) VALUES
+  ('@exchange', 'Exchange', 'System account', 'exchange@system.invalid', '$2a$10$*****************************************************');
+
+/*The quotes are kept until they expire, used_at is set when the user confirms the exchange*/
+CREATE TABLE `exchange_quotes` (
+  `id` VARCHAR(64) NOT NULL,
+  `alias` VARCHAR(45) NOT NULL,
+  `currency_name` VARCHAR(10) NOT NULL,
+  `amount` DECIMAL(36,18) NOT NULL,
+  `destination_currency` VARCHAR(10) NOT NULL,
+  `destination_amount` DECIMAL(36,18) NOT NULL,
+  `rate` DECIMAL(36,18) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  `expires_at` DATETIME NOT NULL,
+  `used_at` DATETIME NULL,
+  PRIMARY KEY (`id`),
+  INDEX `expires_at_idx` (`expires_at` ASC),
+  CONSTRAINT `fk_exchange_quotes_alias`
+      FOREIGN KEY (`alias`)
+          REFERENCES `users` (`alias`)
+          ON DELETE CASCADE
+          ON UPDATE CASCADE);
@@ -0,0 +1,6 @@
+/*The rate and the spread of the entries that convert money, the spread is in the destination currency*/
+ALTER TABLE `journal_entries`
+  ADD COLUMN `rate` DECIMAL(36,18) NULL,
+  ADD COLUMN `spread` DECIMAL(36,18) NULL;
+
+ALTER TABLE `exchange_quotes` ADD COLUMN `spread` DECIMAL(36,18) NOT NULL DEFAULT 0 AFTER `rate`;
@@ -0,0 +1,27 @@
+INSERT INTO `users`(`alias`,`first_name`,`last_name`,`email`,`password`) VALUES
+  ('@withdrawals', 'Withdrawals', 'System account', 'withdrawals@system.invalid', '$2a$10$*****************************************************');
+
+/*The money of a withdrawal is kept in the @withdrawals account until the payout is settled or refunded*/
+CREATE TABLE `withdrawals` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT,
+  `alias` VARCHAR(45) NOT NULL,
+  `currency_name` VARCHAR(10) NOT NULL,
+  `amount` DECIMAL(36,18) NOT NULL,
+  `destination` VARCHAR(255) NOT NULL,
+  `status` VARCHAR(20) NOT NULL,
+  `reference` VARCHAR(255) NOT NULL DEFAULT '',
+  `reason` VARCHAR(255) NOT NULL DEFAULT '',
+  `entry_id` BIGINT NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  `updated_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `alias_idx` (`alias` ASC),
+  INDEX `status_idx` (`status` ASC, `updated_at` ASC),
+  CONSTRAINT `fk_withdrawals_alias`
+      FOREIGN KEY (`alias`)
+          REFERENCES `users` (`alias`)
+          ON DELETE CASCADE
+          ON UPDATE CASCADE,
+  CONSTRAINT `fk_withdrawals_entry`
+      FOREIGN KEY (`entry_id`)
+          REFERENCES `journal_entries` (`id`));
@@ -0,0 +1,24 @@
+/*A hold reserves money of the user without moving it, the active holds are subtracted from the available balance*/
+CREATE TABLE `holds` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT,
+  `alias` VARCHAR(45) NOT NULL,
+  `interaction_alias` VARCHAR(45) NOT NULL,
+  `currency_name` VARCHAR(10) NOT NULL,
+  `amount` DECIMAL(36,18) NOT NULL,
+  `captured_amount` DECIMAL(36,18) NOT NULL DEFAULT 0,
+  `status` VARCHAR(20) NOT NULL,
+  `expires_at` DATETIME NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `alias_idx` (`alias` ASC, `status` ASC, `currency_name` ASC),
+  INDEX `status_idx` (`status` ASC, `expires_at` ASC),
+  CONSTRAINT `fk_holds_alias`
+      FOREIGN KEY (`alias`)
+          REFERENCES `users` (`alias`)
+          ON DELETE CASCADE
+          ON UPDATE CASCADE,
+  CONSTRAINT `fk_holds_interaction_alias`
+      FOREIGN KEY (`interaction_alias`)
+          REFERENCES `users` (`alias`)
+          ON DELETE CASCADE
+          ON UPDATE CASCADE);
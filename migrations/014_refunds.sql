@@ -0,0 +1,5 @@
+/*A refund is a send entry that gives back money of a previous send, it references the refunded entry*/
+ALTER TABLE `journal_entries`
+  ADD COLUMN `refund_of` BIGINT NULL,
+  ADD INDEX `refund_of_idx` (`refund_of` ASC),
+  ADD CONSTRAINT `fk_journal_entries_refund_of` FOREIGN KEY (`refund_of`) REFERENCES `journal_entries` (`id`);
@@ -0,0 +1,7 @@
+/*The history is read by pages sorted by id, every filter of the history has an index that ends with the id
+so the rows of a page are read in order*/
+ALTER TABLE `movements`
+  ADD INDEX `alias_id_idx` (`alias` ASC, `id` ASC),
+  ADD INDEX `alias_type_idx` (`alias` ASC, `mov_type` ASC, `id` ASC),
+  ADD INDEX `alias_interaction_alias_idx` (`alias` ASC, `interaction_alias` ASC, `id` ASC),
+  ADD INDEX `alias_date_created_idx` (`alias` ASC, `date_created` ASC, `id` ASC);
@@ -0,0 +1,37 @@
+/*The statements of a closed month are saved with their movements the first time they are requested and never change*/
+CREATE TABLE `statements` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT,
+  `alias` VARCHAR(45) NOT NULL,
+  `month` CHAR(7) NOT NULL,
+  `currency_name` VARCHAR(10) NOT NULL,
+  `opening_balance` DECIMAL(36,18) NOT NULL,
+  `total_in` DECIMAL(36,18) NOT NULL,
+  `total_out` DECIMAL(36,18) NOT NULL,
+  `closing_balance` DECIMAL(36,18) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `alias_month_currency_idx` (`alias` ASC, `month` ASC, `currency_name` ASC),
+  CONSTRAINT `fk_statements_currency`
+      FOREIGN KEY (`currency_name`)
+          REFERENCES `currencies` (`code`),
+  CONSTRAINT `fk_statements_alias`
+      FOREIGN KEY (`alias`)
+          REFERENCES `users` (`alias`)
+          ON DELETE CASCADE
+          ON UPDATE CASCADE);
+
+CREATE TABLE `statement_lines` (
+  `statement_id` BIGINT NOT NULL,
+  `line` INT NOT NULL,
+  `entry_id` BIGINT NULL,
+  `mov_type` VARCHAR(20) NOT NULL,
+  `interaction_alias` VARCHAR(45) NOT NULL,
+  `amount` DECIMAL(36,18) NOT NULL,
+  `total_amount` DECIMAL(36,18) NOT NULL,
+  `refund_of` BIGINT NULL,
+  `date_created` DATETIME NOT NULL,
+  PRIMARY KEY (`statement_id`, `line`),
+  CONSTRAINT `fk_statement_lines_statement`
+      FOREIGN KEY (`statement_id`)
+          REFERENCES `statements` (`id`)
+          ON DELETE CASCADE);
@@ -0,0 +1,31 @@
+/*A closed account keeps its user row, so its alias and email can not be taken and its movements stay in the history
+of the users it interacted with*/
+ALTER TABLE `users` ADD COLUMN `closed_at` DATETIME NULL;
+
+/*Deleting a user used to delete its movements and holds, including the halves of the entries of other users.
+The users with movements, holds or withdrawals can not be deleted anymore*/
+ALTER TABLE `movements` DROP FOREIGN KEY `fk_movements_alias`;
+ALTER TABLE `movements` ADD CONSTRAINT `fk_movements_alias`
+    FOREIGN KEY (`alias`)
+        REFERENCES `users` (`alias`)
+        ON DELETE RESTRICT
+        ON UPDATE CASCADE;
+
+ALTER TABLE `holds` DROP FOREIGN KEY `fk_holds_alias`, DROP FOREIGN KEY `fk_holds_interaction_alias`;
+ALTER TABLE `holds` ADD CONSTRAINT `fk_holds_alias`
+    FOREIGN KEY (`alias`)
+        REFERENCES `users` (`alias`)
+        ON DELETE RESTRICT
+        ON UPDATE CASCADE,
+    ADD CONSTRAINT `fk_holds_interaction_alias`
+    FOREIGN KEY (`interaction_alias`)
+        REFERENCES `users` (`alias`)
+        ON DELETE RESTRICT
+        ON UPDATE CASCADE;
+
+ALTER TABLE `withdrawals` DROP FOREIGN KEY `fk_withdrawals_alias`;
+ALTER TABLE `withdrawals` ADD CONSTRAINT `fk_withdrawals_alias`
+    FOREIGN KEY (`alias`)
+        REFERENCES `users` (`alias`)
+        ON DELETE RESTRICT
+        ON UPDATE CASCADE;
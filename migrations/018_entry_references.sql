@@ -0,0 +1,5 @@
+/*The reference identifies the entries that must be saved once, like the payout or the refund of a withdrawal,
+so an entry saved again after an error is rejected instead of moving the money twice*/
+ALTER TABLE `journal_entries`
+  ADD COLUMN `reference` VARCHAR(255) NULL,
+  ADD UNIQUE INDEX `reference_idx` (`reference` ASC);
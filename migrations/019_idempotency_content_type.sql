@@ -0,0 +1,2 @@
+/*The content type of the saved response is returned with it when the request is repeated*/
+ALTER TABLE `idempotency_keys` ADD COLUMN `content_type` VARCHAR(255) NOT NULL DEFAULT '';
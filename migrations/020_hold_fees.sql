@@ -0,0 +1,3 @@
+/*The fee of the send of a hold is computed when the hold is created and held with its amount, so capturing
+all the held money does not need more money than the hold reserved*/
+ALTER TABLE `holds` ADD COLUMN `fee` DECIMAL(36,18) NOT NULL DEFAULT 0 AFTER `amount`;
@@ -0,0 +1,9 @@
+/*The lines of the statements keep the id of their movements row like the history and the exports, the lines saved
+before are filled from the movements of their entries. The rows saved before the journal entries stay without id*/
+ALTER TABLE `statement_lines` ADD COLUMN `movement_id` BIGINT NULL AFTER `line`;
+
+UPDATE `statement_lines` l
+  JOIN `statements` s ON s.`id` = l.`statement_id`
+  JOIN `movements` m ON m.`entry_id` = l.`entry_id` AND m.`alias` = s.`alias` AND m.`currency_name` = s.`currency_name`
+    AND m.`mov_type` = l.`mov_type`
+SET l.`movement_id` = m.`id`;